@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/ClearThree/gophermart-bonus/internal/app/server"
 	"github.com/caarlos0/env/v6"
 	"log"
@@ -15,7 +17,24 @@ func main() {
 		fmt.Println("parsing env variables was not successful: ", err)
 	}
 	config.Settings.Sanitize()
-	if err = server.Run(config.Settings.Address); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	if err = money.Configure(config.Settings.MoneyScale, config.Settings.MoneyRounding); err != nil {
+		log.Fatalf("Invalid money settings: %v", err)
+	}
+
+	switch command := flag.Arg(0); command {
+	case "":
+		if err = server.Run(config.Settings.Address); err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	case "reconcile":
+		if err = runReconcile(flag.Args()[1:]); err != nil {
+			log.Fatalf("Reconciliation failed: %v", err)
+		}
+	case "statements":
+		if err = runStatements(flag.Args()[1:]); err != nil {
+			log.Fatalf("Statements export failed: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command %q", command)
 	}
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/server"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"os"
+)
+
+// runReconcile выполняет разовую сверку балансов: gophermart -d <dsn> reconcile [-repair]
+func runReconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "write correcting adjustments for found discrepancies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	pool, err := server.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer func(pool *sql.DB) {
+		innerErr := pool.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing pool: %v", innerErr)
+		}
+	}(pool)
+
+	reconciliationService := service.NewReconciliationService(repositories.NewReconciliationRepository(pool))
+	report, err := reconciliationService.Reconcile(context.Background(), *repair)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/server"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runStatements пишет выписки всех активных пользователей за месяц в файлы, по одной на кошелёк:
+// gophermart -d <dsn> statements [-month YYYY-MM] [-format json,csv] [-wallets bonus,partner] [-out dir]
+// Выписки по кошелькам, кроме основного, пишутся только для пользователей, у которых в них что-то было.
+func runStatements(args []string) error {
+	flags := flag.NewFlagSet("statements", flag.ExitOnError)
+	month := flags.String("month", "", "statement month in YYYY-MM format, previous month by default")
+	formatsFlag := flags.String("format", "json,csv", "comma separated output formats: json, csv")
+	walletsFlag := flags.String("wallets", "", "comma separated wallets, all configured wallets by default")
+	outDir := flags.String("out", "statements", "directory to write statement files to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	from, to, err := service.MonthPeriod(*month, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("invalid month %q: %w", *month, err)
+	}
+	formats := strings.Split(*formatsFlag, ",")
+	for _, format := range formats {
+		if format != service.StatementFormatJSON && format != service.StatementFormatCSV {
+			return fmt.Errorf("%w: %s", service.ErrUnknownStatementFormat, format)
+		}
+	}
+	walletChecker := validators.NewWalletCheckerFromConfig(&config.Settings, repositories.DefaultWallet)
+	wallets := walletChecker.Wallets()
+	if *walletsFlag != "" {
+		wallets = nil
+		for _, wallet := range strings.Split(*walletsFlag, ",") {
+			checked, checkErr := walletChecker.Check(wallet)
+			if checkErr != nil {
+				return checkErr
+			}
+			wallets = append(wallets, checked)
+		}
+	}
+	if err = os.MkdirAll(*outDir, 0o750); err != nil {
+		return err
+	}
+
+	pool, err := server.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer func(pool *sql.DB) {
+		innerErr := pool.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing pool: %v", innerErr)
+		}
+	}(pool)
+
+	statementService := service.NewStatementService(
+		repositories.NewUserRepository(pool), repositories.NewBalanceHistoryRepository(pool))
+	written := 0
+	consume := func(statement service.Statement) error {
+		for _, format := range formats {
+			name := statementFileName(statement, from, format)
+			if writeErr := writeStatementFile(filepath.Join(*outDir, name), statement, format); writeErr != nil {
+				return writeErr
+			}
+			written++
+		}
+		return nil
+	}
+	err = statementService.GenerateAll(context.Background(), wallets, from, to, consume)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("Written %d statement files to %s", written, *outDir)
+	return nil
+}
+
+// statementFileName оставляет прежнее имя для основного кошелька и добавляет имя кошелька для остальных.
+func statementFileName(statement service.Statement, from time.Time, format string) string {
+	if statement.Wallet == repositories.DefaultWallet {
+		return fmt.Sprintf("statement_%d_%s.%s", statement.UserID, from.Format("2006-01"), format)
+	}
+	return fmt.Sprintf("statement_%d_%s_%s.%s", statement.UserID, statement.Wallet, from.Format("2006-01"), format)
+}
+
+func writeStatementFile(path string, statement service.Statement, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(service.WriteStatement(file, statement, format), file.Close())
+}
@@ -11,7 +11,6 @@ require (
 	github.com/jackc/pgx/v5 v5.7.4
 	github.com/pressly/goose v2.7.0+incompatible
 	github.com/stretchr/testify v1.10.0
-	github.com/theplant/luhn v0.0.0-20170224032821-81a1a381387a
 	go.uber.org/zap v1.27.0
 	golang.org/x/crypto v0.38.0
 )
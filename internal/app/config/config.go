@@ -16,10 +16,70 @@ type Config struct {
 	LogLevel                  string        `env:"LOG_LEVEL" envDefault:"INFO"`
 	DatabaseURI               string        `env:"DATABASE_URI"`
 	SecretKey                 string        `env:"SECRET_KEY" envDefault:"DontUseThatInProduction"`
-	JWTExpireHours            int64         `env:"JWT_EXPIRE_HOURS" envDefault:"96"`
 	DefaultChannelsBufferSize int64         `env:"DEFAULT_CHANNELS_BUFFER_SIZE" envDefault:"1024"`
 	WorkersNumber             int64         `env:"WORKERS_NUMBER" envDefault:"16"`
 	OrderStatusCheckPeriod    time.Duration `env:"ORDER_STATUS_CHECK_PERIOD" envDefault:"1s"`
+	OrderNumberValidators     []string      `env:"ORDER_NUMBER_VALIDATORS" envDefault:"luhn" envSeparator:","`
+	OrderNumberMinLength      int           `env:"ORDER_NUMBER_MIN_LENGTH" envDefault:"1"`
+	OrderNumberMaxLength      int           `env:"ORDER_NUMBER_MAX_LENGTH" envDefault:"64"`
+	OrderNumberCharset        string        `env:"ORDER_NUMBER_CHARSET" envDefault:"0123456789"`
+	OrderNumberPattern        string        `env:"ORDER_NUMBER_PATTERN" envDefault:"^.*$"`
+	OrderNumberSeparators     string        `env:"ORDER_NUMBER_SEPARATORS" envDefault:" -"`
+	// Действие при срабатывании правил загрузки заказов: reject (429) или flag (пометить для проверки)
+	OrderRulesAction                string  `env:"ORDER_RULES_ACTION" envDefault:"reject"`
+	OrderRulesMaxPerHour            int64   `env:"ORDER_RULES_MAX_PER_HOUR" envDefault:"0"`
+	OrderRulesMaxPerDay             int64   `env:"ORDER_RULES_MAX_PER_DAY" envDefault:"0"`
+	OrderRulesMaxInvalidShare       float64 `env:"ORDER_RULES_MAX_INVALID_SHARE" envDefault:"0"`
+	OrderRulesInvalidShareMinOrders int64   `env:"ORDER_RULES_INVALID_SHARE_MIN_ORDERS" envDefault:"10"`
+	OrderRulesMaxDuplicateAttempts  int64   `env:"ORDER_RULES_MAX_DUPLICATE_ATTEMPTS" envDefault:"0"`
+	// Точность сумм баллов (знаков после запятой) и режим округления: half_even, half_up, down, up
+	MoneyScale    int    `env:"MONEY_SCALE" envDefault:"2"`
+	MoneyRounding string `env:"MONEY_ROUNDING" envDefault:"half_even"`
+	// Период фоновой сверки балансов, 0 выключает сверку
+	ReconciliationPeriod time.Duration `env:"RECONCILIATION_PERIOD" envDefault:"0s"`
+	ReconciliationRepair bool          `env:"RECONCILIATION_REPAIR" envDefault:"false"`
+	// Срок жизни начисленных баллов в месяцах (0 — не сгорают) и период проверки сгорания
+	PointsTTLMonths             int           `env:"POINTS_TTL_MONTHS" envDefault:"12"`
+	PointsExpirationCheckPeriod time.Duration `env:"POINTS_EXPIRATION_CHECK_PERIOD" envDefault:"24h"`
+	// Время жизни резерва баллов под списание и период снятия просроченных резервов
+	HoldTTL                   time.Duration `env:"HOLD_TTL" envDefault:"15m"`
+	HoldExpirationCheckPeriod time.Duration `env:"HOLD_EXPIRATION_CHECK_PERIOD" envDefault:"1m"`
+	// Ограничения переводов баллов между пользователями, суммы в баллах, пустое значение или 0 — без ограничения
+	TransferMinAmount   string `env:"TRANSFER_MIN_AMOUNT" envDefault:"0"`
+	TransferMaxAmount   string `env:"TRANSFER_MAX_AMOUNT" envDefault:"0"`
+	TransferDailyAmount string `env:"TRANSFER_DAILY_AMOUNT" envDefault:"0"`
+	// Сколько хранится ответ для Idempotency-Key, как часто удаляются устаревшие ключи
+	// и через сколько незавершённый запрос перестаёт занимать ключ
+	IdempotencyKeyTTL        time.Duration `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+	IdempotencyCleanupPeriod time.Duration `env:"IDEMPOTENCY_CLEANUP_PERIOD" envDefault:"1h"`
+	IdempotencyKeyLease      time.Duration `env:"IDEMPOTENCY_KEY_LEASE" envDefault:"1m"`
+	// Политика списаний по умолчанию, суммы в баллах, 0 — без ограничения. Доля заказа задаётся дробью, например 0.5
+	WithdrawalMinAmount     string `env:"WITHDRAWAL_MIN_AMOUNT" envDefault:"0"`
+	WithdrawalMaxAmount     string `env:"WITHDRAWAL_MAX_AMOUNT" envDefault:"0"`
+	WithdrawalDailyCap      string `env:"WITHDRAWAL_DAILY_CAP" envDefault:"0"`
+	WithdrawalMonthlyCap    string `env:"WITHDRAWAL_MONTHLY_CAP" envDefault:"0"`
+	WithdrawalMaxOrderShare string `env:"WITHDRAWAL_MAX_ORDER_SHARE" envDefault:"0"`
+	// Переопределения политики по уровням пользователей в JSON:
+	// {"gold": {"max_amount": "5000", "daily_cap": "10000", "max_order_share": "0.8"}}
+	WithdrawalTierPolicies string `env:"WITHDRAWAL_TIER_POLICIES" envDefault:""`
+	// Уровни лояльности в виде имя:порог:множитель по возрастанию порога, порог — начисления за окно в месяцах.
+	// Пользователи ниже первого порога получают базовый уровень standard с множителем 1
+	LoyaltyTiers                   []string      `env:"LOYALTY_TIERS" envDefault:"silver:1000:1.1,gold:5000:1.25,platinum:20000:1.5" envSeparator:","`
+	LoyaltyTierWindowMonths        int           `env:"LOYALTY_TIER_WINDOW_MONTHS" envDefault:"12"`
+	LoyaltyTierRecalculationPeriod time.Duration `env:"LOYALTY_TIER_RECALCULATION_PERIOD" envDefault:"24h"`
+	// Бонусы пригласившему и приглашённому за первый обработанный заказ приглашённого, 0 отключает бонус
+	ReferralReferrerBonus string `env:"REFERRAL_REFERRER_BONUS" envDefault:"100"`
+	ReferralRefereeBonus  string `env:"REFERRAL_REFEREE_BONUS" envDefault:"50"`
+	// Сколько бонусов пригласивший может получить за сутки, 0 — без ограничения
+	ReferralReferrerDailyCap int64 `env:"REFERRAL_REFERRER_DAILY_CAP" envDefault:"10"`
+	// Кошельки баллов, в которые можно начислять и из которых можно списывать. Основной кошелёк bonus есть всегда
+	Wallets []string `env:"WALLETS" envDefault:"bonus" envSeparator:","`
+	// Время жизни access-токена и сессии (refresh-токена), сколько кешируется проверка отзыва сессии
+	// и как часто удаляются истёкшие и отозванные сессии
+	AccessTokenTTL       time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m"`
+	RefreshTokenTTL      time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	SessionCacheTTL      time.Duration `env:"SESSION_CACHE_TTL" envDefault:"10s"`
+	SessionCleanupPeriod time.Duration `env:"SESSION_CLEANUP_PERIOD" envDefault:"1h"`
 }
 
 func (cfg *Config) Sanitize() {
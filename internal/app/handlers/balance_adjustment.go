@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CreateAdjustmentHandler struct {
+	adjustmentService service.BalanceAdjustmentServiceInterface
+}
+
+func NewCreateAdjustmentHandler(adjustmentService service.BalanceAdjustmentServiceInterface) CreateAdjustmentHandler {
+	return CreateAdjustmentHandler{adjustmentService: adjustmentService}
+}
+
+func (create CreateAdjustmentHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(request, "id"), 10, 64)
+	if err != nil {
+		http.Error(writer, "Please provide a valid user id", http.StatusBadRequest)
+		return
+	}
+	if contentType := request.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		logger.Log.Infoln("Inappropriate content type passed")
+		http.Error(writer, "Only application/json content type is allowed", http.StatusBadRequest)
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.CreateAdjustmentRequest
+	dec := json.NewDecoder(request.Body)
+	if err = dec.Decode(&requestData); err != nil {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	adminID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	adjustment, err := create.adjustmentService.Create(request.Context(), repositories.BalanceAdjustment{
+		UserID:     userID,
+		Amount:     requestData.Amount,
+		ReasonCode: requestData.Reason,
+		Comment:    requestData.Comment,
+		Forced:     requestData.Force,
+		CreatedBy:  adminID,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrZeroAdjustment),
+			errors.Is(err, service.ErrAdjustmentCommentRequired):
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, service.ErrUnknownAdjustmentReason):
+			http.Error(writer, "The reason must be one of: "+strings.Join(service.AdjustmentReasonCodes, ", "),
+				http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrUserNotFound):
+			http.Error(writer, "No user found with the given id", http.StatusNotFound)
+			return
+		case errors.Is(err, repositories.ErrNotEnoughPoints):
+			http.Error(writer, "The adjustment would overdraw the balance, pass force to apply it anyway",
+				http.StatusPaymentRequired)
+			return
+		default:
+			logger.Log.Warnf("Couldn't adjust the balance of user %d: %v", userID, err)
+			http.Error(writer, "Couldn't adjust the balance", http.StatusInternalServerError)
+			return
+		}
+	}
+	logger.Log.Infof("Admin %d adjusted the balance of user %d by %s (%s)",
+		adminID, userID, adjustment.Amount, adjustment.ReasonCode)
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(adjustmentResponse(adjustment)); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+type ReadAllAdjustmentsHandler struct {
+	adjustmentService service.BalanceAdjustmentServiceInterface
+}
+
+func NewReadAllAdjustmentsHandler(
+	adjustmentService service.BalanceAdjustmentServiceInterface) ReadAllAdjustmentsHandler {
+	return ReadAllAdjustmentsHandler{adjustmentService: adjustmentService}
+}
+
+func (readAll ReadAllAdjustmentsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(request, "id"), 10, 64)
+	if err != nil {
+		http.Error(writer, "Please provide a valid user id", http.StatusBadRequest)
+		return
+	}
+	adjustments, err := readAll.adjustmentService.ReadAllByUserID(request.Context(), userID)
+	if err != nil {
+		logger.Log.Warnf("Couldn't load adjustments of user %d: %v", userID, err)
+		http.Error(writer, "Couldn't load adjustments", http.StatusInternalServerError)
+		return
+	}
+	if len(adjustments) == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	responseData := make([]models.AdjustmentResponse, len(adjustments))
+	for index, adjustment := range adjustments {
+		responseData[index] = adjustmentResponse(adjustment)
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(responseData); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+func adjustmentResponse(adjustment repositories.BalanceAdjustment) models.AdjustmentResponse {
+	return models.AdjustmentResponse{
+		ID:        adjustment.ID,
+		UserID:    adjustment.UserID,
+		Sum:       adjustment.Amount,
+		Reason:    adjustment.ReasonCode,
+		Comment:   adjustment.Comment,
+		Forced:    adjustment.Forced,
+		CreatedBy: adjustment.CreatedBy,
+		CreatedAt: adjustment.CreatedAt,
+	}
+}
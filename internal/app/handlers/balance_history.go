@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+var errInvalidDate = errors.New("dates must be in RFC 3339 or YYYY-MM-DD format")
+
+type BalanceHistoryHandler struct {
+	balanceHistoryService service.BalanceHistoryServiceInterface
+	walletChecker         *validators.WalletChecker
+}
+
+func NewBalanceHistoryHandler(
+	balanceHistoryService service.BalanceHistoryServiceInterface,
+	walletChecker *validators.WalletChecker) BalanceHistoryHandler {
+	return BalanceHistoryHandler{balanceHistoryService: balanceHistoryService, walletChecker: walletChecker}
+}
+
+// ServeHTTP отдаёт операции по счёту в хронологическом порядке. Параметры: wallet (без него — все кошельки),
+// from, to (включительно для дат без времени, исключительно для RFC 3339), order (asc или desc), limit и offset.
+// Общее число операций в периоде возвращается в заголовке X-Total-Count.
+func (history BalanceHistoryHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	filter, err := parseBalanceHistoryFilter(query)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if wallet := query.Get("wallet"); wallet != "" {
+		if filter.Wallet, err = history.walletChecker.Check(wallet); err != nil {
+			http.Error(writer, "Unknown wallet", http.StatusBadRequest)
+			return
+		}
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	entries, total, err := history.balanceHistoryService.ReadHistory(request.Context(), userID, filter)
+	if err != nil {
+		logger.Log.Warnf("Couldn't load balance history of user %d: %v", userID, err)
+		http.Error(writer, "Couldn't load balance history", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Add("X-Total-Count", strconv.FormatUint(total, 10))
+	if len(entries) == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	responseData := make([]models.BalanceHistoryEntryResponse, len(entries))
+	for index, entry := range entries {
+		responseData[index] = models.BalanceHistoryEntryResponse{
+			Wallet:    entry.Wallet,
+			Kind:      entry.Kind,
+			Reference: entry.Reference,
+			Sum:       entry.Amount,
+			Balance:   entry.Balance,
+			CreatedAt: entry.CreatedAt,
+		}
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(responseData); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+func parseBalanceHistoryFilter(query url.Values) (repositories.BalanceHistoryFilter, error) {
+	var filter repositories.BalanceHistoryFilter
+	var err error
+	if filter.From, err = parseDateParam(query.Get("from"), false); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseDateParam(query.Get("to"), true); err != nil {
+		return filter, err
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("order must be asc or desc")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil {
+			return filter, errors.New("limit must be a non-negative integer")
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if filter.Offset, err = strconv.ParseUint(offset, 10, 64); err != nil {
+			return filter, errors.New("offset must be a non-negative integer")
+		}
+	}
+	return filter, nil
+}
+
+// parseDateParam разбирает RFC 3339 или дату без времени. Для верхней границы дата без времени
+// означает конец этого дня, чтобы to=2025-06-30 включал весь день.
+func parseDateParam(value string, upperBound bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	parsed, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, errInvalidDate
+	}
+	if upperBound {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return parsed, nil
+}
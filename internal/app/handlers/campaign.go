@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CreateCampaignHandler struct {
+	campaignService service.CampaignServiceInterface
+}
+
+func NewCreateCampaignHandler(campaignService service.CampaignServiceInterface) CreateCampaignHandler {
+	return CreateCampaignHandler{campaignService: campaignService}
+}
+
+func (create CreateCampaignHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if contentType := request.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		logger.Log.Infoln("Inappropriate content type passed")
+		http.Error(writer, "Only application/json content type is allowed", http.StatusBadRequest)
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.CreateCampaignRequest
+	dec := json.NewDecoder(request.Body)
+	if err := dec.Decode(&requestData); err != nil {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if requestData.Name == "" {
+		http.Error(writer, "Please provide the campaign name", http.StatusBadRequest)
+		return
+	}
+	campaign := repositories.Campaign{
+		Name:       requestData.Name,
+		StartsAt:   requestData.StartsAt,
+		EndsAt:     requestData.EndsAt,
+		Multiplier: requestData.Multiplier.String(),
+		Tiers:      requestData.Tiers,
+		UserIDs:    requestData.UserIDs,
+		CreatedBy:  request.Context().Value(middlewares.UserIDKey).(uint64),
+	}
+	if requestData.FixedBonus != nil {
+		campaign.FixedBonus = money.NullAmount{Amount: *requestData.FixedBonus, Valid: true}
+	}
+	campaign, err := create.campaignService.Create(request.Context(), campaign)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidCampaignPeriod), errors.Is(err, service.ErrInvalidCampaignReward):
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrCampaignUserNotFound):
+			http.Error(writer, "Some of the listed users do not exist", http.StatusUnprocessableEntity)
+			return
+		default:
+			logger.Log.Warnf("Couldn't create the campaign %q: %v", requestData.Name, err)
+			http.Error(writer, "Couldn't create the campaign", http.StatusInternalServerError)
+			return
+		}
+	}
+	logger.Log.Infof("Admin %d created campaign %d", campaign.CreatedBy, campaign.ID)
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(campaignResponse(campaign)); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+type ReadAllCampaignsHandler struct {
+	campaignService service.CampaignServiceInterface
+}
+
+func NewReadAllCampaignsHandler(campaignService service.CampaignServiceInterface) ReadAllCampaignsHandler {
+	return ReadAllCampaignsHandler{campaignService: campaignService}
+}
+
+func (readAll ReadAllCampaignsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	campaigns, err := readAll.campaignService.ReadAll(request.Context())
+	if err != nil {
+		logger.Log.Warnf("Couldn't load campaigns: %v", err)
+		http.Error(writer, "Couldn't load campaigns", http.StatusInternalServerError)
+		return
+	}
+	if len(campaigns) == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	responseData := make([]models.CampaignResponse, len(campaigns))
+	for index, campaign := range campaigns {
+		responseData[index] = campaignResponse(campaign)
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(responseData); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+type DeactivateCampaignHandler struct {
+	campaignService service.CampaignServiceInterface
+}
+
+func NewDeactivateCampaignHandler(campaignService service.CampaignServiceInterface) DeactivateCampaignHandler {
+	return DeactivateCampaignHandler{campaignService: campaignService}
+}
+
+func (deactivate DeactivateCampaignHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	campaignID, err := strconv.ParseUint(chi.URLParam(request, "id"), 10, 64)
+	if err != nil {
+		http.Error(writer, "Please provide a valid campaign id", http.StatusBadRequest)
+		return
+	}
+	err = deactivate.campaignService.Deactivate(request.Context(), campaignID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrCampaignNotFound) {
+			http.Error(writer, "No campaign found with the given id", http.StatusNotFound)
+			return
+		}
+		logger.Log.Warnf("Couldn't deactivate the campaign %d: %v", campaignID, err)
+		http.Error(writer, "Couldn't deactivate the campaign", http.StatusInternalServerError)
+		return
+	}
+	logger.Log.Infof("Admin %d deactivated campaign %d",
+		request.Context().Value(middlewares.UserIDKey).(uint64), campaignID)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func campaignResponse(campaign repositories.Campaign) models.CampaignResponse {
+	response := models.CampaignResponse{
+		ID:         campaign.ID,
+		Name:       campaign.Name,
+		StartsAt:   campaign.StartsAt,
+		EndsAt:     campaign.EndsAt,
+		Multiplier: campaign.Multiplier,
+		Active:     campaign.Active,
+		Tiers:      campaign.Tiers,
+		UserIDs:    campaign.UserIDs,
+		CreatedBy:  campaign.CreatedBy,
+		CreatedAt:  campaign.CreatedAt,
+	}
+	if campaign.FixedBonus.Valid {
+		fixedBonus := campaign.FixedBonus.Amount
+		response.FixedBonus = &fixedBonus
+	}
+	return response
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+func listETag(prefix string, version repositories.ListVersion) string {
+	hash := fnv.New64a()
+	_, _ = fmt.Fprintf(hash, "%s:%d:%d:%d", prefix, version.Count, version.MaxID, version.LastModified.UnixNano())
+	return fmt.Sprintf(`"%x"`, hash.Sum64())
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModifiedIfMatches выставляет ETag и отвечает 304, если клиент уже знает эту версию списка.
+func writeNotModifiedIfMatches(writer http.ResponseWriter, request *http.Request, etag string) bool {
+	writer.Header().Set("ETag", etag)
+	writer.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(request.Header.Get("If-None-Match"), etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
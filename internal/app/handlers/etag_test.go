@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"5f2b1c"`
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{name: "no header", ifNoneMatch: "", want: false},
+		{name: "strong match", ifNoneMatch: `"5f2b1c"`, want: true},
+		{name: "weak match", ifNoneMatch: `W/"5f2b1c"`, want: true},
+		{name: "other tag", ifNoneMatch: `"a1b2c3"`, want: false},
+		{name: "unquoted tag", ifNoneMatch: `5f2b1c`, want: false},
+		{name: "list with match", ifNoneMatch: `"a1b2c3", W/"5f2b1c"`, want: true},
+		{name: "list without spaces", ifNoneMatch: `"a1b2c3","5f2b1c"`, want: true},
+		{name: "list without match", ifNoneMatch: `"a1b2c3", W/"d4e5f6"`, want: false},
+		{name: "wildcard", ifNoneMatch: `*`, want: true},
+		{name: "wildcard with spaces", ifNoneMatch: ` * `, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, etagMatches(tt.ifNoneMatch, etag))
+		})
+	}
+}
+
+func TestWriteNotModifiedIfMatches(t *testing.T) {
+	const etag = `"5f2b1c"`
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	request.Header.Set("If-None-Match", `W/"5f2b1c"`)
+	recorder := httptest.NewRecorder()
+	assert.True(t, writeNotModifiedIfMatches(recorder, request, etag))
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+	assert.Equal(t, etag, recorder.Header().Get("ETag"))
+
+	request = httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	recorder = httptest.NewRecorder()
+	assert.False(t, writeNotModifiedIfMatches(recorder, request, etag))
+	assert.Equal(t, etag, recorder.Header().Get("ETag"))
+	assert.Equal(t, "private, no-cache", recorder.Header().Get("Cache-Control"))
+}
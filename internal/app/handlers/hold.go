@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CreateHoldHandler struct {
+	holdService        service.HoldServiceInterface
+	orderNumberChecker *validators.OrderNumberChecker
+	walletChecker      *validators.WalletChecker
+}
+
+func NewCreateHoldHandler(
+	holdService service.HoldServiceInterface,
+	orderNumberChecker *validators.OrderNumberChecker,
+	walletChecker *validators.WalletChecker) CreateHoldHandler {
+	return CreateHoldHandler{
+		holdService:        holdService,
+		orderNumberChecker: orderNumberChecker,
+		walletChecker:      walletChecker,
+	}
+}
+
+func (create CreateHoldHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if contentType := request.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		logger.Log.Infoln("Inappropriate content type passed")
+		http.Error(writer, "Only application/json content type is allowed", http.StatusBadRequest)
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.CreateHoldRequest
+	dec := json.NewDecoder(request.Body)
+	if err := dec.Decode(&requestData); err != nil {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	orderNumber, err := create.orderNumberChecker.Check(requestData.Order)
+	if err != nil {
+		logger.Log.Infof("Invalid order number %q: %v", requestData.Order, err)
+		http.Error(writer, "The provided payload does not contain a valid order number", http.StatusUnprocessableEntity)
+		return
+	}
+	wallet, err := create.walletChecker.Check(requestData.Wallet)
+	if err != nil {
+		http.Error(writer, "Unknown wallet", http.StatusUnprocessableEntity)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	hold, err := create.holdService.Create(
+		request.Context(), orderNumber, requestData.Amount, nullOrderTotal(requestData.OrderTotal), wallet, userID)
+	if err != nil {
+		if writePolicyViolation(writer, err) {
+			logger.Log.Infof("Hold %s of user %d violates policy: %v", orderNumber, userID, err)
+			return
+		}
+		switch {
+		case errors.Is(err, repositories.ErrWithdrawalOrderAlreadyExists),
+			errors.Is(err, repositories.ErrHoldAlreadyExists):
+			logger.Log.Infof("withdrawal order %s already registered or held", orderNumber)
+			writer.WriteHeader(http.StatusConflict)
+			return
+		case errors.Is(err, repositories.ErrNotEnoughPoints):
+			writer.WriteHeader(http.StatusPaymentRequired)
+			return
+		default:
+			logger.Log.Warn("Couldn't create the hold: ", err)
+			http.Error(writer, "Couldn't create the hold", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeHoldResponse(writer, http.StatusCreated, hold)
+}
+
+type CaptureHoldHandler struct {
+	holdService service.HoldServiceInterface
+}
+
+func NewCaptureHoldHandler(holdService service.HoldServiceInterface) CaptureHoldHandler {
+	return CaptureHoldHandler{holdService: holdService}
+}
+
+func (capture CaptureHoldHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	holdID, err := strconv.ParseUint(chi.URLParam(request, "id"), 10, 64)
+	if err != nil {
+		http.Error(writer, "Please provide a valid hold id", http.StatusBadRequest)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	hold, err := capture.holdService.Capture(request.Context(), holdID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrNotEnoughPoints):
+			writer.WriteHeader(http.StatusPaymentRequired)
+			return
+		case errors.Is(err, repositories.ErrWithdrawalOrderAlreadyExists):
+			writer.WriteHeader(http.StatusConflict)
+			return
+		default:
+			writeHoldError(writer, holdID, err)
+			return
+		}
+	}
+	writeHoldResponse(writer, http.StatusOK, hold)
+}
+
+type VoidHoldHandler struct {
+	holdService service.HoldServiceInterface
+}
+
+func NewVoidHoldHandler(holdService service.HoldServiceInterface) VoidHoldHandler {
+	return VoidHoldHandler{holdService: holdService}
+}
+
+func (void VoidHoldHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	holdID, err := strconv.ParseUint(chi.URLParam(request, "id"), 10, 64)
+	if err != nil {
+		http.Error(writer, "Please provide a valid hold id", http.StatusBadRequest)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	hold, err := void.holdService.Void(request.Context(), holdID, userID)
+	if err != nil {
+		writeHoldError(writer, holdID, err)
+		return
+	}
+	writeHoldResponse(writer, http.StatusOK, hold)
+}
+
+func writeHoldError(writer http.ResponseWriter, holdID uint64, err error) {
+	switch {
+	case errors.Is(err, repositories.ErrHoldNotFound):
+		http.Error(writer, "No hold found with the given id", http.StatusNotFound)
+	case errors.Is(err, repositories.ErrHoldNotActive):
+		http.Error(writer, "The hold is already captured, voided or expired", http.StatusConflict)
+	default:
+		logger.Log.Warnf("Couldn't process the hold %d: %v", holdID, err)
+		http.Error(writer, "Couldn't process the hold", http.StatusInternalServerError)
+	}
+}
+
+func writeHoldResponse(writer http.ResponseWriter, status int, hold repositories.Hold) {
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	enc := json.NewEncoder(writer)
+	err := enc.Encode(models.HoldResponse{
+		ID:        hold.ID,
+		Order:     hold.OrderNumber,
+		Sum:       hold.Amount,
+		Wallet:    hold.Wallet,
+		Status:    hold.Status,
+		ExpiresAt: hold.ExpiresAt,
+		CreatedAt: hold.CreatedAt,
+	})
+	if err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	listingFormatJSON   = "application/json"
+	listingFormatCSV    = "text/csv"
+	listingFormatNDJSON = "application/x-ndjson"
+)
+
+var listingFormats = []string{listingFormatJSON, listingFormatCSV, listingFormatNDJSON}
+
+type listingRow interface {
+	CSVRecord() []string
+}
+
+// listingEncoder пишет строки списка в ответ по мере чтения из базы, не собирая их в память.
+type listingEncoder interface {
+	Begin() error
+	Encode(row listingRow) error
+	End() error
+}
+
+// negotiateListingFormat выбирает формат выдачи по заголовку Accept. Пустая строка означает,
+// что ни один из поддерживаемых форматов клиенту не подходит.
+func negotiateListingFormat(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return listingFormatJSON
+	}
+	bestFormat := ""
+	bestQuality := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, parseErr := strconv.ParseFloat(q, 64); parseErr == nil {
+				quality = parsed
+			}
+		}
+		if quality <= bestQuality {
+			continue
+		}
+		for _, format := range listingFormats {
+			if mediaTypeMatches(mediaType, format) {
+				bestFormat = format
+				bestQuality = quality
+				break
+			}
+		}
+	}
+	return bestFormat
+}
+
+func mediaTypeMatches(mediaType string, format string) bool {
+	switch {
+	case mediaType == "*/*":
+		return true
+	case strings.HasSuffix(mediaType, "/*"):
+		return strings.HasPrefix(format, strings.TrimSuffix(mediaType, "*"))
+	default:
+		return mediaType == format
+	}
+}
+
+func listingContentType(format string) string {
+	if format == listingFormatJSON {
+		return format
+	}
+	return format + "; charset=utf-8"
+}
+
+func newListingEncoder(format string, writer io.Writer, csvHeader []string) listingEncoder {
+	switch format {
+	case listingFormatCSV:
+		return &csvListingEncoder{writer: csv.NewWriter(writer), header: csvHeader}
+	case listingFormatNDJSON:
+		return &ndjsonListingEncoder{encoder: json.NewEncoder(writer)}
+	default:
+		return &jsonListingEncoder{writer: writer}
+	}
+}
+
+type jsonListingEncoder struct {
+	writer  io.Writer
+	written bool
+}
+
+func (j *jsonListingEncoder) Begin() error {
+	_, err := io.WriteString(j.writer, "[")
+	return err
+}
+
+func (j *jsonListingEncoder) Encode(row listingRow) error {
+	if j.written {
+		if _, err := io.WriteString(j.writer, ","); err != nil {
+			return err
+		}
+	}
+	payload, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	j.written = true
+	_, err = j.writer.Write(payload)
+	return err
+}
+
+func (j *jsonListingEncoder) End() error {
+	_, err := io.WriteString(j.writer, "]\n")
+	return err
+}
+
+type ndjsonListingEncoder struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonListingEncoder) Begin() error {
+	return nil
+}
+
+func (n *ndjsonListingEncoder) Encode(row listingRow) error {
+	return n.encoder.Encode(row)
+}
+
+func (n *ndjsonListingEncoder) End() error {
+	return nil
+}
+
+type csvListingEncoder struct {
+	writer *csv.Writer
+	header []string
+}
+
+func (c *csvListingEncoder) Begin() error {
+	return c.writer.Write(c.header)
+}
+
+func (c *csvListingEncoder) Encode(row listingRow) error {
+	return c.writer.Write(row.CSVRecord())
+}
+
+func (c *csvListingEncoder) End() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNegotiateListingFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "no header", accept: "", want: listingFormatJSON},
+		{name: "blank header", accept: "  ", want: listingFormatJSON},
+		{name: "json", accept: "application/json", want: listingFormatJSON},
+		{name: "csv", accept: "text/csv", want: listingFormatCSV},
+		{name: "ndjson", accept: "application/x-ndjson", want: listingFormatNDJSON},
+		{name: "any", accept: "*/*", want: listingFormatJSON},
+		{name: "type wildcard", accept: "text/*", want: listingFormatCSV},
+		{name: "application wildcard prefers first format", accept: "application/*", want: listingFormatJSON},
+		{name: "highest quality wins", accept: "application/json;q=0.5, text/csv;q=0.9", want: listingFormatCSV},
+		{name: "default quality is one", accept: "text/csv;q=0.8, application/x-ndjson", want: listingFormatNDJSON},
+		{name: "equal quality keeps first", accept: "text/csv, application/json", want: listingFormatCSV},
+		{name: "specific over wildcard by quality", accept: "*/*;q=0.1, text/csv", want: listingFormatCSV},
+		{name: "zero quality excluded", accept: "text/csv;q=0", want: ""},
+		{name: "zero quality with fallback", accept: "text/csv;q=0, */*;q=0.1", want: listingFormatJSON},
+		{name: "invalid quality treated as one", accept: "text/csv;q=abc", want: listingFormatCSV},
+		{name: "unsupported", accept: "application/xml", want: ""},
+		{name: "unsupported wildcard", accept: "image/*", want: ""},
+		{name: "unsupported and supported", accept: "application/xml, text/csv;q=0.2", want: listingFormatCSV},
+		{name: "malformed part skipped", accept: "garbage;;, application/x-ndjson", want: listingFormatNDJSON},
+		{name: "parameters ignored", accept: "text/csv; charset=utf-8", want: listingFormatCSV},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateListingFormat(tt.accept))
+		})
+	}
+}
+
+func TestMediaTypeMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		format    string
+		want      bool
+	}{
+		{name: "exact", mediaType: "text/csv", format: listingFormatCSV, want: true},
+		{name: "different subtype", mediaType: "text/plain", format: listingFormatCSV, want: false},
+		{name: "any", mediaType: "*/*", format: listingFormatNDJSON, want: true},
+		{name: "type wildcard", mediaType: "application/*", format: listingFormatNDJSON, want: true},
+		{name: "other type wildcard", mediaType: "text/*", format: listingFormatJSON, want: false},
+		{name: "type prefix is not a wildcard", mediaType: "app/*", format: listingFormatJSON, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mediaTypeMatches(tt.mediaType, tt.format))
+		})
+	}
+}
@@ -1,26 +1,34 @@
 package handlers
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
 	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
 	"github.com/ClearThree/gophermart-bonus/internal/app/models"
 	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
 	"github.com/ClearThree/gophermart-bonus/internal/app/service"
-	"github.com/theplant/luhn"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
+	"github.com/go-chi/chi/v5"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
 )
 
 type RegisterOrderHandler struct {
-	orderService service.OrderServiceInterface
+	orderService       service.OrderServiceInterface
+	orderNumberChecker *validators.OrderNumberChecker
+	walletChecker      *validators.WalletChecker
 }
 
-func NewRegisterOrderHandler(service service.OrderServiceInterface) *RegisterOrderHandler {
-	return &RegisterOrderHandler{orderService: service}
+func NewRegisterOrderHandler(
+	service service.OrderServiceInterface,
+	orderNumberChecker *validators.OrderNumberChecker,
+	walletChecker *validators.WalletChecker) *RegisterOrderHandler {
+	return &RegisterOrderHandler{
+		orderService:       service,
+		orderNumberChecker: orderNumberChecker,
+		walletChecker:      walletChecker,
+	}
 }
 
 func (register RegisterOrderHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -41,28 +49,36 @@ func (register RegisterOrderHandler) ServeHTTP(writer http.ResponseWriter, reque
 		http.Error(writer, "Please provide an order number", http.StatusBadRequest)
 		return
 	}
-	orderNumber := string(payload)
-	intOrderNumber, err := strconv.Atoi(orderNumber)
+	orderNumber, err := register.orderNumberChecker.Check(string(payload))
 	if err != nil {
-		logger.Log.Warn("Couldn't parse the order number: not a number")
+		logger.Log.Warnf("Invalid order number %q: %v", string(payload), err)
 		http.Error(writer, "The provided payload is not a valid order number", http.StatusUnprocessableEntity)
+		return
 	}
-	if !luhn.Valid(intOrderNumber) {
-		logger.Log.Warnf("Invalid order number: %s", orderNumber)
-		http.Error(writer, "The provided payload is not a valid order number", http.StatusUnprocessableEntity)
+	// Тело запроса — номер заказа в text/plain, поэтому кошелёк для начисления передаётся параметром запроса
+	wallet, err := register.walletChecker.Check(request.URL.Query().Get("wallet"))
+	if err != nil {
+		http.Error(writer, "Unknown wallet", http.StatusUnprocessableEntity)
 		return
 	}
 	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
-	ID, err := register.orderService.Create(request.Context(), orderNumber, userID)
+	ID, err := register.orderService.Create(request.Context(), orderNumber, wallet, userID)
 	if err != nil {
 		switch {
 		case errors.Is(err, service.ErrOrderAlreadyRegisteredByCurrentUser):
 			logger.Log.Infof("order %s with id %d is already registered", orderNumber, ID)
 			writer.WriteHeader(http.StatusOK)
 			return
+		case errors.Is(err, service.ErrOrderRegisteredWithOtherWallet):
+			http.Error(writer, "The order is already registered for another wallet", http.StatusConflict)
+			return
 		case errors.Is(err, repositories.ErrOrderAlreadyExists):
 			writer.WriteHeader(http.StatusConflict)
 			return
+		case errors.Is(err, service.ErrOrderQuotaExceeded):
+			logger.Log.Infof("User %d exceeded order upload rules", userID)
+			http.Error(writer, "Too many order uploads, try again later", http.StatusTooManyRequests)
+			return
 		default:
 			logger.Log.Warnf("Couldn't register the order, err: %e", err)
 			http.Error(writer, "Couldn't register the order", http.StatusInternalServerError)
@@ -81,32 +97,87 @@ func NewReadAllOrdersHandler(service service.OrderServiceInterface) *ReadAllOrde
 }
 
 func (read ReadAllOrdersHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	format := negotiateListingFormat(request.Header.Get("Accept"))
+	if format == "" {
+		http.Error(writer, "Supported formats are JSON, CSV and NDJSON", http.StatusNotAcceptable)
+		return
+	}
+	writer.Header().Add("Vary", "Accept")
 	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
-	orders, err := read.orderService.ReadAllByUserID(request.Context(), userID)
+	version, err := read.orderService.GetListVersion(request.Context(), userID)
 	if err != nil {
+		logger.Log.Warnf("Couldn't load orders version: %v", err)
 		http.Error(writer, "Couldn't load orders", http.StatusInternalServerError)
 		return
 	}
-	if len(orders) == 0 {
-		writer.WriteHeader(http.StatusNoContent)
+	if writeNotModifiedIfMatches(writer, request, listETag("orders:"+format, version)) {
 		return
 	}
-	responseData := make([]models.OrdersResponse, len(orders))
-	for index, order := range orders {
-		responseData[index] = models.OrdersResponse{
-			Number:    order.Number,
-			Status:    order.Status,
-			CreatedAt: order.CreatedAt,
-		}
-		if order.Accrual.Valid {
-			responseData[index].Accrual = order.Accrual.Float64
-		}
+	if version.Count == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
 	}
-	writer.Header().Add("Content-Type", "application/json")
+	writer.Header().Add("Content-Type", listingContentType(format))
 	writer.WriteHeader(http.StatusOK)
-	enc := json.NewEncoder(writer)
-	if err = enc.Encode(responseData); err != nil {
+	encoder := newListingEncoder(format, writer, models.OrdersCSVHeader)
+	if err = encoder.Begin(); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+	err = read.orderService.StreamAllByUserID(
+		request.Context(), userID, func(order repositories.OrderWithAccrual) error {
+			responseData := models.OrdersResponse{
+				Number:    order.Number,
+				Wallet:    order.Wallet,
+				Status:    order.Status,
+				CreatedAt: order.CreatedAt,
+			}
+			if order.Accrual.Valid {
+				responseData.Accrual = order.Accrual.Amount
+			}
+			return encoder.Encode(responseData)
+		})
+	if err != nil {
+		logger.Log.Warnf("Error streaming orders: %v", err)
+		return
+	}
+	if err = encoder.End(); err != nil {
 		logger.Log.Debugf("Error encoding response: %s", err)
 		return
 	}
 }
+
+type CancelOrderHandler struct {
+	orderService       service.OrderServiceInterface
+	orderNumberChecker *validators.OrderNumberChecker
+}
+
+func NewCancelOrderHandler(
+	service service.OrderServiceInterface, orderNumberChecker *validators.OrderNumberChecker) *CancelOrderHandler {
+	return &CancelOrderHandler{orderService: service, orderNumberChecker: orderNumberChecker}
+}
+
+func (cancel CancelOrderHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	orderNumber := cancel.orderNumberChecker.Normalize(chi.URLParam(request, "number"))
+	if orderNumber == "" {
+		http.Error(writer, "Please provide an order number", http.StatusBadRequest)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	err := cancel.orderService.Cancel(request.Context(), orderNumber, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrOrderNotFound):
+			http.Error(writer, "No order found with the given number", http.StatusNotFound)
+			return
+		case errors.Is(err, repositories.ErrOrderCannotBeCancelled):
+			http.Error(writer, "Order is already being processed and cannot be cancelled", http.StatusConflict)
+			return
+		default:
+			logger.Log.Warnf("Couldn't cancel the order, err: %v", err)
+			http.Error(writer, "Couldn't cancel the order", http.StatusInternalServerError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type CreatePromoCodeHandler struct {
+	promoCodeService service.PromoCodeServiceInterface
+}
+
+func NewCreatePromoCodeHandler(promoCodeService service.PromoCodeServiceInterface) CreatePromoCodeHandler {
+	return CreatePromoCodeHandler{promoCodeService: promoCodeService}
+}
+
+func (create CreatePromoCodeHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if contentType := request.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		logger.Log.Infoln("Inappropriate content type passed")
+		http.Error(writer, "Only application/json content type is allowed", http.StatusBadRequest)
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.CreatePromoCodeRequest
+	dec := json.NewDecoder(request.Body)
+	if err := dec.Decode(&requestData); err != nil {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	promoCode := repositories.PromoCode{
+		Code:      requestData.Code,
+		Amount:    requestData.Amount,
+		CreatedBy: request.Context().Value(middlewares.UserIDKey).(uint64),
+	}
+	if requestData.MaxUses != nil {
+		promoCode.MaxUses = sql.NullInt64{Int64: *requestData.MaxUses, Valid: true}
+	}
+	if requestData.ExpiresAt != nil {
+		promoCode.ExpiresAt = sql.NullTime{Time: *requestData.ExpiresAt, Valid: true}
+	}
+	promoCode, err := create.promoCodeService.Create(request.Context(), promoCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPromoCode):
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrPromoCodeAlreadyExists):
+			http.Error(writer, "The promo code already exists", http.StatusConflict)
+			return
+		default:
+			logger.Log.Warnf("Couldn't create the promo code: %v", err)
+			http.Error(writer, "Couldn't create the promo code", http.StatusInternalServerError)
+			return
+		}
+	}
+	logger.Log.Infof("Admin %d created promo code %d", promoCode.CreatedBy, promoCode.ID)
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(promoCodeResponse(promoCode)); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+type ReadAllPromoCodesHandler struct {
+	promoCodeService service.PromoCodeServiceInterface
+}
+
+func NewReadAllPromoCodesHandler(promoCodeService service.PromoCodeServiceInterface) ReadAllPromoCodesHandler {
+	return ReadAllPromoCodesHandler{promoCodeService: promoCodeService}
+}
+
+func (readAll ReadAllPromoCodesHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	promoCodes, err := readAll.promoCodeService.ReadAll(request.Context())
+	if err != nil {
+		logger.Log.Warnf("Couldn't load promo codes: %v", err)
+		http.Error(writer, "Couldn't load promo codes", http.StatusInternalServerError)
+		return
+	}
+	if len(promoCodes) == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	responseData := make([]models.PromoCodeResponse, len(promoCodes))
+	for index, promoCode := range promoCodes {
+		responseData[index] = promoCodeResponse(promoCode)
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(responseData); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+type RedeemPromoCodeHandler struct {
+	promoCodeService service.PromoCodeServiceInterface
+}
+
+func NewRedeemPromoCodeHandler(promoCodeService service.PromoCodeServiceInterface) RedeemPromoCodeHandler {
+	return RedeemPromoCodeHandler{promoCodeService: promoCodeService}
+}
+
+func (redeem RedeemPromoCodeHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if contentType := request.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		logger.Log.Infoln("Inappropriate content type passed")
+		http.Error(writer, "Only application/json content type is allowed", http.StatusBadRequest)
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.RedeemPromoCodeRequest
+	dec := json.NewDecoder(request.Body)
+	if err := dec.Decode(&requestData); err != nil {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if service.NormalizePromoCode(requestData.Code) == "" {
+		http.Error(writer, "Please provide a promo code", http.StatusBadRequest)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	redemption, err := redeem.promoCodeService.Redeem(request.Context(), requestData.Code, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrPromoCodeNotFound):
+			http.Error(writer, "No promo code found", http.StatusNotFound)
+			return
+		case errors.Is(err, repositories.ErrPromoCodeExpired):
+			http.Error(writer, "The promo code has expired", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrPromoCodeExhausted):
+			http.Error(writer, "The promo code has no uses left", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrPromoCodeAlreadyRedeemed):
+			http.Error(writer, "The promo code is already redeemed", http.StatusConflict)
+			return
+		default:
+			logger.Log.Warnf("Couldn't redeem a promo code for user %d: %v", userID, err)
+			http.Error(writer, "Couldn't redeem the promo code", http.StatusInternalServerError)
+			return
+		}
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	err = enc.Encode(models.PromoRedemptionResponse{
+		Code:        redemption.Code,
+		Sum:         redemption.Amount,
+		ProcessedAt: redemption.CreatedAt,
+	})
+	if err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+func promoCodeResponse(promoCode repositories.PromoCode) models.PromoCodeResponse {
+	response := models.PromoCodeResponse{
+		ID:        promoCode.ID,
+		Code:      promoCode.Code,
+		Sum:       promoCode.Amount,
+		Uses:      promoCode.Uses,
+		Active:    promoCode.Active,
+		CreatedBy: promoCode.CreatedBy,
+		CreatedAt: promoCode.CreatedAt,
+	}
+	if promoCode.MaxUses.Valid {
+		maxUses := promoCode.MaxUses.Int64
+		response.MaxUses = &maxUses
+	}
+	if promoCode.ExpiresAt.Valid {
+		expiresAt := promoCode.ExpiresAt.Time
+		response.ExpiresAt = &expiresAt
+	}
+	return response
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"net/http"
+)
+
+type ReconciliationHandler struct {
+	reconciliationService service.ReconciliationServiceInterface
+}
+
+func NewReconciliationHandler(service service.ReconciliationServiceInterface) *ReconciliationHandler {
+	return &ReconciliationHandler{reconciliationService: service}
+}
+
+// ServeHTTP на GET только строит отчёт о расхождениях, на POST дополнительно исправляет их.
+func (reconcile ReconciliationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	repair := request.Method == http.MethodPost
+	report, err := reconcile.reconciliationService.Reconcile(request.Context(), repair)
+	if err != nil {
+		logger.Log.Warnf("Failed to reconcile balances: %v", err)
+		http.Error(writer, "Couldn't reconcile balances", http.StatusInternalServerError)
+		return
+	}
+	responseData := models.ReconciliationReportResponse{
+		CheckedAt:     report.CheckedAt,
+		Discrepancies: make([]models.BalanceDiscrepancyResponse, len(report.Results)),
+	}
+	for index, result := range report.Results {
+		responseData.Discrepancies[index] = models.BalanceDiscrepancyResponse{
+			UserID:          result.Discrepancy.UserID,
+			Wallet:          result.Discrepancy.Wallet,
+			StoredBalance:   result.Discrepancy.StoredBalance,
+			LedgerBalance:   result.Discrepancy.LedgerBalance,
+			ExpectedBalance: result.Discrepancy.ExpectedBalance,
+			StoredWithdrawn: result.Discrepancy.StoredWithdrawn,
+			LedgerWithdrawn: result.Discrepancy.LedgerWithdrawn,
+			Repaired:        result.Repaired,
+		}
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(responseData); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
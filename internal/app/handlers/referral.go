@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"net/http"
+)
+
+type ReferralStatsHandler struct {
+	referralService service.ReferralServiceInterface
+}
+
+func NewReferralStatsHandler(referralService service.ReferralServiceInterface) ReferralStatsHandler {
+	return ReferralStatsHandler{referralService: referralService}
+}
+
+// ServeHTTP отдаёт реферальный код пользователя и сводку по приглашённым.
+func (referrals ReferralStatsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	stats, err := referrals.referralService.GetStats(request.Context(), userID)
+	if err != nil {
+		logger.Log.Warnf("Couldn't load referral stats of user %d: %v", userID, err)
+		http.Error(writer, "Couldn't load referral stats", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	err = enc.Encode(models.ReferralStatsResponse{
+		Code:       stats.Code,
+		ReferredBy: stats.ReferredBy,
+		Invited:    stats.Invited,
+		Rewarded:   stats.Rewarded,
+		Earned:     stats.Earned,
+	})
+	if err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"net/http"
+	"strconv"
+)
+
+type RefreshTokenHandler struct {
+	sessionService service.SessionServiceInterface
+}
+
+func NewRefreshTokenHandler(sessionService service.SessionServiceInterface) *RefreshTokenHandler {
+	return &RefreshTokenHandler{sessionService: sessionService}
+}
+
+// ServeHTTP меняет refresh-токен из cookie на новую пару access- и refresh-токенов.
+func (refresh RefreshTokenHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	refreshCookie, err := request.Cookie(middlewares.RefreshCookieName)
+	if err != nil {
+		http.Error(writer, "No refresh token passed", http.StatusUnauthorized)
+		return
+	}
+	session, refreshToken, err := refresh.sessionService.Refresh(request.Context(), refreshCookie.Value)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRefreshToken),
+			errors.Is(err, repositories.ErrRefreshTokenMismatch),
+			errors.Is(err, repositories.ErrSessionNotFound),
+			errors.Is(err, repositories.ErrSessionNotActive),
+			errors.Is(err, repositories.ErrRefreshTokenReused):
+			logger.Log.Infof("Refresh token rejected: %v", err)
+			middlewares.ClearSessionCookies(writer)
+			http.Error(writer, "Refresh token is invalid, revoked or expired", http.StatusUnauthorized)
+			return
+		default:
+			logger.Log.Warnf("Failed to refresh session: %v", err)
+			http.Error(writer, "Couldn't refresh session", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = middlewares.SetSessionCookies(writer, session, refreshToken); err != nil {
+		logger.Log.Warnf("Failed to issue access token for session %d: %v", session.ID, err)
+		http.Error(writer, "Couldn't refresh session", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+type LogoutHandler struct {
+	sessionService service.SessionServiceInterface
+}
+
+func NewLogoutHandler(sessionService service.SessionServiceInterface) *LogoutHandler {
+	return &LogoutHandler{sessionService: sessionService}
+}
+
+// ServeHTTP завершает текущую сессию, а с параметром all=true — все сессии пользователя.
+// Сессия определяется по refresh-токену, а если его нет или он не подходит — по access-токену,
+// поэтому выйти можно и после истечения короткоживущего access-токена.
+func (logout LogoutHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	all := false
+	if rawAll := request.URL.Query().Get("all"); rawAll != "" {
+		var err error
+		if all, err = strconv.ParseBool(rawAll); err != nil {
+			http.Error(writer, "Parameter all must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	userID, err := logout.revokeCurrentSession(request)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			http.Error(writer, "No valid refresh or access token passed", http.StatusUnauthorized)
+			return
+		}
+		logger.Log.Warnf("Failed to log out: %v", err)
+		http.Error(writer, "Couldn't log out", http.StatusInternalServerError)
+		return
+	}
+	if all {
+		if err = logout.sessionService.RevokeAll(request.Context(), userID); err != nil {
+			logger.Log.Warnf("Failed to log out user %d from all sessions: %v", userID, err)
+			http.Error(writer, "Couldn't log out", http.StatusInternalServerError)
+			return
+		}
+	}
+	middlewares.ClearSessionCookies(writer)
+	writer.WriteHeader(http.StatusOK)
+}
+
+var errNoSession = errors.New("no valid session token passed")
+
+// revokeCurrentSession отзывает сессию запроса и возвращает её пользователя.
+func (logout LogoutHandler) revokeCurrentSession(request *http.Request) (uint64, error) {
+	if refreshCookie, err := request.Cookie(middlewares.RefreshCookieName); err == nil {
+		userID, revokeErr := logout.sessionService.RevokeByRefreshToken(request.Context(), refreshCookie.Value)
+		switch {
+		case revokeErr == nil:
+			return userID, nil
+		case errors.Is(revokeErr, service.ErrInvalidRefreshToken),
+			errors.Is(revokeErr, repositories.ErrSessionNotFound):
+			logger.Log.Infof("Refresh token rejected on logout: %v", revokeErr)
+		default:
+			return 0, revokeErr
+		}
+	}
+	authCookie, err := request.Cookie(middlewares.AuthCookieName)
+	if err != nil {
+		return 0, errNoSession
+	}
+	claims, err := middlewares.ParseAccessToken(authCookie.Value)
+	if err != nil {
+		return 0, errNoSession
+	}
+	err = logout.sessionService.Revoke(request.Context(), claims.SessionID, claims.UserID)
+	if err != nil && !errors.Is(err, repositories.ErrSessionNotFound) {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
+	"net/http"
+	"time"
+)
+
+type StatementHandler struct {
+	statementService service.StatementServiceInterface
+	walletChecker    *validators.WalletChecker
+}
+
+func NewStatementHandler(
+	statementService service.StatementServiceInterface, walletChecker *validators.WalletChecker) StatementHandler {
+	return StatementHandler{statementService: statementService, walletChecker: walletChecker}
+}
+
+// ServeHTTP отдаёт выписку по кошельку wallet (по умолчанию основной) за месяц (month=YYYY-MM,
+// по умолчанию предыдущий) или за период from/to в JSON или CSV в зависимости от Accept.
+func (statement StatementHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	mediaType := negotiateListingFormat(request.Header.Get("Accept"))
+	format := ""
+	switch mediaType {
+	case listingFormatJSON:
+		format = service.StatementFormatJSON
+	case listingFormatCSV:
+		format = service.StatementFormatCSV
+	default:
+		http.Error(writer, "Supported formats are JSON and CSV", http.StatusNotAcceptable)
+		return
+	}
+	writer.Header().Add("Vary", "Accept")
+
+	query := request.URL.Query()
+	wallet, err := statement.walletChecker.Check(query.Get("wallet"))
+	if err != nil {
+		http.Error(writer, "Unknown wallet", http.StatusBadRequest)
+		return
+	}
+	from, to, err := service.MonthPeriod(query.Get("month"), time.Now().UTC())
+	if err != nil {
+		http.Error(writer, "month must be in YYYY-MM format", http.StatusBadRequest)
+		return
+	}
+	if query.Get("from") != "" || query.Get("to") != "" {
+		if from, err = parseDateParam(query.Get("from"), false); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if to, err = parseDateParam(query.Get("to"), true); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if to.IsZero() {
+			to = time.Now().UTC()
+		}
+	}
+
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	generated, err := statement.statementService.Generate(request.Context(), userID, wallet, from, to)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidStatementPeriod) {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Log.Warnf("Couldn't generate statement of user %d: %v", userID, err)
+		http.Error(writer, "Couldn't generate the statement", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Add("Content-Type", listingContentType(mediaType))
+	writer.WriteHeader(http.StatusOK)
+	if err = service.WriteStatement(writer, generated, format); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
+	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type CreateTransferHandler struct {
+	transferService service.TransferServiceInterface
+}
+
+func NewCreateTransferHandler(transferService service.TransferServiceInterface) CreateTransferHandler {
+	return CreateTransferHandler{transferService: transferService}
+}
+
+func (create CreateTransferHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if contentType := request.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		logger.Log.Infoln("Inappropriate content type passed")
+		http.Error(writer, "Only application/json content type is allowed", http.StatusBadRequest)
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.CreateTransferRequest
+	dec := json.NewDecoder(request.Body)
+	if err := dec.Decode(&requestData); err != nil {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if requestData.To == "" {
+		http.Error(writer, "Please provide the recipient login", http.StatusBadRequest)
+		return
+	}
+	if !requestData.Amount.IsPositive() {
+		http.Error(writer, "The provided payload does not contain a valid transfer amount", http.StatusUnprocessableEntity)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	transfer, err := create.transferService.Create(
+		request.Context(), userID, requestData.To, requestData.Amount, requestData.Reference)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrTransferRecipientNotFound):
+			http.Error(writer, "No active user found with the given login", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrTransferToSelf):
+			http.Error(writer, "Transferring points to yourself is not allowed", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrTransferLimitExceeded):
+			http.Error(writer, "The transfer exceeds the allowed limits", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, repositories.ErrTransferReferenceConflict):
+			http.Error(writer, "The reference is already used for another transfer", http.StatusConflict)
+			return
+		case errors.Is(err, repositories.ErrNotEnoughPoints):
+			writer.WriteHeader(http.StatusPaymentRequired)
+			return
+		default:
+			logger.Log.Warnf("Couldn't transfer points of user %d: %v", userID, err)
+			http.Error(writer, "Couldn't transfer points", http.StatusInternalServerError)
+			return
+		}
+	}
+	status := http.StatusCreated
+	if transfer.IsDuplicate {
+		status = http.StatusOK
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(transferResponse(transfer, userID)); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+type ReadAllTransfersHandler struct {
+	transferService service.TransferServiceInterface
+}
+
+func NewReadAllTransfersHandler(transferService service.TransferServiceInterface) ReadAllTransfersHandler {
+	return ReadAllTransfersHandler{transferService: transferService}
+}
+
+func (read ReadAllTransfersHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	transfers, err := read.transferService.ReadAllByUserID(request.Context(), userID)
+	if err != nil {
+		logger.Log.Warnf("Couldn't load transfers of user %d: %v", userID, err)
+		http.Error(writer, "Couldn't load transfers", http.StatusInternalServerError)
+		return
+	}
+	if len(transfers) == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	responseData := make([]models.TransferResponse, len(transfers))
+	for index, transfer := range transfers {
+		responseData[index] = transferResponse(transfer, userID)
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	if err = enc.Encode(responseData); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+func transferResponse(transfer repositories.Transfer, userID uint64) models.TransferResponse {
+	response := models.TransferResponse{
+		ID:        transfer.ID,
+		Direction: models.TransferDirectionOutgoing,
+		From:      transfer.FromLogin,
+		To:        transfer.ToLogin,
+		Sum:       transfer.Amount,
+		Reference: transfer.Reference,
+		CreatedAt: transfer.CreatedAt,
+	}
+	if transfer.ToUserID == userID {
+		response.Direction = models.TransferDirectionIncoming
+	}
+	return response
+}
@@ -35,7 +35,7 @@ func (register RegisterHandler) ServeHTTP(writer http.ResponseWriter, request *h
 			logger.Log.Errorf("error closing body: %v", err)
 		}
 	}(request.Body)
-	var requestData models.LoginPasswordRequest
+	var requestData models.RegisterRequest
 	dec := json.NewDecoder(request.Body)
 	if err := dec.Decode(&requestData); err != nil {
 		logger.Log.Debugf("Couldn't decode the request body: %s", err)
@@ -46,15 +46,21 @@ func (register RegisterHandler) ServeHTTP(writer http.ResponseWriter, request *h
 		http.Error(writer, "Both login and password should be passed", http.StatusBadRequest)
 		return
 	}
-	id, err := register.userService.Register(request.Context(), requestData.Login, requestData.Password)
+	id, err := register.userService.Register(
+		request.Context(), requestData.Login, requestData.Password, requestData.ReferralCode)
 	if err != nil {
-		if errors.Is(err, repositories.ErrLoginAlreadyTaken) {
+		switch {
+		case errors.Is(err, repositories.ErrLoginAlreadyTaken):
 			http.Error(writer, "Passed login already exists", http.StatusConflict)
 			return
+		case errors.Is(err, repositories.ErrReferralCodeNotFound):
+			http.Error(writer, "Unknown referral code", http.StatusUnprocessableEntity)
+			return
+		default:
+			logger.Log.Warnf("Failed to register user %v", err)
+			http.Error(writer, "Couldn't register user, something went wrong", http.StatusInternalServerError)
+			return
 		}
-		logger.Log.Warnf("Failed to register user %v", err)
-		http.Error(writer, "Couldn't register user, something went wrong", http.StatusInternalServerError)
-		return
 	}
 	writer.Header().Add(string(middlewares.UserIDKey), strconv.FormatUint(id, 10))
 	writer.WriteHeader(http.StatusOK)
@@ -115,11 +121,13 @@ func (login LoginHandler) ServeHTTP(writer http.ResponseWriter, request *http.Re
 }
 
 type UserBalancesHandler struct {
-	userService service.UserServiceInterface
+	userService    service.UserServiceInterface
+	loyaltyService service.LoyaltyServiceInterface
 }
 
-func NewUserBalancesHandler(service service.UserServiceInterface) *UserBalancesHandler {
-	return &UserBalancesHandler{userService: service}
+func NewUserBalancesHandler(
+	service service.UserServiceInterface, loyaltyService service.LoyaltyServiceInterface) *UserBalancesHandler {
+	return &UserBalancesHandler{userService: service, loyaltyService: loyaltyService}
 }
 
 func (balances UserBalancesHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -130,7 +138,7 @@ func (balances UserBalancesHandler) ServeHTTP(writer http.ResponseWriter, reques
 		}
 	}(request.Body)
 	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
-	current, withdrawn, err := balances.userService.GetBalances(request.Context(), userID)
+	userBalances, err := balances.userService.GetBalances(request.Context(), userID)
 	if err != nil {
 		if errors.Is(err, repositories.ErrUserNotFound) {
 			http.Error(writer, "No user found with the given userID", http.StatusInternalServerError)
@@ -141,8 +149,39 @@ func (balances UserBalancesHandler) ServeHTTP(writer http.ResponseWriter, reques
 		return
 	}
 	responseData := models.GetBalancesResponse{
-		Current:   current,
-		Withdrawn: withdrawn,
+		Current:   userBalances.Current,
+		Withdrawn: userBalances.Withdrawn,
+		Held:      userBalances.Held,
+		Expiring:  expiringPointsResponse(userBalances.Expiring),
+		Wallets:   make([]models.WalletBalanceResponse, len(userBalances.Wallets)),
+	}
+	for index, wallet := range userBalances.Wallets {
+		responseData.Wallets[index] = models.WalletBalanceResponse{
+			Wallet:    wallet.Wallet,
+			Current:   wallet.Current,
+			Withdrawn: wallet.Withdrawn,
+			Held:      wallet.Held,
+			Expiring:  expiringPointsResponse(wallet.Expiring),
+		}
+	}
+	progress, err := balances.loyaltyService.GetProgress(request.Context(), userID)
+	if err != nil {
+		logger.Log.Warnf("Failed to get loyalty tier of user %d: %v", userID, err)
+		http.Error(writer, "Couldn't get user balances", http.StatusInternalServerError)
+		return
+	}
+	responseData.Tier = &models.TierResponse{
+		Name:         progress.Tier.Name,
+		Multiplier:   progress.Tier.Multiplier,
+		AccruedTotal: progress.AccruedTotal,
+		WindowMonths: progress.WindowMonths,
+	}
+	if progress.NextTier != nil {
+		responseData.Tier.Next = &models.NextTierResponse{
+			Name:      progress.NextTier.Name,
+			Threshold: progress.NextTier.Threshold,
+			Remaining: progress.RemainingToGo,
+		}
 	}
 	writer.Header().Add("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusOK)
@@ -152,3 +191,10 @@ func (balances UserBalancesHandler) ServeHTTP(writer http.ResponseWriter, reques
 		return
 	}
 }
+
+func expiringPointsResponse(expiring *repositories.ExpiringPoints) *models.ExpiringPointsResponse {
+	if expiring == nil {
+		return nil
+	}
+	return &models.ExpiringPointsResponse{Sum: expiring.Amount, ExpiresAt: expiring.ExpiresAt}
+}
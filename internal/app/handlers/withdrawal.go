@@ -1,27 +1,40 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
 	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
 	"github.com/ClearThree/gophermart-bonus/internal/app/models"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
 	"github.com/ClearThree/gophermart-bonus/internal/app/service"
-	"github.com/theplant/luhn"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
+	"github.com/go-chi/chi/v5"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type CreateWithdrawalHandler struct {
-	withdrawalService service.WithdrawalServiceInterface
+	withdrawalService  service.WithdrawalServiceInterface
+	orderNumberChecker *validators.OrderNumberChecker
+	walletChecker      *validators.WalletChecker
 }
 
-func NewCreateWithdrawalHandler(withdrawalService service.WithdrawalServiceInterface) CreateWithdrawalHandler {
+func NewCreateWithdrawalHandler(
+	withdrawalService service.WithdrawalServiceInterface,
+	orderNumberChecker *validators.OrderNumberChecker,
+	walletChecker *validators.WalletChecker) CreateWithdrawalHandler {
 	return CreateWithdrawalHandler{
-		withdrawalService: withdrawalService,
+		withdrawalService:  withdrawalService,
+		orderNumberChecker: orderNumberChecker,
+		walletChecker:      walletChecker,
 	}
 }
 
@@ -45,29 +58,39 @@ func (create CreateWithdrawalHandler) ServeHTTP(writer http.ResponseWriter, requ
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	intOrderNumber, err := strconv.Atoi(requestData.Order)
+	orderNumber, err := create.orderNumberChecker.Check(requestData.Order)
 	if err != nil {
-		logger.Log.Infof("Couldn't convert order number to int: %s", err)
-		writer.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	if !luhn.Valid(intOrderNumber) {
-		logger.Log.Infof("Invalid order number: %s", requestData.Order)
+		logger.Log.Infof("Invalid order number %q: %v", requestData.Order, err)
 		http.Error(writer, "The provided payload does not contain a valid order number", http.StatusUnprocessableEntity)
 		return
 	}
-	if requestData.Amount == 0 {
-		logger.Log.Infof("Invalid withdrawal amount for order number: %s", requestData.Order)
-		http.Error(writer, "The provided payload does not contain a valid withdrawal amount", http.StatusUnprocessableEntity)
+	requestData.Order = orderNumber
+	wallet, err := create.walletChecker.Check(requestData.Wallet)
+	if err != nil {
+		http.Error(writer, "Unknown wallet", http.StatusUnprocessableEntity)
+		return
 	}
 	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
-	_, err = create.withdrawalService.Create(request.Context(), requestData.Order, requestData.Amount, userID)
+	_, err = create.withdrawalService.Create(
+		request.Context(),
+		requestData.Order,
+		requestData.Amount,
+		nullOrderTotal(requestData.OrderTotal),
+		wallet,
+		userID)
 	if err != nil {
+		if writePolicyViolation(writer, err) {
+			logger.Log.Infof("Withdrawal %s of user %d violates policy: %v", requestData.Order, userID, err)
+			return
+		}
 		switch {
 		case errors.Is(err, repositories.ErrWithdrawalOrderAlreadyExists):
 			logger.Log.Infof("withdrawal order %s already registered", requestData.Order)
 			writer.WriteHeader(http.StatusBadRequest)
 			return
+		case errors.Is(err, repositories.ErrHoldAlreadyExists):
+			http.Error(writer, "The order number is reserved by an active hold", http.StatusConflict)
+			return
 		case errors.Is(err, repositories.ErrNotEnoughPoints):
 			writer.WriteHeader(http.StatusPaymentRequired)
 			return
@@ -80,6 +103,97 @@ func (create CreateWithdrawalHandler) ServeHTTP(writer http.ResponseWriter, requ
 	writer.WriteHeader(http.StatusOK)
 }
 
+type WithdrawalQuoteHandler struct {
+	withdrawalService service.WithdrawalServiceInterface
+	walletChecker     *validators.WalletChecker
+}
+
+func NewWithdrawalQuoteHandler(
+	withdrawalService service.WithdrawalServiceInterface,
+	walletChecker *validators.WalletChecker) WithdrawalQuoteHandler {
+	return WithdrawalQuoteHandler{
+		withdrawalService: withdrawalService,
+		walletChecker:     walletChecker,
+	}
+}
+
+// ServeHTTP считает, сколько баллов можно списать в счёт корзины на сумму order_total, ничего не меняя.
+// Необязательный параметр wallet выбирает кошелёк.
+func (quote WithdrawalQuoteHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	orderTotal, err := money.Parse(query.Get("order_total"))
+	if err != nil || !orderTotal.IsPositive() {
+		http.Error(writer, "order_total must be a positive amount", http.StatusBadRequest)
+		return
+	}
+	wallet, err := quote.walletChecker.Check(query.Get("wallet"))
+	if err != nil {
+		http.Error(writer, "Unknown wallet", http.StatusBadRequest)
+		return
+	}
+	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	result, err := quote.withdrawalService.Quote(request.Context(), orderTotal, wallet, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidOrderTotal):
+			http.Error(writer, "order_total must be a positive amount", http.StatusBadRequest)
+		case errors.Is(err, repositories.ErrUserNotFound):
+			// Токен действителен, но пользователя уже нет или он деактивирован
+			logger.Log.Infof("Withdrawal quote requested for missing user %d", userID)
+			http.Error(writer, "No user found with the given userID", http.StatusUnauthorized)
+		default:
+			logger.Log.Warnf("Couldn't quote withdrawal for user %d: %v", userID, err)
+			http.Error(writer, "Couldn't quote the withdrawal", http.StatusInternalServerError)
+		}
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	err = enc.Encode(models.WithdrawalQuoteResponse{
+		Wallet:           result.Wallet,
+		Tier:             result.Tier,
+		OrderTotal:       result.OrderTotal,
+		Available:        result.Available,
+		MaxSum:           result.MaxAmount,
+		ResultingBalance: result.ResultingBalance,
+		LimitedBy:        result.LimitedBy,
+	})
+	if err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+	}
+}
+
+// writePolicyViolation отвечает 422 с описанием нарушенного правила, если err — нарушение политики списаний.
+func writePolicyViolation(writer http.ResponseWriter, err error) bool {
+	var violation *service.PolicyViolation
+	if !errors.As(err, &violation) {
+		return false
+	}
+	responseData := models.PolicyViolationResponse{
+		Code:    violation.Code,
+		Message: violation.Message,
+		Tier:    violation.Tier,
+	}
+	if violation.Limit.IsPositive() {
+		responseData.Limit = &violation.Limit
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusUnprocessableEntity)
+	enc := json.NewEncoder(writer)
+	if encodeErr := enc.Encode(responseData); encodeErr != nil {
+		logger.Log.Debugf("Error encoding response: %s", encodeErr)
+	}
+	return true
+}
+
+func nullOrderTotal(orderTotal *money.Amount) money.NullAmount {
+	if orderTotal == nil {
+		return money.NullAmount{}
+	}
+	return money.NullAmount{Amount: *orderTotal, Valid: true}
+}
+
 type ReadAllWithdrawalsHandler struct {
 	withdrawalService service.WithdrawalServiceInterface
 }
@@ -90,21 +204,253 @@ func NewReadAllWithdrawalsHandler(withdrawalService service.WithdrawalServiceInt
 	}
 }
 
+// ServeHTTP отдаёт списания от новых к старым. Параметры: from, to (дата без времени включает весь день,
+// время в RFC 3339 — исключительная граница), min_sum и max_sum (включительно), limit и cursor. Если limit задан и есть следующая страница,
+// её курсор возвращается в заголовке X-Next-Cursor. Без limit список отдаётся целиком потоком.
 func (read ReadAllWithdrawalsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	format := negotiateListingFormat(request.Header.Get("Accept"))
+	if format == "" {
+		http.Error(writer, "Supported formats are JSON, CSV and NDJSON", http.StatusNotAcceptable)
+		return
+	}
+	filter, err := parseWithdrawalFilter(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writer.Header().Add("Vary", "Accept")
 	userID := request.Context().Value(middlewares.UserIDKey).(uint64)
-	withdrawals, err := read.withdrawalService.ReadAllByUserID(request.Context(), userID)
+	version, err := read.withdrawalService.GetListVersion(request.Context(), userID)
 	if err != nil {
+		logger.Log.Warnf("Couldn't load withdrawals version: %v", err)
 		http.Error(writer, "Couldn't load withdrawals", http.StatusInternalServerError)
 		return
 	}
-	if len(withdrawals) == 0 {
+	etagPrefix := "withdrawals:" + format + "?" + request.URL.RawQuery
+	if writeNotModifiedIfMatches(writer, request, listETag(etagPrefix, version)) {
+		return
+	}
+	if version.Count == 0 {
 		writer.WriteHeader(http.StatusNoContent)
 		return
 	}
+	encoder := newListingEncoder(format, writer, models.WithdrawalsCSVHeader)
+	started := false
+	begin := func() error {
+		started = true
+		writer.Header().Add("Content-Type", listingContentType(format))
+		writer.WriteHeader(http.StatusOK)
+		return encoder.Begin()
+	}
+
+	if filter.Limit > 0 {
+		withdrawals, next, readErr := read.withdrawalService.ReadAllByUserID(request.Context(), userID, filter)
+		if readErr != nil {
+			writeWithdrawalsError(writer, readErr)
+			return
+		}
+		if next != nil {
+			writer.Header().Set("X-Next-Cursor", encodeWithdrawalCursor(*next))
+		}
+		if err = begin(); err != nil {
+			logger.Log.Debugf("Error encoding response: %s", err)
+			return
+		}
+		for _, withdrawal := range withdrawals {
+			if err = encoder.Encode(withdrawalResponse(withdrawal)); err != nil {
+				logger.Log.Debugf("Error encoding response: %s", err)
+				return
+			}
+		}
+	} else {
+		_, err = read.withdrawalService.StreamAllByUserID(
+			request.Context(), userID, filter, func(withdrawal repositories.Withdrawal) error {
+				if !started {
+					if beginErr := begin(); beginErr != nil {
+						return beginErr
+					}
+				}
+				return encoder.Encode(withdrawalResponse(withdrawal))
+			})
+		if err != nil {
+			if !started {
+				writeWithdrawalsError(writer, err)
+				return
+			}
+			logger.Log.Warnf("Error streaming withdrawals: %v", err)
+			return
+		}
+		if !started {
+			if err = begin(); err != nil {
+				logger.Log.Debugf("Error encoding response: %s", err)
+				return
+			}
+		}
+	}
+	if err = encoder.End(); err != nil {
+		logger.Log.Debugf("Error encoding response: %s", err)
+		return
+	}
+}
+
+func withdrawalResponse(withdrawal repositories.Withdrawal) models.WithdrawalResponse {
+	response := models.WithdrawalResponse{
+		Order:       withdrawal.OrderNumber,
+		Sum:         withdrawal.Amount,
+		Wallet:      withdrawal.Wallet,
+		ProcessedAt: withdrawal.CreatedAt,
+	}
+	if withdrawal.ReversedAt.Valid {
+		response.Reversed = withdrawal.Reversed
+		response.ReversedAt = &withdrawal.ReversedAt.Time
+	}
+	return response
+}
+
+func writeWithdrawalsError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrInvalidWithdrawalAmountRange) || errors.Is(err, service.ErrInvalidWithdrawalDateRange) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Log.Warnf("Couldn't load withdrawals: %v", err)
+	http.Error(writer, "Couldn't load withdrawals", http.StatusInternalServerError)
+}
+
+func parseWithdrawalFilter(query url.Values) (repositories.WithdrawalFilter, error) {
+	var filter repositories.WithdrawalFilter
+	var err error
+	if filter.From, err = parseDateParam(query.Get("from"), false); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseDateParam(query.Get("to"), true); err != nil {
+		return filter, err
+	}
+	for _, bound := range []struct {
+		name string
+		dest *money.NullAmount
+	}{
+		{"min_sum", &filter.MinAmount},
+		{"max_sum", &filter.MaxAmount},
+	} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		amount, parseErr := money.Parse(value)
+		if parseErr != nil {
+			return filter, fmt.Errorf("%s must be an amount", bound.name)
+		}
+		*bound.dest = money.NullAmount{Amount: amount, Valid: true}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil {
+			return filter, errors.New("limit must be a non-negative integer")
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, decodeErr := decodeWithdrawalCursor(cursor)
+		if decodeErr != nil {
+			return filter, errInvalidCursor
+		}
+		filter.After = &after
+	}
+	return filter, nil
+}
+
+var errInvalidCursor = errors.New("cursor is invalid")
+
+// Курсор непрозрачен для клиента: время и id последнего списания страницы в base64.
+func encodeWithdrawalCursor(cursor repositories.WithdrawalCursor) string {
+	raw := cursor.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatUint(cursor.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeWithdrawalCursor(value string) (repositories.WithdrawalCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return repositories.WithdrawalCursor{}, err
+	}
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return repositories.WithdrawalCursor{}, errInvalidCursor
+	}
+	var cursor repositories.WithdrawalCursor
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return repositories.WithdrawalCursor{}, err
+	}
+	if cursor.ID, err = strconv.ParseUint(id, 10, 64); err != nil {
+		return repositories.WithdrawalCursor{}, err
+	}
+	return cursor, nil
+}
+
+type ReverseWithdrawalHandler struct {
+	withdrawalService  service.WithdrawalServiceInterface
+	orderNumberChecker *validators.OrderNumberChecker
+}
+
+func NewReverseWithdrawalHandler(
+	withdrawalService service.WithdrawalServiceInterface,
+	orderNumberChecker *validators.OrderNumberChecker) ReverseWithdrawalHandler {
+	return ReverseWithdrawalHandler{
+		withdrawalService:  withdrawalService,
+		orderNumberChecker: orderNumberChecker,
+	}
+}
+
+func (reverse ReverseWithdrawalHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	orderNumber := reverse.orderNumberChecker.Normalize(chi.URLParam(request, "number"))
+	if orderNumber == "" {
+		http.Error(writer, "Please provide a withdrawal order number", http.StatusBadRequest)
+		return
+	}
+	defer func(Body io.ReadCloser) {
+		innerErr := Body.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing body: %v", innerErr)
+		}
+	}(request.Body)
+	var requestData models.CreateReversalRequest
+	dec := json.NewDecoder(request.Body)
+	if err := dec.Decode(&requestData); err != nil && !errors.Is(err, io.EOF) {
+		logger.Log.Debugf("Couldn't decode the request body: %s", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if requestData.Amount.IsNegative() {
+		http.Error(writer, "The reversal amount must be positive", http.StatusUnprocessableEntity)
+		return
+	}
+	adminID := request.Context().Value(middlewares.UserIDKey).(uint64)
+	reversal, err := reverse.withdrawalService.Reverse(
+		request.Context(), orderNumber, requestData.Amount, requestData.Reason, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrWithdrawalNotFound):
+			http.Error(writer, "No withdrawal found with the given order number", http.StatusNotFound)
+			return
+		case errors.Is(err, repositories.ErrReversalExceedsWithdrawal):
+			http.Error(writer, "The reversal exceeds the withdrawn amount", http.StatusConflict)
+			return
+		default:
+			logger.Log.Warnf("Couldn't reverse the withdrawal %s: %v", orderNumber, err)
+			http.Error(writer, "Couldn't reverse the withdrawal", http.StatusInternalServerError)
+			return
+		}
+	}
+	logger.Log.Infof("Admin %d reversed %s points of withdrawal %s", adminID, reversal.Amount, orderNumber)
 	writer.Header().Add("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
+	writer.WriteHeader(http.StatusCreated)
 	enc := json.NewEncoder(writer)
-	if err = enc.Encode(withdrawals); err != nil {
+	err = enc.Encode(models.ReversalResponse{
+		ID:        reversal.ID,
+		Order:     reversal.OrderNumber,
+		UserID:    reversal.UserID,
+		Sum:       reversal.Amount,
+		Reason:    reversal.Reason,
+		CreatedAt: reversal.CreatedAt,
+	})
+	if err != nil {
 		logger.Log.Debugf("Error encoding response: %s", err)
 		return
 	}
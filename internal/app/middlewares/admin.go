@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"net/http"
+)
+
+type AdminChecker interface {
+	IsAdmin(ctx context.Context, userID uint64) (bool, error)
+}
+
+// NewAdminMiddleware пропускает дальше только администраторов, должен стоять после AuthMiddleware.
+func NewAdminMiddleware(checker AdminChecker) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(writer http.ResponseWriter, request *http.Request) {
+			userID, ok := request.Context().Value(UserIDKey).(uint64)
+			if !ok || userID == 0 {
+				http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			isAdmin, err := checker.IsAdmin(request.Context(), userID)
+			if err != nil {
+				logger.Log.Warnf("Couldn't check admin rights of user %d: %v", userID, err)
+				http.Error(writer, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !isAdmin {
+				logger.Log.Warnf("User %d tried to access admin endpoint %s", userID, request.RequestURI)
+				http.Error(writer, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
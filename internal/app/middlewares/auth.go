@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/ClearThree/gophermart-bonus/internal/app/config"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
 	"strconv"
@@ -14,29 +15,40 @@ import (
 type UserIDKeyType string
 
 const AuthCookieName = "auth"
+const RefreshCookieName = "refresh"
 const UserIDKey UserIDKeyType = "UserID"
+const SessionIDKey UserIDKeyType = "SessionID"
+
+// Refresh-токен нужен только эндпоинтам пользователя, на остальные пути cookie не отправляется
+const refreshCookiePath = "/api/user"
 
 var ErrWrongAlgorithm = errors.New("unexpected signing method")
 var ErrTokenIsNotValid = errors.New("invalid token passed")
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID uint64 `json:"user_id"`
+	UserID    uint64 `json:"user_id"`
+	SessionID uint64 `json:"session_id"`
 }
 
-func GenerateJWTString(userID uint64) (string, error) {
+// GenerateJWTString выпускает короткоживущий access-токен сессии.
+func GenerateJWTString(userID uint64, sessionID uint64) (string, error) {
 	if userID == 0 {
 		return "", errors.New("invalid user id")
 	}
+	if sessionID == 0 {
+		return "", errors.New("invalid session id")
+	}
 	issueTime := time.Now()
-	expireTime := issueTime.Add(time.Hour * time.Duration(config.Settings.JWTExpireHours))
+	expireTime := issueTime.Add(config.Settings.AccessTokenTTL)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "clearthree",
 			IssuedAt:  jwt.NewNumericDate(issueTime),
 			ExpiresAt: jwt.NewNumericDate(expireTime),
 		},
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 	})
 
 	tokenString, err := token.SignedString([]byte(config.Settings.SecretKey))
@@ -46,7 +58,7 @@ func GenerateJWTString(userID uint64) (string, error) {
 	return tokenString, nil
 }
 
-func GetUserID(tokenString string) (uint64, error) {
+func ParseAccessToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims,
 		func(t *jwt.Token) (interface{}, error) {
@@ -57,53 +69,100 @@ func GetUserID(tokenString string) (uint64, error) {
 			return []byte(config.Settings.SecretKey), nil
 		})
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenExpired) {
-			return claims.UserID, err
-		}
-		return 0, err
+		return nil, err
 	}
 
-	if !token.Valid {
+	if !token.Valid || claims.UserID == 0 || claims.SessionID == 0 {
 		logger.Log.Info("Token is not valid")
-		return 0, ErrTokenIsNotValid
+		return nil, ErrTokenIsNotValid
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	fn := func(writer http.ResponseWriter, request *http.Request) {
-		var ctx = request.Context()
-		token, err := request.Cookie(AuthCookieName)
-		if err != nil {
-			logger.Log.Warnf("No auth cookie")
-			http.Error(writer, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		userID, tokenErr := GetUserID(token.Value)
-		if tokenErr != nil {
-			logger.Log.Error(tokenErr)
-			http.Error(writer, tokenErr.Error(), http.StatusUnauthorized)
-			return
-		}
-		if userID == 0 {
-			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ctx = context.WithValue(ctx, UserIDKey, userID)
+// SetSessionCookies выставляет access-токен сессии и её текущий refresh-токен.
+func SetSessionCookies(writer http.ResponseWriter, session repositories.Session, refreshToken string) error {
+	JWTString, err := GenerateJWTString(session.UserID, session.ID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(writer, &http.Cookie{
+		Name:     AuthCookieName,
+		Value:    JWTString,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	http.SetCookie(writer, &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    refreshToken,
+		Path:     refreshCookiePath,
+		MaxAge:   int(config.Settings.RefreshTokenTTL.Seconds()),
+		HttpOnly: true,
+	})
+	return nil
+}
+
+func ClearSessionCookies(writer http.ResponseWriter) {
+	http.SetCookie(writer, &http.Cookie{Name: AuthCookieName, Path: "/", MaxAge: -1, HttpOnly: true})
+	http.SetCookie(writer, &http.Cookie{Name: RefreshCookieName, Path: refreshCookiePath, MaxAge: -1, HttpOnly: true})
+}
 
-		next.ServeHTTP(writer, request.WithContext(ctx))
+type SessionChecker interface {
+	IsSessionActive(ctx context.Context, sessionID uint64) (bool, error)
+}
+
+// NewAuthMiddleware проверяет access-токен и то, что его сессия не отозвана.
+func NewAuthMiddleware(checker SessionChecker) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(writer http.ResponseWriter, request *http.Request) {
+			var ctx = request.Context()
+			token, err := request.Cookie(AuthCookieName)
+			if err != nil {
+				logger.Log.Warnf("No auth cookie")
+				http.Error(writer, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			claims, tokenErr := ParseAccessToken(token.Value)
+			if tokenErr != nil {
+				logger.Log.Info(tokenErr)
+				http.Error(writer, tokenErr.Error(), http.StatusUnauthorized)
+				return
+			}
+			active, err := checker.IsSessionActive(ctx, claims.SessionID)
+			if err != nil {
+				logger.Log.Warnf("Couldn't check session %d: %v", claims.SessionID, err)
+				http.Error(writer, "Couldn't check the session", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				logger.Log.Infof("Session %d of user %d is revoked or expired", claims.SessionID, claims.UserID)
+				http.Error(writer, "Session is revoked or expired", http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+
+			next.ServeHTTP(writer, request.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
+}
+
+type SessionStarter interface {
+	Start(ctx context.Context, userID uint64) (repositories.Session, string, error)
 }
 
 type SetAuthWriter struct {
-	writer http.ResponseWriter
+	writer  http.ResponseWriter
+	ctx     context.Context
+	starter SessionStarter
 }
 
-func NewSetAuthWriter(writer http.ResponseWriter) *SetAuthWriter {
+func NewSetAuthWriter(ctx context.Context, writer http.ResponseWriter, starter SessionStarter) *SetAuthWriter {
 	return &SetAuthWriter{
-		writer: writer,
+		writer:  writer,
+		ctx:     ctx,
+		starter: starter,
 	}
 }
 
@@ -121,25 +180,29 @@ func (c *SetAuthWriter) WriteHeader(statusCode int) {
 		if err != nil {
 			logger.Log.Error(err)
 			http.Error(c.writer, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		JWTString, genErr := GenerateJWTString(userID)
-		if genErr != nil {
-			http.Error(c.writer, genErr.Error(), http.StatusInternalServerError)
+		session, refreshToken, err := c.starter.Start(c.ctx, userID)
+		if err != nil {
+			logger.Log.Warnf("Couldn't start session for user %d: %v", userID, err)
+			http.Error(c.writer, "Couldn't start session", http.StatusInternalServerError)
+			return
+		}
+		if err = SetSessionCookies(c.writer, session, refreshToken); err != nil {
+			http.Error(c.writer, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.SetCookie(c.writer, &http.Cookie{
-			Name:  AuthCookieName,
-			Value: JWTString,
-			Path:  "/",
-		})
 	}
 	c.writer.WriteHeader(statusCode)
 }
 
-func SetAuthMiddleware(next http.Handler) http.Handler {
-	fn := func(writer http.ResponseWriter, request *http.Request) {
-		writer = NewSetAuthWriter(writer)
-		next.ServeHTTP(writer, request)
+// NewSetAuthMiddleware открывает сессию, если обработчик вернул идентификатор вошедшего пользователя.
+func NewSetAuthMiddleware(starter SessionStarter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(writer http.ResponseWriter, request *http.Request) {
+			writer = NewSetAuthWriter(request.Context(), writer, starter)
+			next.ServeHTTP(writer, request)
+		}
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
 }
@@ -0,0 +1,146 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"io"
+	"net/http"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, userID uint64, key string, requestHash string) (repositories.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, userID uint64, key string, record repositories.IdempotencyRecord) error
+	Release(ctx context.Context, userID uint64, key string) error
+}
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *idempotencyRecorder) Write(p []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// NewIdempotencyMiddleware запоминает ответ на запрос с заголовком Idempotency-Key и отдаёт его же на повтор
+// с тем же ключом и телом. Повтор с другим телом получает 422, повтор во время выполнения первого запроса — 409.
+// Ключ, оставшийся незавершённым после падения процесса, освобождается по истечении аренды.
+// Запросы без заголовка проходят как есть. Должен стоять после AuthMiddleware.
+func NewIdempotencyMiddleware(store IdempotencyStore) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(writer http.ResponseWriter, request *http.Request) {
+			key := request.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(writer, request)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(writer, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+			userID, ok := request.Context().Value(UserIDKey).(uint64)
+			if !ok || userID == 0 {
+				http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			payload, err := io.ReadAll(request.Body)
+			if err != nil {
+				http.Error(writer, "Couldn't read the request body", http.StatusBadRequest)
+				return
+			}
+			request.Body = io.NopCloser(bytes.NewReader(payload))
+			requestHash := hashIdempotentRequest(request, payload)
+
+			record, reserved, err := store.Reserve(request.Context(), userID, key, requestHash)
+			if err != nil {
+				logger.Log.Warnf("Couldn't reserve idempotency key of user %d: %v", userID, err)
+				http.Error(writer, "Couldn't process the request", http.StatusInternalServerError)
+				return
+			}
+			if !reserved {
+				switch {
+				case record.RequestHash != requestHash:
+					http.Error(writer, "Idempotency-Key was already used with a different request",
+						http.StatusUnprocessableEntity)
+				case !record.Completed:
+					http.Error(writer, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					if record.ContentType != "" {
+						writer.Header().Set("Content-Type", record.ContentType)
+					}
+					writer.Header().Set(IdempotentReplayedHeader, "true")
+					writer.WriteHeader(record.StatusCode)
+					if _, err = writer.Write(record.ResponseBody); err != nil {
+						logger.Log.Debugf("Error writing replayed response: %s", err)
+					}
+				}
+				return
+			}
+
+			// Отвязываемся от отмены запроса, иначе ключ останется занятым при обрыве соединения
+			ctx := context.WithoutCancel(request.Context())
+			release := func() {
+				if releaseErr := store.Release(ctx, userID, key); releaseErr != nil {
+					logger.Log.Warnf("Couldn't release idempotency key of user %d: %v", userID, releaseErr)
+				}
+			}
+			// Паника обработчика тоже освобождает ключ, дальше её обрабатывает Recoverer
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					release()
+					panic(recovered)
+				}
+			}()
+
+			recorder := &idempotencyRecorder{ResponseWriter: writer}
+			next.ServeHTTP(recorder, request)
+
+			if recorder.statusCode == 0 || recorder.statusCode >= http.StatusInternalServerError {
+				release()
+				return
+			}
+			err = store.Complete(ctx, userID, key, repositories.IdempotencyRecord{
+				RequestHash:  requestHash,
+				Completed:    true,
+				StatusCode:   recorder.statusCode,
+				ContentType:  recorder.Header().Get("Content-Type"),
+				ResponseBody: recorder.body.Bytes(),
+			})
+			if err != nil {
+				logger.Log.Warnf("Couldn't store idempotent response of user %d: %v", userID, err)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func hashIdempotentRequest(request *http.Request, payload []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(request.URL.Path))
+	hash.Write([]byte{0})
+	hash.Write(payload)
+	return hex.EncodeToString(hash.Sum(nil))
+}
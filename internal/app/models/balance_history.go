@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type BalanceHistoryEntryResponse struct {
+	Wallet    string       `json:"wallet"`
+	Kind      string       `json:"kind"`
+	Reference string       `json:"reference,omitempty"`
+	Sum       money.Amount `json:"sum"`
+	Balance   money.Amount `json:"balance"`
+	CreatedAt time.Time    `json:"created_at"`
+}
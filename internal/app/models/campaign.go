@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type CreateCampaignRequest struct {
+	Name       string        `json:"name"`
+	StartsAt   time.Time     `json:"starts_at"`
+	EndsAt     time.Time     `json:"ends_at"`
+	Multiplier json.Number   `json:"multiplier,omitempty"`
+	FixedBonus *money.Amount `json:"fixed_bonus,omitempty"`
+	Tiers      []string      `json:"tiers,omitempty"`
+	UserIDs    []uint64      `json:"user_ids,omitempty"`
+}
+
+type CampaignResponse struct {
+	ID         uint64        `json:"id"`
+	Name       string        `json:"name"`
+	StartsAt   time.Time     `json:"starts_at"`
+	EndsAt     time.Time     `json:"ends_at"`
+	Multiplier string        `json:"multiplier,omitempty"`
+	FixedBonus *money.Amount `json:"fixed_bonus,omitempty"`
+	Active     bool          `json:"active"`
+	Tiers      []string      `json:"tiers,omitempty"`
+	UserIDs    []uint64      `json:"user_ids,omitempty"`
+	CreatedBy  uint64        `json:"created_by"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
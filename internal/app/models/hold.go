@@ -0,0 +1,24 @@
+package models
+
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type CreateHoldRequest struct {
+	Order      string        `json:"order"`
+	Amount     money.Amount  `json:"sum"`
+	OrderTotal *money.Amount `json:"order_total,omitempty"`
+	// Пустой кошелёк означает основной
+	Wallet string `json:"wallet,omitempty"`
+}
+
+type HoldResponse struct {
+	ID        uint64       `json:"id"`
+	Order     string       `json:"order"`
+	Sum       money.Amount `json:"sum"`
+	Wallet    string       `json:"wallet"`
+	Status    string       `json:"status"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
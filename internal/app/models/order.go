@@ -1,12 +1,24 @@
 package models
 
 import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"time"
 )
 
 type OrdersResponse struct {
-	Number    string    `json:"number"`
-	Status    string    `json:"status"`
-	Accrual   float64   `json:"accrual,omitempty"`
-	CreatedAt time.Time `json:"uploaded_at"`
+	Number    string       `json:"number"`
+	Wallet    string       `json:"wallet"`
+	Status    string       `json:"status"`
+	Accrual   money.Amount `json:"accrual,omitempty"`
+	CreatedAt time.Time    `json:"uploaded_at"`
+}
+
+var OrdersCSVHeader = []string{"number", "status", "accrual", "uploaded_at", "wallet"}
+
+func (o OrdersResponse) CSVRecord() []string {
+	accrual := ""
+	if !o.Accrual.IsZero() {
+		accrual = o.Accrual.String()
+	}
+	return []string{o.Number, o.Status, accrual, o.CreatedAt.Format(time.RFC3339Nano), o.Wallet}
 }
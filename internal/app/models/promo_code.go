@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type CreatePromoCodeRequest struct {
+	Code      string       `json:"code"`
+	Amount    money.Amount `json:"sum"`
+	MaxUses   *int64       `json:"max_uses,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+}
+
+type PromoCodeResponse struct {
+	ID        uint64       `json:"id"`
+	Code      string       `json:"code"`
+	Sum       money.Amount `json:"sum"`
+	MaxUses   *int64       `json:"max_uses,omitempty"`
+	Uses      int64        `json:"uses"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	Active    bool         `json:"active"`
+	CreatedBy uint64       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type RedeemPromoCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type PromoRedemptionResponse struct {
+	Code        string       `json:"code"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt time.Time    `json:"processed_at"`
+}
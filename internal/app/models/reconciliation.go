@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type BalanceDiscrepancyResponse struct {
+	UserID          uint64       `json:"user_id"`
+	Wallet          string       `json:"wallet"`
+	StoredBalance   money.Amount `json:"stored_balance"`
+	LedgerBalance   money.Amount `json:"ledger_balance"`
+	ExpectedBalance money.Amount `json:"expected_balance"`
+	StoredWithdrawn money.Amount `json:"stored_withdrawn"`
+	LedgerWithdrawn money.Amount `json:"ledger_withdrawn"`
+	Repaired        bool         `json:"repaired"`
+}
+
+type ReconciliationReportResponse struct {
+	CheckedAt     time.Time                    `json:"checked_at"`
+	Discrepancies []BalanceDiscrepancyResponse `json:"discrepancies"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type CreateTransferRequest struct {
+	To        string       `json:"to"`
+	Amount    money.Amount `json:"sum"`
+	Reference string       `json:"reference,omitempty"`
+}
+
+const (
+	TransferDirectionIncoming = "incoming"
+	TransferDirectionOutgoing = "outgoing"
+)
+
+type TransferResponse struct {
+	ID        uint64       `json:"id"`
+	Direction string       `json:"direction"`
+	From      string       `json:"from,omitempty"`
+	To        string       `json:"to"`
+	Sum       money.Amount `json:"sum"`
+	Reference string       `json:"reference,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
@@ -1,11 +1,80 @@
 package models
 
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
 type LoginPasswordRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
 
+type RegisterRequest struct {
+	LoginPasswordRequest
+	ReferralCode string `json:"referral_code,omitempty"`
+}
+
+type ReferralStatsResponse struct {
+	Code       string       `json:"code"`
+	ReferredBy string       `json:"referred_by,omitempty"`
+	Invited    uint64       `json:"invited"`
+	Rewarded   uint64       `json:"rewarded"`
+	Earned     money.Amount `json:"earned"`
+}
+
+// GetBalancesResponse на верхнем уровне описывает основной кошелёк, Wallets — все кошельки пользователя.
 type GetBalancesResponse struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   money.Amount            `json:"current"`
+	Withdrawn money.Amount            `json:"withdrawn"`
+	Held      money.Amount            `json:"held"`
+	Expiring  *ExpiringPointsResponse `json:"expiring,omitempty"`
+	Tier      *TierResponse           `json:"tier,omitempty"`
+	Wallets   []WalletBalanceResponse `json:"wallets"`
+}
+
+type WalletBalanceResponse struct {
+	Wallet    string                  `json:"wallet"`
+	Current   money.Amount            `json:"current"`
+	Withdrawn money.Amount            `json:"withdrawn"`
+	Held      money.Amount            `json:"held"`
+	Expiring  *ExpiringPointsResponse `json:"expiring,omitempty"`
+}
+
+type TierResponse struct {
+	Name         string            `json:"name"`
+	Multiplier   string            `json:"multiplier"`
+	AccruedTotal money.Amount      `json:"accrued_total"`
+	WindowMonths int               `json:"window_months"`
+	Next         *NextTierResponse `json:"next,omitempty"`
+}
+
+type NextTierResponse struct {
+	Name      string       `json:"name"`
+	Threshold money.Amount `json:"threshold"`
+	Remaining money.Amount `json:"remaining"`
+}
+
+type ExpiringPointsResponse struct {
+	Sum       money.Amount `json:"sum"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+type CreateAdjustmentRequest struct {
+	// Положительная сумма начисляет баллы, отрицательная списывает
+	Amount  money.Amount `json:"sum"`
+	Reason  string       `json:"reason"`
+	Comment string       `json:"comment"`
+	Force   bool         `json:"force,omitempty"`
+}
+
+type AdjustmentResponse struct {
+	ID        uint64       `json:"id"`
+	UserID    uint64       `json:"user_id"`
+	Sum       money.Amount `json:"sum"`
+	Reason    string       `json:"reason"`
+	Comment   string       `json:"comment"`
+	Forced    bool         `json:"forced"`
+	CreatedBy uint64       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
 }
@@ -1,6 +1,67 @@
 package models
 
+import (
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
 type CreateWithdrawalRequest struct {
-	Order  string  `json:"order"`
-	Amount float64 `json:"sum"`
+	Order      string        `json:"order"`
+	Amount     money.Amount  `json:"sum"`
+	OrderTotal *money.Amount `json:"order_total,omitempty"`
+	// Пустой кошелёк означает основной
+	Wallet string `json:"wallet,omitempty"`
+}
+
+type WithdrawalQuoteResponse struct {
+	Wallet           string       `json:"wallet"`
+	Tier             string       `json:"tier,omitempty"`
+	OrderTotal       money.Amount `json:"order_total"`
+	Available        money.Amount `json:"available"`
+	MaxSum           money.Amount `json:"max_sum"`
+	ResultingBalance money.Amount `json:"resulting_balance"`
+	LimitedBy        string       `json:"limited_by"`
+}
+
+type PolicyViolationResponse struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Tier    string        `json:"tier,omitempty"`
+	Limit   *money.Amount `json:"limit,omitempty"`
+}
+
+type WithdrawalResponse struct {
+	Order       string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	Wallet      string       `json:"wallet"`
+	ProcessedAt time.Time    `json:"processed_at"`
+	Reversed    money.Amount `json:"reversed,omitempty"`
+	ReversedAt  *time.Time   `json:"reversed_at,omitempty"`
+}
+
+var WithdrawalsCSVHeader = []string{"order", "sum", "processed_at", "reversed", "reversed_at", "wallet"}
+
+func (w WithdrawalResponse) CSVRecord() []string {
+	reversed, reversedAt := "", ""
+	if w.ReversedAt != nil {
+		reversed = w.Reversed.String()
+		reversedAt = w.ReversedAt.Format(time.RFC3339Nano)
+	}
+	return []string{
+		w.Order, w.Sum.String(), w.ProcessedAt.Format(time.RFC3339Nano), reversed, reversedAt, w.Wallet}
+}
+
+type CreateReversalRequest struct {
+	// Пустая сумма означает возврат всего остатка списания
+	Amount money.Amount `json:"sum"`
+	Reason string       `json:"reason"`
+}
+
+type ReversalResponse struct {
+	ID        uint64       `json:"id"`
+	Order     string       `json:"order"`
+	UserID    uint64       `json:"user_id"`
+	Sum       money.Amount `json:"sum"`
+	Reason    string       `json:"reason,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
 }
@@ -0,0 +1,344 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type RoundingMode string
+
+const (
+	RoundHalfUp   RoundingMode = "half_up"
+	RoundHalfEven RoundingMode = "half_even"
+	RoundDown     RoundingMode = "down"
+	RoundUp       RoundingMode = "up"
+)
+
+const maxScale = 9
+
+var ErrInvalidAmount = errors.New("invalid amount")
+var ErrInvalidScale = errors.New("money scale must be between 0 and 9")
+var ErrUnknownRoundingMode = errors.New("unknown rounding mode")
+var errOutOfRange = fmt.Errorf("%w: value out of range", ErrInvalidAmount)
+
+// decimalPattern пропускает только обычную десятичную запись: без дробей вида 1/3 и экспоненты
+var decimalPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+var scale = 2
+var rounding = RoundHalfEven
+
+// Configure задаёт число знаков после запятой и режим округления.
+// Вызывается один раз при старте приложения, до работы с любыми суммами.
+func Configure(newScale int, mode string) error {
+	if newScale < 0 || newScale > maxScale {
+		return ErrInvalidScale
+	}
+	switch RoundingMode(mode) {
+	case RoundHalfUp, RoundHalfEven, RoundDown, RoundUp:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownRoundingMode, mode)
+	}
+	scale = newScale
+	rounding = RoundingMode(mode)
+	return nil
+}
+
+func Scale() int {
+	return scale
+}
+
+// Amount хранит сумму баллов в целых минимальных единицах (10^-scale), поэтому арифметика точная.
+type Amount int64
+
+func FromMinorUnits(units int64) Amount {
+	return Amount(units)
+}
+
+// FromInt переводит целое число баллов в минимальные единицы, слишком большие значения дают ошибку.
+func FromInt(value int64) (Amount, error) {
+	return fromUnits(new(big.Int).Mul(big.NewInt(value), big.NewInt(pow10(scale))))
+}
+
+// Parse разбирает десятичную запись без потери точности, лишние знаки округляются по текущему режиму.
+func Parse(value string) (Amount, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("%w: empty value", ErrInvalidAmount)
+	}
+	rat, err := parseDecimal(value)
+	if err != nil {
+		return 0, err
+	}
+	return fromRat(rat)
+}
+
+func parseDecimal(value string) (*big.Rat, error) {
+	if !decimalPattern.MatchString(value) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	return rat, nil
+}
+
+func MustParse(value string) Amount {
+	amount, err := Parse(value)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+func fromRat(rat *big.Rat) (Amount, error) {
+	scaled := new(big.Int).Mul(rat.Num(), big.NewInt(pow10(scale)))
+	return fromUnits(roundQuotient(scaled, rat.Denom()))
+}
+
+func roundQuotient(numerator *big.Int, denominator *big.Int) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if remainder.Sign() == 0 {
+		return quotient
+	}
+	sign := numerator.Sign() * denominator.Sign()
+	doubled := new(big.Int).Abs(remainder)
+	doubled.Lsh(doubled, 1)
+	half := doubled.Cmp(new(big.Int).Abs(denominator))
+	awayFromZero := false
+	switch rounding {
+	case RoundDown:
+	case RoundUp:
+		awayFromZero = true
+	case RoundHalfUp:
+		awayFromZero = half >= 0
+	default:
+		awayFromZero = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+	}
+	if awayFromZero {
+		quotient.Add(quotient, big.NewInt(int64(sign)))
+	}
+	return quotient
+}
+
+func pow10(exponent int) int64 {
+	result := int64(1)
+	for i := 0; i < exponent; i++ {
+		result *= 10
+	}
+	return result
+}
+
+func (a Amount) MinorUnits() int64 {
+	return int64(a)
+}
+
+// Add, Sub, Neg и Abs не переполняются молча: результат за пределами int64 возвращается ошибкой.
+func (a Amount) Add(other Amount) (Amount, error) {
+	sum := a + other
+	if (other > 0 && sum < a) || (other < 0 && sum > a) {
+		return 0, errOutOfRange
+	}
+	return sum, nil
+}
+
+func (a Amount) Sub(other Amount) (Amount, error) {
+	difference := a - other
+	if (other > 0 && difference > a) || (other < 0 && difference < a) {
+		return 0, errOutOfRange
+	}
+	return difference, nil
+}
+
+func (a Amount) Neg() (Amount, error) {
+	if a == math.MinInt64 {
+		return 0, errOutOfRange
+	}
+	return -a, nil
+}
+
+func (a Amount) Abs() (Amount, error) {
+	if a < 0 {
+		return a.Neg()
+	}
+	return a, nil
+}
+
+func (a Amount) Cmp(other Amount) int {
+	switch {
+	case a < other:
+		return -1
+	case a > other:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (a Amount) IsZero() bool {
+	return a == 0
+}
+
+func (a Amount) IsPositive() bool {
+	return a > 0
+}
+
+func (a Amount) IsNegative() bool {
+	return a < 0
+}
+
+func Min(first Amount, second Amount) Amount {
+	if first < second {
+		return first
+	}
+	return second
+}
+
+func Max(first Amount, second Amount) Amount {
+	if first > second {
+		return first
+	}
+	return second
+}
+
+// MulRatio умножает сумму на дробь numerator/denominator с округлением по текущему режиму.
+func (a Amount) MulRatio(numerator int64, denominator int64) (Amount, error) {
+	if denominator == 0 {
+		return 0, fmt.Errorf("%w: zero denominator", ErrInvalidAmount)
+	}
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(numerator))
+	return fromUnits(roundQuotient(product, big.NewInt(denominator)))
+}
+
+// MulFactor умножает сумму на десятичный коэффициент, например "1.25".
+func (a Amount) MulFactor(factor string) (Amount, error) {
+	rat, err := parseDecimal(strings.TrimSpace(factor))
+	if err != nil {
+		return 0, fmt.Errorf("factor: %w", err)
+	}
+	product := new(big.Int).Mul(big.NewInt(int64(a)), rat.Num())
+	return fromUnits(roundQuotient(product, rat.Denom()))
+}
+
+func fromUnits(units *big.Int) (Amount, error) {
+	if !units.IsInt64() {
+		return 0, errOutOfRange
+	}
+	return Amount(units.Int64()), nil
+}
+
+func (a Amount) String() string {
+	// Модуль считается в uint64, иначе -MinInt64 переполнился бы
+	units := uint64(a)
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		units = -units
+	}
+	if scale == 0 {
+		return sign + strconv.FormatUint(units, 10)
+	}
+	divisor := uint64(pow10(scale))
+	return fmt.Sprintf("%s%d.%0*d", sign, units/divisor, scale, units%divisor)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON принимает JSON-число или строку с десятичной записью, а null оставляет сумму без изменений.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("%w: trailing data", ErrInvalidAmount)
+	}
+	var value string
+	switch typed := raw.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		value = typed.String()
+	case string:
+		value = typed
+	default:
+		return fmt.Errorf("%w: expected a number or a string, got %T", ErrInvalidAmount, raw)
+	}
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func (a *Amount) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		return fmt.Errorf("%w: NULL", ErrInvalidAmount)
+	case string:
+		parsed, err := Parse(value)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case []byte:
+		parsed, err := Parse(string(value))
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case int64:
+		parsed, err := FromInt(value)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case float64:
+		parsed, err := Parse(strconv.FormatFloat(value, 'f', -1, 64))
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+type NullAmount struct {
+	Amount Amount
+	Valid  bool
+}
+
+func (n *NullAmount) Scan(src any) error {
+	if src == nil {
+		n.Amount, n.Valid = 0, false
+		return nil
+	}
+	n.Valid = true
+	return n.Amount.Scan(src)
+}
+
+func (n NullAmount) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Amount.Value()
+}
@@ -0,0 +1,207 @@
+package money
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "integer", value: "751", want: 75100},
+		{name: "two decimals", value: "729.98", want: 72998},
+		{name: "one decimal", value: "0.5", want: 50},
+		{name: "surrounding spaces", value: " 10.10 ", want: 1010},
+		{name: "negative", value: "-0.05", want: -5},
+		{name: "explicit plus", value: "+3", want: 300},
+		{name: "half even rounds down to even", value: "1.005", want: 100},
+		{name: "half even rounds up to even", value: "1.015", want: 102},
+		{name: "above half rounds up", value: "1.0051", want: 101},
+		{name: "empty", value: "", wantErr: true},
+		{name: "letters", value: "abc", wantErr: true},
+		{name: "fraction", value: "1/3", wantErr: true},
+		{name: "exponent", value: "1e2", wantErr: true},
+		{name: "trailing dot", value: "1.", wantErr: true},
+		{name: "leading dot", value: ".5", wantErr: true},
+		{name: "out of range", value: "100000000000000000000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Amount
+		want   string
+	}{
+		{name: "zero", amount: 0, want: "0.00"},
+		{name: "whole", amount: 75100, want: "751.00"},
+		{name: "fraction", amount: 72998, want: "729.98"},
+		{name: "leading zero in fraction", amount: 1005, want: "10.05"},
+		{name: "small negative", amount: -5, want: "-0.05"},
+		{name: "negative", amount: -12345, want: "-123.45"},
+		{name: "max", amount: math.MaxInt64, want: "92233720368547758.07"},
+		{name: "min", amount: math.MinInt64, want: "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.amount.String())
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Amount
+	}{
+		{name: "number", json: `500`, want: 50000},
+		{name: "decimal number", json: `729.98`, want: 72998},
+		{name: "quoted", json: `"0.10"`, want: 10},
+		{name: "negative", json: `-1.5`, want: -150},
+		{name: "quoted with spaces", json: `" 2.5 "`, want: 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var amount Amount
+			require.NoError(t, json.Unmarshal([]byte(tt.json), &amount))
+			assert.Equal(t, tt.want, amount)
+
+			encoded, err := json.Marshal(amount)
+			require.NoError(t, err)
+			var decoded Amount
+			require.NoError(t, json.Unmarshal(encoded, &decoded))
+			assert.Equal(t, amount, decoded)
+		})
+	}
+}
+
+func TestUnmarshalJSONNull(t *testing.T) {
+	amount := MustParse("1")
+	require.NoError(t, amount.UnmarshalJSON([]byte(`null`)))
+	assert.Equal(t, MustParse("1"), amount)
+}
+
+func TestUnmarshalJSONRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{name: "exponent in string", json: `"1e2"`},
+		{name: "exponent number", json: `1e2`},
+		{name: "unterminated string", json: `"123`},
+		{name: "unopened string", json: `123"`},
+		{name: "quoted null", json: `"null"`},
+		{name: "boolean", json: `true`},
+		{name: "object", json: `{"sum": 1}`},
+		{name: "array", json: `[1]`},
+		{name: "trailing data", json: `1 2`},
+		{name: "empty", json: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount := MustParse("1")
+			assert.ErrorIs(t, amount.UnmarshalJSON([]byte(tt.json)), ErrInvalidAmount)
+			assert.Equal(t, MustParse("1"), amount)
+		})
+	}
+}
+
+func TestRepeatedSubLeavesNoResidue(t *testing.T) {
+	balance := MustParse("100")
+	step := MustParse("0.10")
+	var err error
+	for i := 0; i < 1000; i++ {
+		balance, err = balance.Sub(step)
+		require.NoError(t, err)
+	}
+	assert.True(t, balance.IsZero())
+	assert.Equal(t, "0.00", balance.String())
+
+	balance = MustParse("1")
+	third := MustParse("0.33")
+	for i := 0; i < 3; i++ {
+		balance, err = balance.Sub(third)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "0.01", balance.String())
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func() (Amount, error)
+		want    Amount
+		wantErr bool
+	}{
+		{name: "add", op: func() (Amount, error) { return Amount(1).Add(2) }, want: 3},
+		{name: "add negative", op: func() (Amount, error) { return Amount(1).Add(-2) }, want: -1},
+		{name: "add overflow", op: func() (Amount, error) { return Amount(math.MaxInt64).Add(1) }, wantErr: true},
+		{name: "add underflow", op: func() (Amount, error) { return Amount(math.MinInt64).Add(-1) }, wantErr: true},
+		{name: "sub", op: func() (Amount, error) { return Amount(1).Sub(2) }, want: -1},
+		{name: "sub overflow", op: func() (Amount, error) { return Amount(math.MaxInt64).Sub(-1) }, wantErr: true},
+		{name: "sub underflow", op: func() (Amount, error) { return Amount(math.MinInt64).Sub(1) }, wantErr: true},
+		{name: "sub to min", op: func() (Amount, error) { return Amount(-1).Sub(math.MaxInt64) }, want: math.MinInt64},
+		{name: "neg", op: func() (Amount, error) { return Amount(5).Neg() }, want: -5},
+		{name: "neg max", op: func() (Amount, error) { return Amount(math.MaxInt64).Neg() }, want: -math.MaxInt64},
+		{name: "neg min", op: func() (Amount, error) { return Amount(math.MinInt64).Neg() }, wantErr: true},
+		{name: "abs", op: func() (Amount, error) { return Amount(-5).Abs() }, want: 5},
+		{name: "abs min", op: func() (Amount, error) { return Amount(math.MinInt64).Abs() }, wantErr: true},
+		{name: "from int", op: func() (Amount, error) { return FromInt(751) }, want: 75100},
+		{name: "from int negative", op: func() (Amount, error) { return FromInt(-3) }, want: -300},
+		{name: "from int overflow", op: func() (Amount, error) { return FromInt(math.MaxInt64 / 10) }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMulFactor(t *testing.T) {
+	got, err := MustParse("100").MulFactor("1.25")
+	require.NoError(t, err)
+	assert.Equal(t, "125.00", got.String())
+
+	_, err = MustParse("100").MulFactor("1/3")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+
+	_, err = Amount(math.MaxInt64).MulFactor("2")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
+
+func TestMulRatio(t *testing.T) {
+	got, err := MustParse("10").MulRatio(1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "3.33", got.String())
+
+	_, err = Amount(math.MaxInt64).MulRatio(3, 2)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+
+	_, err = MustParse("10").MulRatio(1, 0)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/ClearThree/gophermart-bonus/internal/app/config"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
 	"net/http"
@@ -12,9 +13,9 @@ import (
 )
 
 type ExternalOrder struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual"`
 }
 
 type AccrualRepositoryInterface interface {
@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+// BalanceAdjustment — ручная корректировка баланса. Amount со знаком: начисление положительное, списание отрицательное.
+type BalanceAdjustment struct {
+	ID         uint64
+	UserID     uint64
+	Amount     money.Amount
+	ReasonCode string
+	Comment    string
+	Forced     bool
+	CreatedBy  uint64
+	CreatedAt  time.Time
+}
+
+type BalanceAdjustmentRepositoryInterface interface {
+	Create(ctx context.Context, adjustment BalanceAdjustment) (BalanceAdjustment, error)
+	ReadAllByUserID(ctx context.Context, userID uint64) ([]BalanceAdjustment, error)
+}
+
+type BalanceAdjustmentRepository struct {
+	pool *sql.DB
+}
+
+func NewBalanceAdjustmentRepository(pool *sql.DB) *BalanceAdjustmentRepository {
+	return &BalanceAdjustmentRepository{pool: pool}
+}
+
+// Create записывает корректировку и проводит её по журналу с кодом причины в reference.
+// Списание сверх доступных баллов (с учётом резервов) проходит только с Forced.
+func (b BalanceAdjustmentRepository) Create(
+	ctx context.Context, adjustment BalanceAdjustment) (BalanceAdjustment, error) {
+	transaction, txErr := b.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return BalanceAdjustment{}, txErr
+	}
+	if err := lockUserBalance(ctx, transaction, adjustment.UserID); err != nil {
+		return BalanceAdjustment{}, rollbackWithError(transaction, err)
+	}
+	if adjustment.Amount.IsNegative() && !adjustment.Forced {
+		available, err := readAvailablePoints(ctx, transaction, adjustment.UserID, DefaultWallet)
+		if err != nil {
+			return BalanceAdjustment{}, rollbackWithError(transaction, err)
+		}
+		requested, err := adjustment.Amount.Neg()
+		if err != nil {
+			return BalanceAdjustment{}, rollbackWithError(transaction, err)
+		}
+		if requested.Cmp(available) > 0 {
+			logger.Log.Infof("Adjustment would overdraw balance of user %d", adjustment.UserID)
+			return BalanceAdjustment{}, rollbackWithError(transaction, ErrNotEnoughPoints)
+		}
+	}
+
+	createAdjustmentPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "balance_adjustment" (user_id, amount, reason_code, comment, forced, created_by)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING id, created_at`)
+	if err != nil {
+		return BalanceAdjustment{}, rollbackWithError(transaction, err)
+	}
+	err = createAdjustmentPreparedStmt.QueryRowContext(
+		ctx,
+		adjustment.UserID,
+		adjustment.Amount,
+		adjustment.ReasonCode,
+		adjustment.Comment,
+		adjustment.Forced,
+		adjustment.CreatedBy,
+	).Scan(&adjustment.ID, &adjustment.CreatedAt)
+	if err != nil {
+		logger.Log.Warnf("Error creating adjustment for user %d, err %v", adjustment.UserID, err)
+		return BalanceAdjustment{}, rollbackWithError(transaction, err)
+	}
+	posting := LedgerPosting{
+		UserID:    adjustment.UserID,
+		Kind:      LedgerKindAdjustment,
+		Amount:    adjustment.Amount,
+		Reference: adjustment.ReasonCode,
+		SourceID:  adjustment.ID,
+	}
+	if adjustment.Amount.IsPositive() {
+		_, err = creditPoints(ctx, transaction, posting, sql.NullInt64{}, sql.NullTime{})
+	} else {
+		_, err = debitPoints(ctx, transaction, posting, adjustment.Forced)
+	}
+	if err != nil {
+		logger.Log.Warnf("Error posting adjustment %d to ledger, err %v", adjustment.ID, err)
+		return BalanceAdjustment{}, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return BalanceAdjustment{}, txErr
+	}
+	return adjustment, nil
+}
+
+func (b BalanceAdjustmentRepository) ReadAllByUserID(ctx context.Context, userID uint64) ([]BalanceAdjustment, error) {
+	selectAdjustmentsPreparedStmt, err := b.pool.PrepareContext(
+		ctx,
+		`SELECT id, user_id, amount, reason_code, comment, forced, created_by, created_at
+				FROM "balance_adjustment"
+				WHERE user_id = $1
+				ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectAdjustmentsPreparedStmt.QueryContext(ctx, userID)
+	if err != nil {
+		logger.Log.Errorf("error during adjustments selection: %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var adjustments []BalanceAdjustment
+	for rows.Next() {
+		adjustment := new(BalanceAdjustment)
+		scanErr := rows.Scan(
+			&adjustment.ID,
+			&adjustment.UserID,
+			&adjustment.Amount,
+			&adjustment.ReasonCode,
+			&adjustment.Comment,
+			&adjustment.Forced,
+			&adjustment.CreatedBy,
+			&adjustment.CreatedAt,
+		)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		adjustments = append(adjustments, *adjustment)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return adjustments, nil
+}
@@ -0,0 +1,240 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+// BalanceHistoryEntry — одна операция по счёту пользователя и баланс сразу после неё.
+type BalanceHistoryEntry struct {
+	ID        uint64
+	Wallet    string
+	Kind      string
+	Reference string
+	Amount    money.Amount
+	Balance   money.Amount
+	CreatedAt time.Time
+}
+
+// BalanceHistoryFilter задаёт полуинтервал [From, To), кошелёк, порядок и страницу.
+// Нулевые границы не ограничивают выборку, пустой кошелёк означает все кошельки.
+// По умолчанию операции идут в хронологическом порядке, Descending — от новых к старым.
+type BalanceHistoryFilter struct {
+	Wallet     string
+	From       time.Time
+	To         time.Time
+	Descending bool
+	Limit      uint64
+	Offset     uint64
+}
+
+type BalanceHistoryRepositoryInterface interface {
+	ReadHistory(ctx context.Context, userID uint64, filter BalanceHistoryFilter) ([]BalanceHistoryEntry, uint64, error)
+	ReadBalanceAt(ctx context.Context, userID uint64, wallet string, at time.Time) (money.Amount, error)
+	StreamPeriod(
+		ctx context.Context,
+		userID uint64,
+		wallet string,
+		from time.Time,
+		to time.Time,
+		consume func(entry BalanceHistoryEntry) error) error
+}
+
+type BalanceHistoryRepository struct {
+	pool *sql.DB
+}
+
+func NewBalanceHistoryRepository(pool *sql.DB) *BalanceHistoryRepository {
+	return &BalanceHistoryRepository{pool: pool}
+}
+
+// Нарастающий итог считается по всей истории кошелька до применения фильтра по датам,
+// иначе баланс на первой строке страницы не учитывал бы более ранние операции.
+const balanceHistoryTimelineQuery = `
+	WITH timeline AS (
+		SELECT t.id, t.wallet, t.kind, COALESCE(t.reference, '') AS reference, t.created_at, e.amount,
+			SUM(e.amount) OVER (PARTITION BY t.wallet ORDER BY t.created_at, t.id) AS balance
+		FROM "ledger_entry" e JOIN "ledger_transaction" t ON t.id = e.transaction_id
+		WHERE e.user_id = $1 AND e.account = $2
+	), filtered AS (
+		SELECT *
+		FROM timeline
+		WHERE ($3::TIMESTAMP IS NULL OR created_at >= $3::TIMESTAMP)
+			AND ($4::TIMESTAMP IS NULL OR created_at < $4::TIMESTAMP)
+			AND ($5::TEXT IS NULL OR wallet = $5::TEXT)
+	)`
+
+// Общее число считается отдельным запросом: оконный COUNT на странице за пределами выборки дал бы 0.
+var countBalanceHistoryQuery = balanceHistoryTimelineQuery + `
+	SELECT COUNT(*) FROM filtered`
+
+var selectBalanceHistoryAscQuery = balanceHistoryTimelineQuery + `
+	SELECT id, wallet, kind, reference, amount, balance, created_at
+	FROM filtered
+	ORDER BY created_at, id
+	LIMIT $6 OFFSET $7`
+
+var selectBalanceHistoryDescQuery = balanceHistoryTimelineQuery + `
+	SELECT id, wallet, kind, reference, amount, balance, created_at
+	FROM filtered
+	ORDER BY created_at DESC, id DESC
+	LIMIT $6 OFFSET $7`
+
+// ReadHistory читает страницу истории и общее число операций в одном снимке данных.
+func (b BalanceHistoryRepository) ReadHistory(
+	ctx context.Context, userID uint64, filter BalanceHistoryFilter) ([]BalanceHistoryEntry, uint64, error) {
+	transaction, txErr := b.pool.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if txErr != nil {
+		return nil, 0, txErr
+	}
+	filterArgs := []any{
+		userID,
+		ledgerAccountUser,
+		nullTime(filter.From),
+		nullTime(filter.To),
+		sql.NullString{String: filter.Wallet, Valid: filter.Wallet != ""},
+	}
+	countHistoryPreparedStmt, err := transaction.PrepareContext(ctx, countBalanceHistoryQuery)
+	if err != nil {
+		logger.Log.Warnf("Error preparing balance history count query, err %v", err)
+		return nil, 0, rollbackWithError(transaction, err)
+	}
+	var total uint64
+	if err = countHistoryPreparedStmt.QueryRowContext(ctx, filterArgs...).Scan(&total); err != nil {
+		logger.Log.Warnf("Error counting balance history of user %d, err %v", userID, err)
+		return nil, 0, rollbackWithError(transaction, err)
+	}
+	entries, err := readBalanceHistoryPage(ctx, transaction, filter, filterArgs)
+	if err != nil {
+		logger.Log.Warnf("Error selecting balance history of user %d, err %v", userID, err)
+		return nil, 0, rollbackWithError(transaction, err)
+	}
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return nil, 0, txErr
+	}
+	return entries, total, nil
+}
+
+func readBalanceHistoryPage(
+	ctx context.Context,
+	transaction *sql.Tx,
+	filter BalanceHistoryFilter,
+	filterArgs []any) ([]BalanceHistoryEntry, error) {
+	query := selectBalanceHistoryAscQuery
+	if filter.Descending {
+		query = selectBalanceHistoryDescQuery
+	}
+	selectHistoryPreparedStmt, err := transaction.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectHistoryPreparedStmt.QueryContext(ctx, append(filterArgs, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var entries []BalanceHistoryEntry
+	for rows.Next() {
+		entry := new(BalanceHistoryEntry)
+		scanErr := rows.Scan(
+			&entry.ID,
+			&entry.Wallet,
+			&entry.Kind,
+			&entry.Reference,
+			&entry.Amount,
+			&entry.Balance,
+			&entry.CreatedAt,
+		)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		entries = append(entries, *entry)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return entries, nil
+}
+
+// ReadBalanceAt возвращает баланс кошелька на момент at (без операций, совершённых в этот момент и позже).
+func (b BalanceHistoryRepository) ReadBalanceAt(
+	ctx context.Context, userID uint64, wallet string, at time.Time) (money.Amount, error) {
+	selectBalancePreparedStmt, err := b.pool.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(e.amount), 0)
+				FROM "ledger_entry" e JOIN "ledger_transaction" t ON t.id = e.transaction_id
+				WHERE e.user_id = $1 AND e.account = $2 AND t.wallet = $4 AND t.created_at < $3::TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+	var balance money.Amount
+	err = selectBalancePreparedStmt.QueryRowContext(
+		ctx, userID, ledgerAccountUser, at, walletOrDefault(wallet)).Scan(&balance)
+	if err != nil {
+		logger.Log.Warnf("Error selecting balance of user %d at %s, err %v", userID, at, err)
+		return 0, err
+	}
+	return balance, nil
+}
+
+// StreamPeriod отдаёт операции кошелька за полуинтервал [from, to) в хронологическом порядке.
+func (b BalanceHistoryRepository) StreamPeriod(
+	ctx context.Context,
+	userID uint64,
+	wallet string,
+	from time.Time,
+	to time.Time,
+	consume func(entry BalanceHistoryEntry) error) error {
+	selectPeriodPreparedStmt, err := b.pool.PrepareContext(
+		ctx,
+		`SELECT t.id, t.wallet, t.kind, COALESCE(t.reference, ''), e.amount, t.created_at
+				FROM "ledger_entry" e JOIN "ledger_transaction" t ON t.id = e.transaction_id
+				WHERE e.user_id = $1 AND e.account = $2 AND t.wallet = $5
+					AND t.created_at >= $3::TIMESTAMP AND t.created_at < $4::TIMESTAMP
+				ORDER BY t.created_at, t.id`)
+	if err != nil {
+		return err
+	}
+	rows, err := selectPeriodPreparedStmt.QueryContext(
+		ctx, userID, ledgerAccountUser, from, to, walletOrDefault(wallet))
+	if err != nil {
+		logger.Log.Warnf("Error selecting ledger period of user %d, err %v", userID, err)
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	for rows.Next() {
+		entry := new(BalanceHistoryEntry)
+		scanErr := rows.Scan(
+			&entry.ID, &entry.Wallet, &entry.Kind, &entry.Reference, &entry.Amount, &entry.CreatedAt)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return scanErr
+		}
+		if err = consume(*entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func nullTime(value time.Time) sql.NullTime {
+	if value.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: value, Valid: true}
+}
@@ -0,0 +1,321 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Campaign — промо-акция с ограниченным сроком. Задаётся либо множитель к базовому начислению (Multiplier),
+// либо фиксированный бонус за заказ (FixedBonus). Пустые Tiers и UserIDs означают, что акция действует для всех.
+type Campaign struct {
+	ID         uint64
+	Name       string
+	StartsAt   time.Time
+	EndsAt     time.Time
+	Multiplier string
+	FixedBonus money.NullAmount
+	Active     bool
+	Tiers      []string
+	UserIDs    []uint64
+	CreatedBy  uint64
+	CreatedAt  time.Time
+}
+
+type CampaignRepositoryInterface interface {
+	Create(ctx context.Context, campaign Campaign) (Campaign, error)
+	ReadAll(ctx context.Context) ([]Campaign, error)
+	Deactivate(ctx context.Context, campaignID uint64) error
+	ApplyToAccrual(ctx context.Context, transaction *sql.Tx, accrual ProcessedAccrual) error
+}
+
+var ErrCampaignNotFound = errors.New("campaign not found")
+var ErrCampaignUserNotFound = errors.New("campaign participant not found")
+
+type CampaignRepository struct {
+	pool *sql.DB
+}
+
+func NewCampaignRepository(pool *sql.DB) *CampaignRepository {
+	return &CampaignRepository{pool: pool}
+}
+
+func (c CampaignRepository) Create(ctx context.Context, campaign Campaign) (Campaign, error) {
+	var multiplier sql.NullString
+	if campaign.Multiplier != "" {
+		multiplier = sql.NullString{String: campaign.Multiplier, Valid: true}
+	}
+	transaction, txErr := c.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Campaign{}, txErr
+	}
+	createCampaignPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "campaign" (name, starts_at, ends_at, multiplier, fixed_bonus, created_by)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING id, active, created_at`)
+	if err != nil {
+		return Campaign{}, rollbackWithError(transaction, err)
+	}
+	err = createCampaignPreparedStmt.QueryRowContext(
+		ctx,
+		campaign.Name,
+		campaign.StartsAt,
+		campaign.EndsAt,
+		multiplier,
+		campaign.FixedBonus,
+		campaign.CreatedBy,
+	).Scan(&campaign.ID, &campaign.Active, &campaign.CreatedAt)
+	if err != nil {
+		logger.Log.Warnf("Error creating campaign %q, err %v", campaign.Name, err)
+		return Campaign{}, rollbackWithError(transaction, err)
+	}
+
+	if len(campaign.Tiers) > 0 {
+		createTierPreparedStmt, prepareErr := transaction.PrepareContext(
+			ctx, `INSERT INTO "campaign_tier" (campaign_id, tier) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+		if prepareErr != nil {
+			return Campaign{}, rollbackWithError(transaction, prepareErr)
+		}
+		for _, tier := range campaign.Tiers {
+			if _, err = createTierPreparedStmt.ExecContext(ctx, campaign.ID, tier); err != nil {
+				return Campaign{}, rollbackWithError(transaction, err)
+			}
+		}
+	}
+	if len(campaign.UserIDs) > 0 {
+		createUserPreparedStmt, prepareErr := transaction.PrepareContext(
+			ctx, `INSERT INTO "campaign_user" (campaign_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+		if prepareErr != nil {
+			return Campaign{}, rollbackWithError(transaction, prepareErr)
+		}
+		for _, userID := range campaign.UserIDs {
+			if _, err = createUserPreparedStmt.ExecContext(ctx, campaign.ID, userID); err != nil {
+				var pgErr *pgconn.PgError
+				if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+					err = ErrCampaignUserNotFound
+				}
+				return Campaign{}, rollbackWithError(transaction, err)
+			}
+		}
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return Campaign{}, txErr
+	}
+	return campaign, nil
+}
+
+func (c CampaignRepository) ReadAll(ctx context.Context) ([]Campaign, error) {
+	selectCampaignsPreparedStmt, err := c.pool.PrepareContext(
+		ctx,
+		`SELECT c.id, c.name, c.starts_at, c.ends_at, c.multiplier::TEXT, c.fixed_bonus, c.active,
+					COALESCE((SELECT string_agg(ct.tier, ',' ORDER BY ct.tier)
+						FROM "campaign_tier" ct WHERE ct.campaign_id = c.id), ''),
+					COALESCE((SELECT string_agg(cu.user_id::TEXT, ',' ORDER BY cu.user_id)
+						FROM "campaign_user" cu WHERE cu.campaign_id = c.id), ''),
+					c.created_by, c.created_at
+				FROM "campaign" c
+				ORDER BY c.starts_at DESC, c.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectCampaignsPreparedStmt.QueryContext(ctx)
+	if err != nil {
+		logger.Log.Errorf("error during campaigns selection: %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var campaigns []Campaign
+	for rows.Next() {
+		campaign := new(Campaign)
+		var multiplier sql.NullString
+		var tiers, userIDs string
+		scanErr := rows.Scan(
+			&campaign.ID,
+			&campaign.Name,
+			&campaign.StartsAt,
+			&campaign.EndsAt,
+			&multiplier,
+			&campaign.FixedBonus,
+			&campaign.Active,
+			&tiers,
+			&userIDs,
+			&campaign.CreatedBy,
+			&campaign.CreatedAt,
+		)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		campaign.Multiplier = multiplier.String
+		if tiers != "" {
+			campaign.Tiers = strings.Split(tiers, ",")
+		}
+		if userIDs != "" {
+			for _, value := range strings.Split(userIDs, ",") {
+				userID, parseErr := strconv.ParseUint(value, 10, 64)
+				if parseErr != nil {
+					return nil, parseErr
+				}
+				campaign.UserIDs = append(campaign.UserIDs, userID)
+			}
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return campaigns, nil
+}
+
+// Deactivate выключает акцию. Уже начисленные бонусы остаются у пользователей.
+func (c CampaignRepository) Deactivate(ctx context.Context, campaignID uint64) error {
+	deactivatePreparedStmt, err := c.pool.PrepareContext(
+		ctx, `UPDATE "campaign" SET active = False WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	result, err := deactivatePreparedStmt.ExecContext(ctx, campaignID)
+	if err != nil {
+		logger.Log.Warnf("Error deactivating campaign %d, err %v", campaignID, err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrCampaignNotFound
+	}
+	return nil
+}
+
+type matchedCampaign struct {
+	id         uint64
+	multiplier sql.NullString
+	fixedBonus money.NullAmount
+}
+
+// ApplyToAccrual начисляет бонусы всех активных акций, в период которых был загружен заказ.
+// Каждый бонус проводится отдельной операцией и сгорает вместе с основным начислением.
+// Начисления в партнёрские кошельки акциями не поощряются.
+// Подходит для передачи в NewOrderRepository как AccrualHook.
+func (c CampaignRepository) ApplyToAccrual(ctx context.Context, transaction *sql.Tx, accrual ProcessedAccrual) error {
+	if accrual.Wallet != DefaultWallet {
+		return nil
+	}
+	campaigns, err := readMatchingCampaigns(ctx, transaction, accrual)
+	if err != nil {
+		return err
+	}
+	if len(campaigns) == 0 {
+		return nil
+	}
+	createBonusPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "campaign_bonus" (campaign_id, order_id, user_id, accrual_id, amount)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (campaign_id, order_id) DO NOTHING
+				RETURNING id`)
+	if err != nil {
+		return err
+	}
+	for _, campaign := range campaigns {
+		bonus := campaign.fixedBonus.Amount
+		if campaign.multiplier.Valid {
+			multiplied, mulErr := accrual.BaseAmount.MulFactor(campaign.multiplier.String)
+			if mulErr != nil {
+				return mulErr
+			}
+			bonus, err = multiplied.Sub(accrual.BaseAmount)
+			if err != nil {
+				return err
+			}
+		}
+		if !bonus.IsPositive() {
+			continue
+		}
+		var bonusID uint64
+		err = createBonusPreparedStmt.QueryRowContext(
+			ctx, campaign.id, accrual.OrderID, accrual.UserID, accrual.AccrualID, bonus).Scan(&bonusID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			logger.Log.Warnf("Error inserting bonus of campaign %d for order %s, err %v",
+				campaign.id, accrual.OrderNumber, err)
+			return err
+		}
+		_, err = creditPoints(ctx, transaction, LedgerPosting{
+			UserID:    accrual.UserID,
+			Kind:      LedgerKindCampaign,
+			Amount:    bonus,
+			Reference: accrual.OrderNumber,
+			SourceID:  bonusID,
+		}, sql.NullInt64{}, accrual.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		logger.Log.Infof("Campaign %d added %s points for order %s", campaign.id, bonus, accrual.OrderNumber)
+	}
+	return nil
+}
+
+func readMatchingCampaigns(
+	ctx context.Context, transaction *sql.Tx, accrual ProcessedAccrual) ([]matchedCampaign, error) {
+	selectCampaignsPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT c.id, c.multiplier::TEXT, c.fixed_bonus
+				FROM "campaign" c
+					JOIN "order" o ON o.id = $1
+					JOIN "user" u ON u.id = $2
+				WHERE c.active
+					AND o.created_at >= c.starts_at AND o.created_at < c.ends_at
+					AND (
+						(NOT EXISTS (SELECT 1 FROM "campaign_tier" ct WHERE ct.campaign_id = c.id)
+							AND NOT EXISTS (SELECT 1 FROM "campaign_user" cu WHERE cu.campaign_id = c.id))
+						OR EXISTS (SELECT 1 FROM "campaign_tier" ct WHERE ct.campaign_id = c.id AND ct.tier = u.tier)
+						OR EXISTS (SELECT 1 FROM "campaign_user" cu WHERE cu.campaign_id = c.id AND cu.user_id = u.id)
+					)
+				ORDER BY c.id`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectCampaignsPreparedStmt.QueryContext(ctx, accrual.OrderID, accrual.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var campaigns []matchedCampaign
+	for rows.Next() {
+		var campaign matchedCampaign
+		if scanErr := rows.Scan(&campaign.id, &campaign.multiplier, &campaign.fixedBonus); scanErr != nil {
+			return nil, scanErr
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return campaigns, nil
+}
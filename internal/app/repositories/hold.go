@@ -0,0 +1,412 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"slices"
+	"time"
+)
+
+const (
+	HoldStatusHeld     = "HELD"
+	HoldStatusCaptured = "CAPTURED"
+	HoldStatusVoided   = "VOIDED"
+	HoldStatusExpired  = "EXPIRED"
+)
+
+type Hold struct {
+	ID           uint64
+	UserID       uint64
+	Wallet       string
+	OrderNumber  string
+	Amount       money.Amount
+	Status       string
+	ExpiresAt    time.Time
+	WithdrawalID sql.NullInt64
+	CreatedAt    time.Time
+}
+
+type HoldRepositoryInterface interface {
+	Create(
+		ctx context.Context,
+		number string,
+		amount money.Amount,
+		wallet string,
+		userID uint64,
+		ttl time.Duration,
+		caps WithdrawalCaps) (Hold, error)
+	Capture(ctx context.Context, holdID uint64, userID uint64) (Hold, error)
+	Void(ctx context.Context, holdID uint64, userID uint64) (Hold, error)
+	ExpireStale(ctx context.Context) (int64, error)
+}
+
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldNotActive = errors.New("hold is not active anymore")
+var ErrHoldAlreadyExists = errors.New("active hold for the order number already exists")
+
+var holdColumns = `id, user_id, wallet, order_number, amount, status, expires_at, withdrawal_id, created_at`
+
+type HoldRepository struct {
+	pool *sql.DB
+}
+
+func NewHoldRepository(pool *sql.DB) *HoldRepository {
+	return &HoldRepository{pool: pool}
+}
+
+func (h HoldRepository) Create(
+	ctx context.Context,
+	number string,
+	amount money.Amount,
+	wallet string,
+	userID uint64,
+	ttl time.Duration,
+	caps WithdrawalCaps) (Hold, error) {
+	wallet = walletOrDefault(wallet)
+	transaction, txErr := h.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Hold{}, txErr
+	}
+	err := lockUserBalance(ctx, transaction, userID)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	withdrawalExists, err := withdrawalNumberExists(ctx, transaction, number)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	if withdrawalExists {
+		return Hold{}, rollbackWithError(transaction, ErrWithdrawalOrderAlreadyExists)
+	}
+	available, err := readAvailablePoints(ctx, transaction, userID, wallet)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	if amount.Cmp(available) > 0 {
+		logger.Log.Infof("Insufficient points for hold of user %d, order %s", userID, number)
+		return Hold{}, rollbackWithError(transaction, ErrNotEnoughPoints)
+	}
+	if err = checkWithdrawalCaps(ctx, transaction, userID, wallet, amount, caps); err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+
+	// Просроченный, но ещё не снятый фоновой задачей резерв не должен мешать повторному резервированию
+	expireOrderHoldsPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`UPDATE "withdrawal_hold" SET status = $1, modified_at = NOW()
+				WHERE order_number = $2 AND status = $3 AND expires_at <= NOW()`)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	_, err = expireOrderHoldsPreparedStmt.ExecContext(ctx, HoldStatusExpired, number, HoldStatusHeld)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+
+	createHoldPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "withdrawal_hold" (user_id, order_number, amount, expires_at, wallet)
+				VALUES ($1, $2, $3, NOW() + make_interval(secs => $4), $5)
+				RETURNING `+holdColumns)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	hold, err := scanHold(createHoldPreparedStmt.QueryRowContext(
+		ctx, userID, number, amount, ttl.Seconds(), wallet))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+			return Hold{}, rollbackWithError(transaction, ErrHoldAlreadyExists)
+		}
+		logger.Log.Warnf("Error creating hold for user %d, err %v", userID, err)
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return Hold{}, txErr
+	}
+	return hold, nil
+}
+
+// Capture превращает резерв в обычное списание на ту же сумму и тот же номер заказа.
+func (h HoldRepository) Capture(ctx context.Context, holdID uint64, userID uint64) (Hold, error) {
+	transaction, txErr := h.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Hold{}, txErr
+	}
+	err := lockUserBalance(ctx, transaction, userID)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	hold, err := lockActiveHold(ctx, transaction, holdID, userID)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	// Сгорание, которое фоновая задача ещё не провела, может снять и этот резерв
+	voided, err := expireDueLots(ctx, transaction, userID, hold.Wallet)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	if slices.Contains(voided, holdID) {
+		if txErr = transaction.Commit(); txErr != nil {
+			return Hold{}, txErr
+		}
+		return Hold{}, ErrHoldNotActive
+	}
+	// Сам резерв уже учтён в доступном остатке, поэтому проверяем общий баланс по журналу
+	balance, _, err := readLedgerBalances(ctx, transaction, userID, hold.Wallet)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	if hold.Amount.Cmp(balance) > 0 {
+		return Hold{}, rollbackWithError(transaction, ErrNotEnoughPoints)
+	}
+	withdrawalID, err := createWithdrawal(ctx, transaction, hold.OrderNumber, hold.Amount, hold.Wallet, userID)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	hold, err = updateHoldStatus(
+		ctx, transaction, holdID, HoldStatusCaptured, sql.NullInt64{Int64: int64(withdrawalID), Valid: true})
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return Hold{}, txErr
+	}
+	return hold, nil
+}
+
+func (h HoldRepository) Void(ctx context.Context, holdID uint64, userID uint64) (Hold, error) {
+	transaction, txErr := h.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Hold{}, txErr
+	}
+	_, err := lockActiveHold(ctx, transaction, holdID, userID)
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	hold, err := updateHoldStatus(ctx, transaction, holdID, HoldStatusVoided, sql.NullInt64{})
+	if err != nil {
+		return Hold{}, rollbackWithError(transaction, err)
+	}
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return Hold{}, txErr
+	}
+	return hold, nil
+}
+
+func (h HoldRepository) ExpireStale(ctx context.Context) (int64, error) {
+	expireHoldsPreparedStmt, err := h.pool.PrepareContext(
+		ctx,
+		`UPDATE "withdrawal_hold" SET status = $1, modified_at = NOW() WHERE status = $2 AND expires_at <= NOW()`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for expiring holds, err %v", err)
+		return 0, err
+	}
+	result, err := expireHoldsPreparedStmt.ExecContext(ctx, HoldStatusExpired, HoldStatusHeld)
+	if err != nil {
+		logger.Log.Warnf("Error expiring holds, err %v", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func lockActiveHold(ctx context.Context, transaction *sql.Tx, holdID uint64, userID uint64) (Hold, error) {
+	lockHoldPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT `+holdColumns+`, expires_at > NOW()
+				FROM "withdrawal_hold"
+				WHERE id = $1 AND user_id = $2
+				FOR UPDATE`)
+	if err != nil {
+		return Hold{}, err
+	}
+	var hold Hold
+	var unexpired bool
+	err = lockHoldPreparedStmt.QueryRowContext(ctx, holdID, userID).Scan(
+		&hold.ID,
+		&hold.UserID,
+		&hold.Wallet,
+		&hold.OrderNumber,
+		&hold.Amount,
+		&hold.Status,
+		&hold.ExpiresAt,
+		&hold.WithdrawalID,
+		&hold.CreatedAt,
+		&unexpired,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Hold{}, ErrHoldNotFound
+		}
+		return Hold{}, err
+	}
+	if hold.Status != HoldStatusHeld || !unexpired {
+		return Hold{}, ErrHoldNotActive
+	}
+	return hold, nil
+}
+
+func updateHoldStatus(
+	ctx context.Context, transaction *sql.Tx, holdID uint64, status string, withdrawalID sql.NullInt64) (Hold, error) {
+	updateHoldPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`UPDATE "withdrawal_hold"
+				SET status = $1, withdrawal_id = $2, modified_at = NOW()
+				WHERE id = $3
+				RETURNING `+holdColumns)
+	if err != nil {
+		return Hold{}, err
+	}
+	return scanHold(updateHoldPreparedStmt.QueryRowContext(ctx, status, withdrawalID, holdID))
+}
+
+func scanHold(row *sql.Row) (Hold, error) {
+	var hold Hold
+	err := row.Scan(
+		&hold.ID,
+		&hold.UserID,
+		&hold.Wallet,
+		&hold.OrderNumber,
+		&hold.Amount,
+		&hold.Status,
+		&hold.ExpiresAt,
+		&hold.WithdrawalID,
+		&hold.CreatedAt,
+	)
+	return hold, err
+}
+
+// voidUncoveredHolds снимает действующие резервы кошелька, начиная с самых новых, пока их сумма
+// не станет покрываться балансом без сгоревших баллов, и возвращает идентификаторы снятых.
+// Вызывается под блокировкой баланса пользователя после сгорания баллов.
+func voidUncoveredHolds(ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) ([]uint64, error) {
+	balance, _, err := readLedgerBalances(ctx, transaction, userID, wallet)
+	if err != nil {
+		return nil, err
+	}
+	overdue, err := readOverduePoints(ctx, transaction, userID, wallet)
+	if err != nil {
+		return nil, err
+	}
+	if balance, err = balance.Sub(overdue); err != nil {
+		return nil, err
+	}
+	selectHoldsPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT id, amount
+				FROM "withdrawal_hold"
+				WHERE user_id = $1 AND wallet = $2 AND status = $3 AND expires_at > NOW()
+				ORDER BY created_at DESC, id DESC
+				FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectHoldsPreparedStmt.QueryContext(ctx, userID, walletOrDefault(wallet), HoldStatusHeld)
+	if err != nil {
+		return nil, err
+	}
+	var holds []Hold
+	held := money.Amount(0)
+	for rows.Next() {
+		hold := Hold{}
+		if scanErr := rows.Scan(&hold.ID, &hold.Amount); scanErr != nil {
+			return nil, errors.Join(scanErr, rows.Close())
+		}
+		holds = append(holds, hold)
+		if held, err = held.Add(hold.Amount); err != nil {
+			return nil, errors.Join(err, rows.Close())
+		}
+	}
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return nil, err
+	}
+
+	var voided []uint64
+	for _, hold := range holds {
+		if held.Cmp(balance) <= 0 {
+			break
+		}
+		if _, err = updateHoldStatus(ctx, transaction, hold.ID, HoldStatusVoided, sql.NullInt64{}); err != nil {
+			return nil, err
+		}
+		logger.Log.Infof("Voided hold %d of user %d: expired points no longer cover it", hold.ID, userID)
+		voided = append(voided, hold.ID)
+		if held, err = held.Sub(hold.Amount); err != nil {
+			return nil, err
+		}
+	}
+	return voided, nil
+}
+
+// readHeldPoints возвращает сумму действующих резервов пользователя в кошельке.
+func readHeldPoints(ctx context.Context, db preparer, userID uint64, wallet string) (money.Amount, error) {
+	selectHeldPreparedStmt, err := db.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(amount), 0)
+				FROM "withdrawal_hold"
+				WHERE user_id = $1 AND wallet = $3 AND status = $2 AND expires_at > NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	var held money.Amount
+	err = selectHeldPreparedStmt.QueryRowContext(ctx, userID, HoldStatusHeld, walletOrDefault(wallet)).Scan(&held)
+	if err != nil {
+		return 0, err
+	}
+	return held, nil
+}
+
+// readAvailablePoints возвращает баланс кошелька по журналу за вычетом действующих резервов
+// и сгоревших партий, сгорание которых фоновая задача ещё не провела.
+func readAvailablePoints(
+	ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) (money.Amount, error) {
+	balance, _, err := readLedgerBalances(ctx, transaction, userID, wallet)
+	if err != nil {
+		return 0, err
+	}
+	held, err := readHeldPoints(ctx, transaction, userID, wallet)
+	if err != nil {
+		return 0, err
+	}
+	overdue, err := readOverduePoints(ctx, transaction, userID, wallet)
+	if err != nil {
+		return 0, err
+	}
+	if balance, err = balance.Sub(held); err != nil {
+		return 0, err
+	}
+	return balance.Sub(overdue)
+}
+
+func withdrawalNumberExists(ctx context.Context, transaction *sql.Tx, number string) (bool, error) {
+	selectWithdrawalPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT EXISTS(SELECT 1 FROM withdrawal WHERE withdrawal_order_number = $1)`)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = selectWithdrawalPreparedStmt.QueryRowContext(ctx, number).Scan(&exists)
+	return exists, err
+}
+
+func heldOrderNumberExists(ctx context.Context, transaction *sql.Tx, number string) (bool, error) {
+	selectHoldPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT EXISTS(
+					SELECT 1 FROM "withdrawal_hold" WHERE order_number = $1 AND status = $2 AND expires_at > NOW())`)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = selectHoldPreparedStmt.QueryRowContext(ctx, number, HoldStatusHeld).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"time"
+)
+
+// IdempotencyRecord — сохранённый результат запроса с ключом идемпотентности.
+// Completed ложно, пока первый запрос ещё выполняется.
+type IdempotencyRecord struct {
+	RequestHash  string
+	Completed    bool
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+}
+
+type IdempotencyRepositoryInterface interface {
+	Reserve(ctx context.Context, userID uint64, key string, requestHash string) (IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, userID uint64, key string, record IdempotencyRecord) error
+	Release(ctx context.Context, userID uint64, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type IdempotencyRepository struct {
+	pool  *sql.DB
+	ttl   time.Duration
+	lease time.Duration
+}
+
+// NewIdempotencyRepository создаёт хранилище ключей: ttl — срок хранения ответа,
+// lease — сколько ключ может оставаться незавершённым, прежде чем его займёт повторный запрос.
+func NewIdempotencyRepository(pool *sql.DB, ttl time.Duration, lease time.Duration) *IdempotencyRepository {
+	return &IdempotencyRepository{pool: pool, ttl: ttl, lease: lease}
+}
+
+// Reserve занимает ключ за текущим запросом. Если ключ уже занят и не истёк,
+// возвращает сохранённую запись и false. Истёкшая запись и незавершённая запись с истёкшей арендой
+// (запрос упал вместе с процессом) перезаписываются.
+func (i IdempotencyRepository) Reserve(
+	ctx context.Context, userID uint64, key string, requestHash string) (IdempotencyRecord, bool, error) {
+	reserveKeyPreparedStmt, err := i.pool.PrepareContext(
+		ctx,
+		`INSERT INTO "idempotency_key" (user_id, key, request_hash)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, key) DO UPDATE
+					SET request_hash = EXCLUDED.request_hash,
+						status_code = NULL,
+						content_type = NULL,
+						response_body = NULL,
+						created_at = NOW(),
+						completed_at = NULL
+					WHERE "idempotency_key".created_at <= NOW() - make_interval(secs => $4)
+						OR ("idempotency_key".status_code IS NULL
+							AND "idempotency_key".created_at <= NOW() - make_interval(secs => $5))
+				RETURNING id`)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	var ID uint64
+	err = reserveKeyPreparedStmt.QueryRowContext(
+		ctx, userID, key, requestHash, i.ttl.Seconds(), i.lease.Seconds()).Scan(&ID)
+	if err == nil {
+		return IdempotencyRecord{RequestHash: requestHash}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Log.Warnf("Error reserving idempotency key for user %d, err %v", userID, err)
+		return IdempotencyRecord{}, false, err
+	}
+
+	selectKeyPreparedStmt, err := i.pool.PrepareContext(
+		ctx,
+		`SELECT request_hash, status_code, COALESCE(content_type, ''), response_body
+				FROM "idempotency_key"
+				WHERE user_id = $1 AND key = $2`)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	var record IdempotencyRecord
+	var statusCode sql.NullInt32
+	err = selectKeyPreparedStmt.QueryRowContext(ctx, userID, key).Scan(
+		&record.RequestHash, &statusCode, &record.ContentType, &record.ResponseBody)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	record.Completed = statusCode.Valid
+	record.StatusCode = int(statusCode.Int32)
+	return record, false, nil
+}
+
+func (i IdempotencyRepository) Complete(
+	ctx context.Context, userID uint64, key string, record IdempotencyRecord) error {
+	completeKeyPreparedStmt, err := i.pool.PrepareContext(
+		ctx,
+		`UPDATE "idempotency_key"
+				SET status_code = $1, content_type = $2, response_body = $3, completed_at = NOW()
+				WHERE user_id = $4 AND key = $5`)
+	if err != nil {
+		return err
+	}
+	_, err = completeKeyPreparedStmt.ExecContext(
+		ctx, record.StatusCode, record.ContentType, record.ResponseBody, userID, key)
+	return err
+}
+
+// Release освобождает ключ, чтобы клиент мог повторить запрос, завершившийся ошибкой сервера.
+func (i IdempotencyRepository) Release(ctx context.Context, userID uint64, key string) error {
+	releaseKeyPreparedStmt, err := i.pool.PrepareContext(
+		ctx, `DELETE FROM "idempotency_key" WHERE user_id = $1 AND key = $2 AND status_code IS NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = releaseKeyPreparedStmt.ExecContext(ctx, userID, key)
+	return err
+}
+
+func (i IdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	deleteExpiredPreparedStmt, err := i.pool.PrepareContext(
+		ctx, `DELETE FROM "idempotency_key" WHERE created_at <= NOW() - make_interval(secs => $1)`)
+	if err != nil {
+		return 0, err
+	}
+	result, err := deleteExpiredPreparedStmt.ExecContext(ctx, i.ttl.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,209 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+)
+
+const (
+	LedgerKindAccrual     = "accrual"
+	LedgerKindWithdrawal  = "withdrawal"
+	LedgerKindAdjustment  = "adjustment"
+	LedgerKindReversal    = "reversal"
+	LedgerKindExpiration  = "expiration"
+	LedgerKindTransferOut = "transfer_out"
+	LedgerKindTransferIn  = "transfer_in"
+	LedgerKindCampaign    = "campaign_bonus"
+	LedgerKindReferral    = "referral_bonus"
+	LedgerKindPromo       = "promo"
+)
+
+const (
+	ledgerAccountUser       = "user:points"
+	ledgerAccountAccrual    = "system:accrual"
+	ledgerAccountRedemption = "system:redemption"
+	ledgerAccountAdjustment = "system:adjustment"
+	ledgerAccountExpiration = "system:expiration"
+	ledgerAccountTransfer   = "system:transfer"
+	ledgerAccountCampaign   = "system:campaign"
+	ledgerAccountReferral   = "system:referral"
+	ledgerAccountPromo      = "system:promo"
+)
+
+// DefaultWallet — основной кошелёк бонусных баллов. Бонусы кампаний, рефералов, промокодов,
+// переводы и ручные корректировки проводятся только по нему.
+const DefaultWallet = "bonus"
+
+var ErrUnknownLedgerKind = errors.New("unknown ledger transaction kind")
+
+// LedgerPosting описывает одну операцию по счёту пользователя в кошельке Wallet (пустой — основной кошелёк).
+// Amount указывается со знаком с точки зрения пользователя: начисление положительное, списание отрицательное.
+type LedgerPosting struct {
+	UserID    uint64
+	Wallet    string
+	Kind      string
+	Amount    money.Amount
+	Reference string
+	SourceID  uint64
+}
+
+func (p LedgerPosting) wallet() string {
+	return walletOrDefault(p.Wallet)
+}
+
+func walletOrDefault(wallet string) string {
+	if wallet == "" {
+		return DefaultWallet
+	}
+	return wallet
+}
+
+func ledgerCounterAccount(kind string) (string, error) {
+	switch kind {
+	case LedgerKindAccrual:
+		return ledgerAccountAccrual, nil
+	case LedgerKindWithdrawal, LedgerKindReversal:
+		return ledgerAccountRedemption, nil
+	case LedgerKindAdjustment:
+		return ledgerAccountAdjustment, nil
+	case LedgerKindExpiration:
+		return ledgerAccountExpiration, nil
+	case LedgerKindTransferOut, LedgerKindTransferIn:
+		return ledgerAccountTransfer, nil
+	case LedgerKindCampaign:
+		return ledgerAccountCampaign, nil
+	case LedgerKindReferral:
+		return ledgerAccountReferral, nil
+	case LedgerKindPromo:
+		return ledgerAccountPromo, nil
+	default:
+		return "", ErrUnknownLedgerKind
+	}
+}
+
+func ledgerWithdrawnDelta(posting LedgerPosting) (money.Amount, error) {
+	switch posting.Kind {
+	case LedgerKindWithdrawal, LedgerKindReversal:
+		return posting.Amount.Neg()
+	default:
+		return 0, nil
+	}
+}
+
+// postLedgerTransaction записывает операцию двумя проводками (счёт пользователя и системный счёт)
+// и обновляет материализованные остатки в "user-balance" в рамках переданной транзакции.
+func postLedgerTransaction(ctx context.Context, transaction *sql.Tx, posting LedgerPosting) (uint64, error) {
+	counterAccount, err := ledgerCounterAccount(posting.Kind)
+	if err != nil {
+		return 0, err
+	}
+	var reference sql.NullString
+	if posting.Reference != "" {
+		reference = sql.NullString{String: posting.Reference, Valid: true}
+	}
+	var sourceID sql.NullInt64
+	if posting.SourceID != 0 {
+		sourceID = sql.NullInt64{Int64: int64(posting.SourceID), Valid: true}
+	}
+
+	createTransactionPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "ledger_transaction" (user_id, kind, reference, source_id, wallet)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING id`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing insert ledger transaction statement, err %v", err)
+		return 0, err
+	}
+	var ID uint64
+	err = createTransactionPreparedStmt.QueryRowContext(
+		ctx, posting.UserID, posting.Kind, reference, sourceID, posting.wallet()).Scan(&ID)
+	if err != nil {
+		logger.Log.Warnf("Error inserting ledger transaction for user %d, err %v", posting.UserID, err)
+		return 0, err
+	}
+
+	createEntriesPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "ledger_entry" (transaction_id, account, user_id, amount)
+				VALUES ($1, $2, $3, $4), ($1, $5, NULL, -$4::NUMERIC)`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing insert ledger entries statement, err %v", err)
+		return 0, err
+	}
+	_, err = createEntriesPreparedStmt.ExecContext(
+		ctx, ID, ledgerAccountUser, posting.UserID, posting.Amount, counterAccount)
+	if err != nil {
+		logger.Log.Warnf("Error inserting ledger entries for transaction %d, err %v", ID, err)
+		return 0, err
+	}
+
+	// Строка кошелька, отличного от основного, заводится при первой операции по нему
+	updateBalancePreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "user-balance" (user_id, wallet, balance, withdrawals_sum)
+				VALUES ($3, $4, $1, $2)
+				ON CONFLICT (user_id, wallet) DO UPDATE
+				SET balance = "user-balance".balance + EXCLUDED.balance,
+					withdrawals_sum = "user-balance".withdrawals_sum + EXCLUDED.withdrawals_sum`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing update user balance statement, err %v", err)
+		return 0, err
+	}
+	withdrawnDelta, err := ledgerWithdrawnDelta(posting)
+	if err != nil {
+		return 0, err
+	}
+	_, err = updateBalancePreparedStmt.ExecContext(
+		ctx, posting.Amount, withdrawnDelta, posting.UserID, posting.wallet())
+	if err != nil {
+		logger.Log.Warnf("Error updating materialized balance of user %d, err %v", posting.UserID, err)
+		return 0, err
+	}
+	return ID, nil
+}
+
+// lockUserBalance блокирует строку основного кошелька пользователя до конца транзакции,
+// чтобы операции пользователя по всем его кошелькам выполнялись последовательно.
+func lockUserBalance(ctx context.Context, transaction *sql.Tx, userID uint64) error {
+	lockBalancePreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT user_id FROM "user-balance" WHERE user_id = $1 AND wallet = $2 FOR UPDATE`)
+	if err != nil {
+		return err
+	}
+	var lockedUserID uint64
+	err = lockBalancePreparedStmt.QueryRowContext(ctx, userID, DefaultWallet).Scan(&lockedUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// readLedgerBalances считает текущий баланс и сумму списаний кошелька пользователя по журналу проводок.
+func readLedgerBalances(
+	ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) (money.Amount, money.Amount, error) {
+	selectBalancesPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(e.amount), 0),
+					COALESCE(-SUM(e.amount) FILTER (WHERE t.kind IN ($3, $4)), 0)
+				FROM "ledger_entry" e JOIN "ledger_transaction" t ON t.id = e.transaction_id
+				WHERE e.user_id = $1 AND e.account = $2 AND t.wallet = $5`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var balance money.Amount
+	var withdrawn money.Amount
+	err = selectBalancesPreparedStmt.QueryRowContext(
+		ctx, userID, ledgerAccountUser, LedgerKindWithdrawal, LedgerKindReversal, walletOrDefault(wallet),
+	).Scan(&balance, &withdrawn)
+	if err != nil {
+		return 0, 0, err
+	}
+	return balance, withdrawn, nil
+}
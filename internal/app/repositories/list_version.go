@@ -0,0 +1,11 @@
+package repositories
+
+import (
+	"time"
+)
+
+type ListVersion struct {
+	Count        uint64
+	MaxID        uint64
+	LastModified time.Time
+}
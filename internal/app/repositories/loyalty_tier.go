@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+)
+
+// UserAccruedTotal — сумма начислений пользователя за скользящее окно без учёта множителей уровня.
+type UserAccruedTotal struct {
+	UserID uint64
+	Tier   string
+	Total  money.Amount
+}
+
+type LoyaltyTierRepositoryInterface interface {
+	ReadAccruedTotals(ctx context.Context, windowMonths int) ([]UserAccruedTotal, error)
+	ReadAccruedTotal(ctx context.Context, userID uint64, windowMonths int) (UserAccruedTotal, error)
+	UpdateTier(ctx context.Context, userID uint64, tier string) error
+}
+
+type LoyaltyTierRepository struct {
+	pool *sql.DB
+}
+
+func NewLoyaltyTierRepository(pool *sql.DB) *LoyaltyTierRepository {
+	return &LoyaltyTierRepository{pool: pool}
+}
+
+// Сгоревшие, отменённые и прочие операции на уровень не влияют: считается только то, что пришло за заказы
+// в основной кошелёк.
+var selectAccruedTotalsQuery = `
+	SELECT u.id, u.tier, COALESCE(SUM(a.base_amount), 0)
+	FROM "user" u
+		LEFT JOIN "accrual" a ON a.user_id = u.id
+			AND a.created_at >= NOW() - make_interval(months => $1::INT)
+			AND a.wallet = $3
+	WHERE u.active AND ($2::BIGINT IS NULL OR u.id = $2::BIGINT)
+	GROUP BY u.id, u.tier
+	ORDER BY u.id`
+
+func (l LoyaltyTierRepository) ReadAccruedTotals(ctx context.Context, windowMonths int) ([]UserAccruedTotal, error) {
+	return readAccruedTotals(ctx, l.pool, windowMonths, sql.NullInt64{})
+}
+
+func (l LoyaltyTierRepository) ReadAccruedTotal(
+	ctx context.Context, userID uint64, windowMonths int) (UserAccruedTotal, error) {
+	totals, err := readAccruedTotals(ctx, l.pool, windowMonths, sql.NullInt64{Int64: int64(userID), Valid: true})
+	if err != nil {
+		return UserAccruedTotal{}, err
+	}
+	if len(totals) == 0 {
+		return UserAccruedTotal{}, ErrUserNotFound
+	}
+	return totals[0], nil
+}
+
+func (l LoyaltyTierRepository) UpdateTier(ctx context.Context, userID uint64, tier string) error {
+	updateTierPreparedStmt, err := l.pool.PrepareContext(
+		ctx, `UPDATE "user" SET tier = $1, tier_updated_at = NOW() WHERE id = $2`)
+	if err != nil {
+		return err
+	}
+	_, err = updateTierPreparedStmt.ExecContext(ctx, tier, userID)
+	if err != nil {
+		logger.Log.Warnf("Error updating tier of user %d, err %v", userID, err)
+	}
+	return err
+}
+
+func readAccruedTotals(
+	ctx context.Context, db preparer, windowMonths int, userID sql.NullInt64) ([]UserAccruedTotal, error) {
+	selectTotalsPreparedStmt, err := db.PrepareContext(ctx, selectAccruedTotalsQuery)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectTotalsPreparedStmt.QueryContext(ctx, windowMonths, userID, DefaultWallet)
+	if err != nil {
+		logger.Log.Warnf("Error selecting accrued totals, err %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var totals []UserAccruedTotal
+	for rows.Next() {
+		var total UserAccruedTotal
+		if scanErr := rows.Scan(&total.UserID, &total.Tier, &total.Total); scanErr != nil {
+			return nil, scanErr
+		}
+		totals = append(totals, total)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return totals, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"slices"
@@ -15,13 +17,14 @@ type Order struct {
 	ID        uint64
 	UserID    uint64
 	Number    string
+	Wallet    string
 	Status    string
 	CreatedAt time.Time
 }
 
 type OrderWithAccrual struct {
 	Order
-	Accrual sql.NullFloat64
+	Accrual money.NullAmount
 }
 
 const (
@@ -32,15 +35,20 @@ const (
 )
 
 var AllOrderStatuses = []string{OrderStatusNew, OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid}
-var updateStatusQuery = `UPDATE "order" SET status = $1 WHERE id = $2`
+var updateStatusQuery = `UPDATE "order" SET status = $1, modified_at = NOW() WHERE id = $2`
 
 type OrderRepositoryInterface interface {
-	Create(ctx context.Context, number string, userID uint64) (Order, error)
+	Create(ctx context.Context, number string, wallet string, userID uint64, guard OrderUploadGuard) (Order, error)
 	Read(ctx context.Context, number string) (Order, error)
 	ReadAllByUserID(ctx context.Context, userID uint64) ([]OrderWithAccrual, error)
+	StreamAllByUserID(ctx context.Context, userID uint64, consume func(order OrderWithAccrual) error) error
+	GetListVersion(ctx context.Context, userID uint64) (ListVersion, error)
 	ReadByStatus(ctx context.Context, status string) ([]Order, error)
 	UpdateOrderStatus(ctx context.Context, orderID uint64, status string) error
-	UpdateOrderAndPasteAccrual(ctx context.Context, order Order, status string, amount float64) error
+	UpdateOrderAndPasteAccrual(
+		ctx context.Context, order Order, status string, baseAmount money.Amount, multiplier string) error
+	ClaimForProcessing(ctx context.Context, orderID uint64) (bool, error)
+	Cancel(ctx context.Context, number string, userID uint64) error
 }
 
 var ErrOrderAlreadyExists = errors.New("order with given number already exists")
@@ -48,59 +56,123 @@ var ErrInsertingOrder = errors.New("error registering router")
 var ErrOrderNotFound = errors.New("order not found")
 var ErrInvalidStatus = errors.New("invalid status passed for order update")
 var ErrWrongMethodUsed = errors.New("wrong method used to update order")
+var ErrOrderCannotBeCancelled = errors.New("order is already being processed and cannot be cancelled")
+
+// ProcessedAccrual описывает начисление по заказу, только что переведённому в PROCESSED.
+type ProcessedAccrual struct {
+	AccrualID   uint64
+	OrderID     uint64
+	UserID      uint64
+	OrderNumber string
+	Wallet      string
+	BaseAmount  money.Amount
+	Amount      money.Amount
+	ExpiresAt   sql.NullTime
+}
+
+// AccrualHook вызывается в транзакции начисления после проводки по журналу.
+// Ошибка хука откатывает начисление целиком, заказ будет обработан повторно.
+type AccrualHook func(ctx context.Context, transaction *sql.Tx, accrual ProcessedAccrual) error
+
+// OrderUploadGuard вызывается в транзакции загрузки заказа перед вставкой, ошибка отменяет загрузку.
+type OrderUploadGuard func(ctx context.Context, transaction *sql.Tx, userID uint64) error
 
 type OrderRepository struct {
-	pool *sql.DB
+	pool         *sql.DB
+	config       *config.Config
+	accrualHooks []AccrualHook
 }
 
-func NewOrderRepository(pool *sql.DB) *OrderRepository {
-	return &OrderRepository{pool: pool}
+func NewOrderRepository(pool *sql.DB, config *config.Config, accrualHooks ...AccrualHook) *OrderRepository {
+	return &OrderRepository{pool: pool, config: config, accrualHooks: accrualHooks}
 }
 
-func (o OrderRepository) Create(ctx context.Context, number string, userID uint64) (Order, error) {
-	insertOrderPreparedStmt, err := o.pool.PrepareContext(
+// Create регистрирует заказ, начисление по которому попадёт в кошелёк wallet. Проверка на дубликат,
+// guard и вставка выполняются в одной транзакции под блокировкой пользователя, поэтому конкурентные загрузки
+// одного пользователя проверяются правилами по очереди. Guard не вызывается для уже загруженного номера.
+func (o OrderRepository) Create(
+	ctx context.Context, number string, wallet string, userID uint64, guard OrderUploadGuard) (Order, error) {
+	wallet = walletOrDefault(wallet)
+	transaction, txErr := o.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Order{}, txErr
+	}
+	if err := lockUser(ctx, transaction, userID); err != nil {
+		return Order{}, rollbackWithError(transaction, err)
+	}
+	selectExistingPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT EXISTS(SELECT 1 FROM "order" WHERE number = $1)`)
+	if err != nil {
+		return Order{}, rollbackWithError(transaction, err)
+	}
+	var exists bool
+	if err = selectExistingPreparedStmt.QueryRowContext(ctx, number).Scan(&exists); err != nil {
+		return Order{}, rollbackWithError(transaction, err)
+	}
+	if exists {
+		logger.Log.Infof("Order %s already registered", number)
+		return Order{}, rollbackWithError(transaction, ErrOrderAlreadyExists)
+	}
+	if guard != nil {
+		if err = guard(ctx, transaction, userID); err != nil {
+			return Order{}, rollbackWithError(transaction, err)
+		}
+	}
+
+	insertOrderPreparedStmt, err := transaction.PrepareContext(
 		ctx,
-		`INSERT INTO "order" (number, user_id)
-				VALUES ($1, $2) 
-				RETURNING id, user_id, number, status, created_at`)
+		`INSERT INTO "order" (number, user_id, wallet)
+				VALUES ($1, $2, $3) 
+				RETURNING id, status, created_at`)
 	if err != nil {
 		logger.Log.Warnf("Error preparing statement for creating order, error %e", err)
-		return Order{}, err
+		return Order{}, rollbackWithError(transaction, err)
 	}
-	row := insertOrderPreparedStmt.QueryRowContext(ctx, number, userID)
-	if row.Err() != nil {
+	order := Order{UserID: userID, Number: number, Wallet: wallet}
+	err = insertOrderPreparedStmt.QueryRowContext(ctx, number, userID, wallet).Scan(
+		&order.ID, &order.Status, &order.CreatedAt)
+	if err != nil {
 		var pgErr *pgconn.PgError
-		if errors.As(row.Err(), &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
 			logger.Log.Infof("Order %s already registered", number)
-			return Order{}, ErrOrderAlreadyExists
+			err = ErrOrderAlreadyExists
 		}
-		return Order{}, row.Err()
+		return Order{}, rollbackWithError(transaction, err)
 	}
-	var ID uint64
-	var selectedUserID uint64
-	var selectedNumber string
-	var status string
-	var createdAt time.Time
-	err = row.Scan(&ID, &selectedUserID, &selectedNumber, &status, &createdAt)
-	if err != nil {
-		return Order{}, err
+	if err = recordUploadAttempt(ctx, transaction, userID, number, UploadOutcomeAccepted); err != nil {
+		return Order{}, rollbackWithError(transaction, err)
 	}
-	if row == nil {
-		return Order{}, ErrInsertingOrder
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return Order{}, txErr
 	}
+	return order, nil
+}
 
-	return Order{
-		ID:        ID,
-		UserID:    userID,
-		Number:    number,
-		Status:    status,
-		CreatedAt: createdAt,
-	}, nil
+// lockUser блокирует строку пользователя до конца транзакции. NO KEY UPDATE не мешает вставкам
+// в таблицы со ссылкой на пользователя из других соединений, например записи срабатываний правил.
+func lockUser(ctx context.Context, transaction *sql.Tx, userID uint64) error {
+	lockUserPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT id FROM "user" WHERE id = $1 FOR NO KEY UPDATE`)
+	if err != nil {
+		return err
+	}
+	var lockedUserID uint64
+	err = lockUserPreparedStmt.QueryRowContext(ctx, userID).Scan(&lockedUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	return nil
 }
 
 func (o OrderRepository) Read(ctx context.Context, number string) (Order, error) {
 	selectOrderPreparedStmt, err := o.pool.PrepareContext(
-		ctx, `SELECT id, user_id, number, status, created_at FROM "order" WHERE number = $1`)
+		ctx, `SELECT id, user_id, number, wallet, status, created_at FROM "order" WHERE number = $1`)
 	if err != nil {
 		logger.Log.Warnf("Error preparing query for order, error %e", err)
 		return Order{}, err
@@ -113,9 +185,10 @@ func (o OrderRepository) Read(ctx context.Context, number string) (Order, error)
 	var ID uint64
 	var selectedUserID uint64
 	var selectedNumber string
+	var wallet string
 	var status string
 	var createdAt time.Time
-	err = row.Scan(&ID, &selectedUserID, &selectedNumber, &status, &createdAt)
+	err = row.Scan(&ID, &selectedUserID, &selectedNumber, &wallet, &status, &createdAt)
 	if err != nil {
 		return Order{}, err
 	}
@@ -126,30 +199,40 @@ func (o OrderRepository) Read(ctx context.Context, number string) (Order, error)
 		ID:        ID,
 		UserID:    selectedUserID,
 		Number:    number,
+		Wallet:    wallet,
 		Status:    status,
 		CreatedAt: createdAt,
 	}, nil
 }
 
 func (o OrderRepository) ReadAllByUserID(ctx context.Context, userID uint64) ([]OrderWithAccrual, error) {
+	var orders []OrderWithAccrual
+	err := o.StreamAllByUserID(ctx, userID, func(order OrderWithAccrual) error {
+		orders = append(orders, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (o OrderRepository) StreamAllByUserID(
+	ctx context.Context, userID uint64, consume func(order OrderWithAccrual) error) error {
 	selectAllOrdersByUserIDPreparedStmt, err := o.pool.PrepareContext(
 		ctx,
-		`SELECT o.id, o.user_id, o.number, o.status, o.created_at, a.amount 
+		`SELECT o.id, o.user_id, o.number, o.wallet, o.status, o.created_at, a.amount 
 				FROM "order" o LEFT JOIN "accrual" a on o.id = a.order_id 
 				WHERE o.user_id = $1
 				ORDER BY o.created_at DESC`)
 	if err != nil {
 		logger.Log.Warnf("Error preparing statement for quering orders by user %d, err %e", userID, err)
-		return nil, err
+		return err
 	}
 	rows, err := selectAllOrdersByUserIDPreparedStmt.QueryContext(ctx, userID)
 	if err != nil {
 		logger.Log.Infof("Error querying orders by user %d, err %e", userID, err)
-		return nil, err
-	}
-	if rows.Err() != nil {
-		logger.Log.Infof("Error querying orders by user %d, err %e", userID, err)
-		return nil, err
+		return err
 	}
 	defer func(rows *sql.Rows) {
 		innerErr := rows.Close()
@@ -157,23 +240,49 @@ func (o OrderRepository) ReadAllByUserID(ctx context.Context, userID uint64) ([]
 			logger.Log.Errorf("error closing rows: %v", innerErr)
 		}
 	}(rows)
-	var orders []OrderWithAccrual
 	for rows.Next() {
 		order := new(OrderWithAccrual)
-		scanErr := rows.Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.CreatedAt, &order.Accrual)
+		scanErr := rows.Scan(
+			&order.ID, &order.UserID, &order.Number, &order.Wallet, &order.Status, &order.CreatedAt, &order.Accrual)
 		if scanErr != nil {
 			logger.Log.Error(scanErr.Error())
-			return nil, scanErr
+			return scanErr
+		}
+		if err = consume(*order); err != nil {
+			return err
 		}
-		orders = append(orders, *order)
 	}
-	return orders, nil
+	if rows.Err() != nil {
+		logger.Log.Infof("Error querying orders by user %d, err %v", userID, rows.Err())
+		return rows.Err()
+	}
+	return nil
+}
+
+func (o OrderRepository) GetListVersion(ctx context.Context, userID uint64) (ListVersion, error) {
+	selectListVersionPreparedStmt, err := o.pool.PrepareContext(
+		ctx,
+		`SELECT COUNT(*), COALESCE(MAX(id), 0), COALESCE(MAX(COALESCE(modified_at, created_at)), 'epoch')
+				FROM "order"
+				WHERE user_id = $1`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for orders version of user %d, err %v", userID, err)
+		return ListVersion{}, err
+	}
+	var version ListVersion
+	err = selectListVersionPreparedStmt.QueryRowContext(ctx, userID).Scan(
+		&version.Count, &version.MaxID, &version.LastModified)
+	if err != nil {
+		logger.Log.Infof("Error querying orders version of user %d, err %v", userID, err)
+		return ListVersion{}, err
+	}
+	return version, nil
 }
 
 func (o OrderRepository) ReadByStatus(ctx context.Context, status string) ([]Order, error) {
 	selectOrdersByStatusPreparedStmt, err := o.pool.PrepareContext(
 		ctx,
-		`SELECT o.id, o.user_id, o.number, o.status, o.created_at
+		`SELECT o.id, o.user_id, o.number, o.wallet, o.status, o.created_at
 				FROM "order" o
 				WHERE o.status = $1
 				ORDER BY o.created_at`)
@@ -206,6 +315,7 @@ func (o OrderRepository) ReadByStatus(ctx context.Context, status string) ([]Ord
 			&order.ID,
 			&order.UserID,
 			&order.Number,
+			&order.Wallet,
 			&order.Status,
 			&order.CreatedAt,
 		)
@@ -239,11 +349,16 @@ func (o OrderRepository) UpdateOrderStatus(ctx context.Context, orderID uint64,
 	return nil
 }
 
+// UpdateOrderAndPasteAccrual проводит начисление baseAmount, умноженное на множитель уровня пользователя.
 func (o OrderRepository) UpdateOrderAndPasteAccrual(
-	ctx context.Context, order Order, status string, amount float64) error {
+	ctx context.Context, order Order, status string, baseAmount money.Amount, multiplier string) error {
 	if status != OrderStatusProcessed {
 		return ErrWrongMethodUsed
 	}
+	amount, err := baseAmount.MulFactor(multiplier)
+	if err != nil {
+		return err
+	}
 
 	transaction, txErr := o.pool.BeginTx(ctx, nil)
 	if txErr != nil {
@@ -274,7 +389,9 @@ func (o OrderRepository) UpdateOrderAndPasteAccrual(
 
 	createAccrualPreparedStmt, err := transaction.PrepareContext(
 		ctx,
-		`INSERT INTO "accrual" (amount, user_id, order_id) VALUES ($1, $2, $3)`)
+		`INSERT INTO "accrual" (amount, user_id, order_id, expires_at, base_amount, multiplier, wallet)
+				VALUES ($1, $2, $3, CASE WHEN $4::INT > 0 THEN NOW() + make_interval(months => $4::INT) END, $5, $6, $7)
+				RETURNING id, expires_at`)
 	if err != nil {
 		txErr = transaction.Rollback()
 		if txErr != nil {
@@ -284,43 +401,162 @@ func (o OrderRepository) UpdateOrderAndPasteAccrual(
 		logger.Log.Warnf("Error preparing insert acctrual statement, err %e", err)
 		return err
 	}
-	_, err = createAccrualPreparedStmt.ExecContext(ctx, amount, order.UserID, order.ID)
+	var accrualID uint64
+	var expiresAt sql.NullTime
+	err = createAccrualPreparedStmt.QueryRowContext(
+		ctx,
+		amount,
+		order.UserID,
+		order.ID,
+		o.config.PointsTTLMonths,
+		baseAmount,
+		multiplier,
+		walletOrDefault(order.Wallet),
+	).Scan(&accrualID, &expiresAt)
 	if err != nil {
 		txErr = transaction.Rollback()
 		if txErr != nil {
 			logger.Log.Warnf("Error during transaction rollback, err %e", txErr)
 			return txErr
 		}
-		logger.Log.Warnf("Error executing update status statement, err %e", err)
+		logger.Log.Warnf("Error executing insert accrual statement, err %e", err)
 		return err
 	}
 
-	updateBalancePreparedStmt, err := transaction.PrepareContext(
+	_, err = creditPoints(ctx, transaction, LedgerPosting{
+		UserID:    order.UserID,
+		Wallet:    order.Wallet,
+		Kind:      LedgerKindAccrual,
+		Amount:    amount,
+		Reference: order.Number,
+		SourceID:  accrualID,
+	}, sql.NullInt64{Int64: int64(accrualID), Valid: true}, expiresAt)
+	if err != nil {
+		logger.Log.Warnf("Error posting accrual for order %s to ledger, err %v", order.Number, err)
+		return rollbackWithError(transaction, err)
+	}
+	processed := ProcessedAccrual{
+		AccrualID:   accrualID,
+		OrderID:     order.ID,
+		UserID:      order.UserID,
+		OrderNumber: order.Number,
+		Wallet:      walletOrDefault(order.Wallet),
+		BaseAmount:  baseAmount,
+		Amount:      amount,
+		ExpiresAt:   expiresAt,
+	}
+	for _, hook := range o.accrualHooks {
+		if err = hook(ctx, transaction, processed); err != nil {
+			logger.Log.Warnf("Error running accrual hook for order %s, err %v", order.Number, err)
+			return rollbackWithError(transaction, err)
+		}
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("Error during transaction commit, err %e", txErr)
+		return txErr
+	}
+	return nil
+}
+
+// ClaimForProcessing переводит заказ в PROCESSING и запоминает, что он уже уходил в систему начислений.
+func (o OrderRepository) ClaimForProcessing(ctx context.Context, orderID uint64) (bool, error) {
+	claimOrderPreparedStmt, err := o.pool.PrepareContext(
 		ctx,
-		`UPDATE "user-balance" SET balance = balance + $1 WHERE user_id = $2`)
+		`UPDATE "order" SET status = $1, claimed_at = COALESCE(claimed_at, NOW()), modified_at = NOW()
+				WHERE id = $2 AND status = $3`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for claiming order %d, err %v", orderID, err)
+		return false, err
+	}
+	result, err := claimOrderPreparedStmt.ExecContext(ctx, OrderStatusProcessing, orderID, OrderStatusNew)
+	if err != nil {
+		logger.Log.Infof("Error claiming order %d, err %v", orderID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Cancel удаляет заказ, который воркер ещё ни разу не брал в обработку.
+// Заказ, вернувшийся в NEW после ответа системы начислений, отменить уже нельзя.
+func (o OrderRepository) Cancel(ctx context.Context, number string, userID uint64) error {
+	transaction, txErr := o.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		logger.Log.Warnf("Error creating transaction for cancelling order, err %v", txErr)
+		return txErr
+	}
+
+	deleteOrderPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`DELETE FROM "order"
+				WHERE number = $1 AND user_id = $2 AND status = $3 AND claimed_at IS NULL
+				RETURNING id, created_at`)
 	if err != nil {
 		txErr = transaction.Rollback()
 		if txErr != nil {
-			logger.Log.Warnf("Error during transaction rollback, err %e", txErr)
+			logger.Log.Warnf("Error during transaction rollback, err %v", txErr)
 			return txErr
 		}
-		logger.Log.Warnf("Error preparing update user balance statement, err %e", err)
+		logger.Log.Warnf("Error preparing delete order statement, err %v", err)
 		return err
 	}
-	_, err = updateBalancePreparedStmt.ExecContext(ctx, amount, order.UserID)
+	var ID uint64
+	var createdAt time.Time
+	err = deleteOrderPreparedStmt.QueryRowContext(ctx, number, userID, OrderStatusNew).Scan(&ID, &createdAt)
 	if err != nil {
 		txErr = transaction.Rollback()
 		if txErr != nil {
-			logger.Log.Warnf("Error during transaction rollback, err %e", txErr)
+			logger.Log.Warnf("Error during transaction rollback, err %v", txErr)
+			return txErr
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Log.Warnf("Error deleting order %s, err %v", number, err)
+			return err
+		}
+		existingOrder, readErr := o.Read(ctx, number)
+		if readErr != nil {
+			if errors.Is(readErr, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return readErr
+		}
+		if existingOrder.UserID != userID {
+			return ErrOrderNotFound
+		}
+		return ErrOrderCannotBeCancelled
+	}
+
+	createCancellationPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "order_cancellation" (order_id, number, user_id, uploaded_at) VALUES ($1, $2, $3, $4)`)
+	if err != nil {
+		txErr = transaction.Rollback()
+		if txErr != nil {
+			logger.Log.Warnf("Error during transaction rollback, err %v", txErr)
 			return txErr
 		}
-		logger.Log.Warnf("Error executing update user balance statement, err %e", err)
+		logger.Log.Warnf("Error preparing insert order cancellation statement, err %v", err)
+		return err
+	}
+	_, err = createCancellationPreparedStmt.ExecContext(ctx, ID, number, userID, createdAt)
+	if err != nil {
+		txErr = transaction.Rollback()
+		if txErr != nil {
+			logger.Log.Warnf("Error during transaction rollback, err %v", txErr)
+			return txErr
+		}
+		logger.Log.Warnf("Error executing insert order cancellation statement, err %v", err)
 		return err
 	}
 
 	txErr = transaction.Commit()
 	if txErr != nil {
-		logger.Log.Warnf("Error during transaction commit, err %e", txErr)
+		logger.Log.Warnf("Error during transaction commit, err %v", txErr)
 		return txErr
 	}
 	return nil
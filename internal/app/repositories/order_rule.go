@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+)
+
+const (
+	UploadOutcomeAccepted       = "accepted"
+	UploadOutcomeDuplicateOwn   = "duplicate_own"
+	UploadOutcomeDuplicateOther = "duplicate_other"
+	UploadOutcomeRejected       = "rejected"
+)
+
+type UploadStats struct {
+	AcceptedLastHour  uint64
+	AcceptedLastDay   uint64
+	DuplicatesLastDay uint64
+	FinishedOrders    uint64
+	InvalidOrders     uint64
+}
+
+type RuleHit struct {
+	UserID    uint64
+	Rule      string
+	Value     float64
+	Threshold float64
+	Action    string
+}
+
+type OrderRuleRepositoryInterface interface {
+	GetUploadStats(ctx context.Context, transaction *sql.Tx, userID uint64) (UploadStats, error)
+	RecordAttempt(ctx context.Context, userID uint64, number string, outcome string) error
+	RecordHit(ctx context.Context, hit RuleHit) error
+	FlagUser(ctx context.Context, transaction *sql.Tx, userID uint64) error
+}
+
+type OrderRuleRepository struct {
+	pool *sql.DB
+}
+
+func NewOrderRuleRepository(pool *sql.DB) *OrderRuleRepository {
+	return &OrderRuleRepository{pool: pool}
+}
+
+// GetUploadStats читает статистику загрузок в транзакции загрузки заказа, под блокировкой пользователя.
+func (o OrderRuleRepository) GetUploadStats(
+	ctx context.Context, transaction *sql.Tx, userID uint64) (UploadStats, error) {
+	selectStatsPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT
+					COUNT(*) FILTER (WHERE outcome = $2 AND created_at > NOW() - INTERVAL '1 hour'),
+					COUNT(*) FILTER (WHERE outcome = $2),
+					COUNT(*) FILTER (WHERE outcome IN ($3, $4)),
+					(SELECT COUNT(*) FROM "order" WHERE user_id = $1 AND status IN ($5, $6)),
+					(SELECT COUNT(*) FROM "order" WHERE user_id = $1 AND status = $6)
+				FROM "order_upload_attempt"
+				WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 day'`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for upload stats of user %d, err %v", userID, err)
+		return UploadStats{}, err
+	}
+	var stats UploadStats
+	err = selectStatsPreparedStmt.QueryRowContext(
+		ctx,
+		userID,
+		UploadOutcomeAccepted,
+		UploadOutcomeDuplicateOwn,
+		UploadOutcomeDuplicateOther,
+		OrderStatusProcessed,
+		OrderStatusInvalid,
+	).Scan(
+		&stats.AcceptedLastHour,
+		&stats.AcceptedLastDay,
+		&stats.DuplicatesLastDay,
+		&stats.FinishedOrders,
+		&stats.InvalidOrders,
+	)
+	if err != nil {
+		logger.Log.Infof("Error querying upload stats of user %d, err %v", userID, err)
+		return UploadStats{}, err
+	}
+	return stats, nil
+}
+
+func (o OrderRuleRepository) RecordAttempt(ctx context.Context, userID uint64, number string, outcome string) error {
+	return recordUploadAttempt(ctx, o.pool, userID, number, outcome)
+}
+
+// recordUploadAttempt записывает попытку загрузки. Принятые заказы записываются в транзакции их вставки,
+// чтобы конкурентная загрузка сразу увидела их в статистике.
+func recordUploadAttempt(ctx context.Context, db preparer, userID uint64, number string, outcome string) error {
+	insertAttemptPreparedStmt, err := db.PrepareContext(
+		ctx, `INSERT INTO "order_upload_attempt" (user_id, number, outcome) VALUES ($1, $2, $3)`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for recording upload attempt, err %v", err)
+		return err
+	}
+	_, err = insertAttemptPreparedStmt.ExecContext(ctx, userID, number, outcome)
+	if err != nil {
+		logger.Log.Infof("Error recording upload attempt of user %d, err %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+func (o OrderRuleRepository) RecordHit(ctx context.Context, hit RuleHit) error {
+	insertHitPreparedStmt, err := o.pool.PrepareContext(
+		ctx,
+		`INSERT INTO "order_rule_hit" (user_id, rule, value, threshold, action) VALUES ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for recording rule hit, err %v", err)
+		return err
+	}
+	_, err = insertHitPreparedStmt.ExecContext(ctx, hit.UserID, hit.Rule, hit.Value, hit.Threshold, hit.Action)
+	if err != nil {
+		logger.Log.Infof("Error recording rule hit of user %d, err %v", hit.UserID, err)
+		return err
+	}
+	return nil
+}
+
+func (o OrderRuleRepository) FlagUser(ctx context.Context, transaction *sql.Tx, userID uint64) error {
+	flagUserPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`UPDATE "user" SET flagged_for_review = True, flagged_at = COALESCE(flagged_at, NOW()) WHERE id = $1`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for flagging user %d, err %v", userID, err)
+		return err
+	}
+	_, err = flagUserPreparedStmt.ExecContext(ctx, userID)
+	if err != nil {
+		logger.Log.Infof("Error flagging user %d, err %v", userID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type ExpiringPoints struct {
+	Amount    money.Amount
+	ExpiresAt time.Time
+}
+
+type ExpiredLot struct {
+	ID        uint64
+	UserID    uint64
+	Remaining money.Amount
+	ExpiresAt time.Time
+}
+
+type PointsLotRepositoryInterface interface {
+	ReadExpiredLots(ctx context.Context, limit int) ([]ExpiredLot, error)
+	ExpireLot(ctx context.Context, lotID uint64) (money.Amount, error)
+}
+
+type PointsLotRepository struct {
+	pool *sql.DB
+}
+
+func NewPointsLotRepository(pool *sql.DB) *PointsLotRepository {
+	return &PointsLotRepository{pool: pool}
+}
+
+func (p PointsLotRepository) ReadExpiredLots(ctx context.Context, limit int) ([]ExpiredLot, error) {
+	selectExpiredLotsPreparedStmt, err := p.pool.PrepareContext(
+		ctx,
+		`SELECT id, user_id, remaining, expires_at
+				FROM "points_lot"
+				WHERE remaining > 0 AND expires_at <= NOW()
+				ORDER BY expires_at, id
+				LIMIT $1`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing statement for expired lots, err %v", err)
+		return nil, err
+	}
+	rows, err := selectExpiredLotsPreparedStmt.QueryContext(ctx, limit)
+	if err != nil {
+		logger.Log.Warnf("Error querying expired lots, err %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var lots []ExpiredLot
+	for rows.Next() {
+		lot := new(ExpiredLot)
+		scanErr := rows.Scan(&lot.ID, &lot.UserID, &lot.Remaining, &lot.ExpiresAt)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		lots = append(lots, *lot)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return lots, nil
+}
+
+// ExpireLot списывает сгоревший остаток партии отдельной проводкой журнала
+// и снимает резервы, которые после сгорания уже нечем покрыть.
+func (p PointsLotRepository) ExpireLot(ctx context.Context, lotID uint64) (money.Amount, error) {
+	transaction, txErr := p.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return 0, txErr
+	}
+	selectLotPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT user_id, wallet FROM "points_lot" WHERE id = $1`)
+	if err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+	var userID uint64
+	var wallet string
+	err = selectLotPreparedStmt.QueryRowContext(ctx, lotID).Scan(&userID, &wallet)
+	if err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+	err = lockUserBalance(ctx, transaction, userID)
+	if err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+	lockLotPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT remaining FROM "points_lot" WHERE id = $1 AND remaining > 0 AND expires_at <= NOW() FOR UPDATE`)
+	if err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+	var remaining money.Amount
+	err = lockLotPreparedStmt.QueryRowContext(ctx, lotID).Scan(&remaining)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Партию уже потратили или списали параллельно
+			return 0, transaction.Rollback()
+		}
+		return 0, rollbackWithError(transaction, err)
+	}
+
+	if err = postLotExpiration(ctx, transaction, lotID, userID, wallet, remaining); err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+	if _, err = voidUncoveredHolds(ctx, transaction, userID, wallet); err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return 0, txErr
+	}
+	return remaining, nil
+}
+
+// expireDueLots проводит сгорание всех просроченных партий кошелька, не дожидаясь фоновой задачи,
+// и возвращает идентификаторы снятых из-за этого резервов. Вызывается под блокировкой баланса пользователя.
+func expireDueLots(ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) ([]uint64, error) {
+	selectDueLotsPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT id, remaining
+				FROM "points_lot"
+				WHERE user_id = $1 AND wallet = $2 AND remaining > 0 AND expires_at <= NOW()
+				ORDER BY expires_at, id
+				FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectDueLotsPreparedStmt.QueryContext(ctx, userID, wallet)
+	if err != nil {
+		return nil, err
+	}
+	var lots []ExpiredLot
+	for rows.Next() {
+		lot := ExpiredLot{UserID: userID}
+		if scanErr := rows.Scan(&lot.ID, &lot.Remaining); scanErr != nil {
+			return nil, errors.Join(scanErr, rows.Close())
+		}
+		lots = append(lots, lot)
+	}
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return nil, nil
+	}
+	for _, lot := range lots {
+		if err = postLotExpiration(ctx, transaction, lot.ID, userID, wallet, lot.Remaining); err != nil {
+			return nil, err
+		}
+		logger.Log.Infof("Expired %s points of user %d from lot %d", lot.Remaining, userID, lot.ID)
+	}
+	return voidUncoveredHolds(ctx, transaction, userID, wallet)
+}
+
+func postLotExpiration(
+	ctx context.Context,
+	transaction *sql.Tx,
+	lotID uint64,
+	userID uint64,
+	wallet string,
+	remaining money.Amount) error {
+	expired, err := remaining.Neg()
+	if err != nil {
+		return err
+	}
+	ledgerTransactionID, err := postLedgerTransaction(ctx, transaction, LedgerPosting{
+		UserID:   userID,
+		Wallet:   wallet,
+		Kind:     LedgerKindExpiration,
+		Amount:   expired,
+		SourceID: lotID,
+	})
+	if err != nil {
+		logger.Log.Warnf("Error posting expiration of lot %d, err %v", lotID, err)
+		return err
+	}
+	return consumeLot(ctx, transaction, lotID, remaining, ledgerTransactionID)
+}
+
+// creditPoints проводит начисление по журналу и заводит под него партию баллов.
+func creditPoints(
+	ctx context.Context,
+	transaction *sql.Tx,
+	posting LedgerPosting,
+	accrualID sql.NullInt64,
+	expiresAt sql.NullTime) (uint64, error) {
+	ledgerTransactionID, err := postLedgerTransaction(ctx, transaction, posting)
+	if err != nil {
+		return 0, err
+	}
+	createLotPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "points_lot" (user_id, ledger_transaction_id, accrual_id, amount, remaining, expires_at, wallet)
+				VALUES ($1, $2, $3, $4, $4, $5, $6)`)
+	if err != nil {
+		logger.Log.Warnf("Error preparing insert points lot statement, err %v", err)
+		return 0, err
+	}
+	_, err = createLotPreparedStmt.ExecContext(
+		ctx, posting.UserID, ledgerTransactionID, accrualID, posting.Amount, expiresAt, posting.wallet())
+	if err != nil {
+		logger.Log.Warnf("Error inserting points lot for user %d, err %v", posting.UserID, err)
+		return 0, err
+	}
+	return ledgerTransactionID, nil
+}
+
+// debitPoints проводит списание по журналу и гасит живые партии начиная с самых старых.
+// Баллы без партии (например, ручные корректировки до введения сгорания) списываются последними.
+// Без overdraft списание, которое не покрывают живые партии и баллы без партии, отклоняется с ErrNotEnoughPoints;
+// overdraft нужен принудительным корректировкам, которые могут увести баланс в минус.
+func debitPoints(ctx context.Context, transaction *sql.Tx, posting LedgerPosting, overdraft bool) (uint64, error) {
+	ledgerTransactionID, err := postLedgerTransaction(ctx, transaction, posting)
+	if err != nil {
+		return 0, err
+	}
+	amount, err := posting.Amount.Neg()
+	if err != nil {
+		return 0, err
+	}
+	_, err = consumeLiveLots(
+		ctx, transaction, posting.UserID, posting.wallet(), amount, ledgerTransactionID, overdraft)
+	if err != nil {
+		return 0, err
+	}
+	return ledgerTransactionID, nil
+}
+
+type liveLot struct {
+	id        uint64
+	remaining money.Amount
+	expiresAt sql.NullTime
+}
+
+// consumedPortion — часть списания, погашенная одной партией (или баллами без партии, если expiresAt не задан).
+type consumedPortion struct {
+	amount    money.Amount
+	expiresAt sql.NullTime
+}
+
+// consumeLiveLots гасит живые партии кошелька на сумму списания, уже проведённого по журналу.
+// Сгоревшие партии не тратятся, даже если фоновая задача ещё не провела их сгорание.
+func consumeLiveLots(
+	ctx context.Context,
+	transaction *sql.Tx,
+	userID uint64,
+	wallet string,
+	amount money.Amount,
+	ledgerTransactionID uint64,
+	overdraft bool) ([]consumedPortion, error) {
+	selectLiveLotsPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT id, remaining, expires_at
+				FROM "points_lot"
+				WHERE user_id = $1 AND wallet = $2 AND remaining > 0 AND (expires_at IS NULL OR expires_at > NOW())
+				ORDER BY expires_at NULLS LAST, id
+				FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectLiveLotsPreparedStmt.QueryContext(ctx, userID, wallet)
+	if err != nil {
+		return nil, err
+	}
+	var lots []liveLot
+	left := amount
+	for rows.Next() && left.IsPositive() {
+		lot := liveLot{}
+		if scanErr := rows.Scan(&lot.id, &lot.remaining, &lot.expiresAt); scanErr != nil {
+			return nil, errors.Join(scanErr, rows.Close())
+		}
+		lots = append(lots, lot)
+		if left, err = left.Sub(money.Min(left, lot.remaining)); err != nil {
+			return nil, errors.Join(err, rows.Close())
+		}
+	}
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return nil, err
+	}
+
+	portions := make([]consumedPortion, 0, len(lots)+1)
+	left = amount
+	for _, lot := range lots {
+		consumed := money.Min(left, lot.remaining)
+		if err = consumeLot(ctx, transaction, lot.id, consumed, ledgerTransactionID); err != nil {
+			return nil, err
+		}
+		portions = append(portions, consumedPortion{amount: consumed, expiresAt: lot.expiresAt})
+		if left, err = left.Sub(consumed); err != nil {
+			return nil, err
+		}
+	}
+	if left.IsPositive() {
+		if !overdraft {
+			unlotted, unlottedErr := readUnlottedPoints(ctx, transaction, userID, wallet)
+			if unlottedErr != nil {
+				return nil, unlottedErr
+			}
+			if unlotted.IsNegative() {
+				logger.Log.Infof("Live points lots of user %d do not cover debit of %s", userID, amount)
+				return nil, ErrNotEnoughPoints
+			}
+		}
+		portions = append(portions, consumedPortion{amount: left})
+	}
+	return portions, nil
+}
+
+// readUnlottedPoints возвращает баллы кошелька, не принадлежащие ни одной партии: баланс по журналу
+// за вычетом остатков всех партий, в том числе сгоревших, но ещё не списанных.
+func readUnlottedPoints(ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) (money.Amount, error) {
+	balance, _, err := readLedgerBalances(ctx, transaction, userID, wallet)
+	if err != nil {
+		return 0, err
+	}
+	selectLotsRemainingPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(remaining), 0) FROM "points_lot" WHERE user_id = $1 AND wallet = $2 AND remaining > 0`)
+	if err != nil {
+		return 0, err
+	}
+	var lotsRemaining money.Amount
+	err = selectLotsRemainingPreparedStmt.QueryRowContext(ctx, userID, wallet).Scan(&lotsRemaining)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Sub(lotsRemaining)
+}
+
+// readOverduePoints возвращает остаток сгоревших партий кошелька, сгорание которых ещё не проведено.
+// Журнал до этого момента продолжает считать их частью баланса.
+func readOverduePoints(ctx context.Context, db preparer, userID uint64, wallet string) (money.Amount, error) {
+	selectOverduePreparedStmt, err := db.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(remaining), 0)
+				FROM "points_lot"
+				WHERE user_id = $1 AND wallet = $2 AND remaining > 0 AND expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	var overdue money.Amount
+	err = selectOverduePreparedStmt.QueryRowContext(ctx, userID, walletOrDefault(wallet)).Scan(&overdue)
+	if err != nil {
+		return 0, err
+	}
+	return overdue, nil
+}
+
+func consumeLot(
+	ctx context.Context, transaction *sql.Tx, lotID uint64, amount money.Amount, ledgerTransactionID uint64) error {
+	updateLotPreparedStmt, err := transaction.PrepareContext(
+		ctx, `UPDATE "points_lot" SET remaining = remaining - $1 WHERE id = $2`)
+	if err != nil {
+		return err
+	}
+	_, err = updateLotPreparedStmt.ExecContext(ctx, amount, lotID)
+	if err != nil {
+		logger.Log.Warnf("Error consuming points lot %d, err %v", lotID, err)
+		return err
+	}
+	createConsumptionPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "points_lot_consumption" (lot_id, ledger_transaction_id, amount) VALUES ($1, $2, $3)`)
+	if err != nil {
+		return err
+	}
+	_, err = createConsumptionPreparedStmt.ExecContext(ctx, lotID, ledgerTransactionID, amount)
+	return err
+}
+
+// readSoonestExpiring возвращает сумму баллов кошелька, которые сгорят раньше всех (в пределах одних суток).
+func readSoonestExpiring(ctx context.Context, db preparer, userID uint64, wallet string) (*ExpiringPoints, error) {
+	selectExpiringPreparedStmt, err := db.PrepareContext(
+		ctx,
+		`SELECT MIN(expires_at), SUM(remaining)
+				FROM "points_lot"
+				WHERE user_id = $1 AND wallet = $2 AND remaining > 0 AND expires_at > NOW()
+				GROUP BY date_trunc('day', expires_at)
+				ORDER BY date_trunc('day', expires_at)
+				LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	expiring := new(ExpiringPoints)
+	err = selectExpiringPreparedStmt.QueryRowContext(
+		ctx, userID, walletOrDefault(wallet)).Scan(&expiring.ExpiresAt, &expiring.Amount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return expiring, nil
+}
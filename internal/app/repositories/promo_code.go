@@ -0,0 +1,222 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+type PromoCode struct {
+	ID        uint64
+	Code      string
+	Amount    money.Amount
+	MaxUses   sql.NullInt64
+	Uses      int64
+	ExpiresAt sql.NullTime
+	Active    bool
+	CreatedBy uint64
+	CreatedAt time.Time
+}
+
+type PromoRedemption struct {
+	ID        uint64
+	Code      string
+	UserID    uint64
+	Amount    money.Amount
+	CreatedAt time.Time
+}
+
+type PromoCodeRepositoryInterface interface {
+	Create(ctx context.Context, promoCode PromoCode) (PromoCode, error)
+	ReadAll(ctx context.Context) ([]PromoCode, error)
+	Redeem(ctx context.Context, code string, userID uint64) (PromoRedemption, error)
+}
+
+var ErrPromoCodeAlreadyExists = errors.New("promo code already exists")
+var ErrPromoCodeNotFound = errors.New("promo code not found")
+var ErrPromoCodeExpired = errors.New("promo code expired or deactivated")
+var ErrPromoCodeExhausted = errors.New("promo code has no uses left")
+var ErrPromoCodeAlreadyRedeemed = errors.New("promo code already redeemed by the user")
+
+type PromoCodeRepository struct {
+	pool   *sql.DB
+	config *config.Config
+}
+
+func NewPromoCodeRepository(pool *sql.DB, config *config.Config) *PromoCodeRepository {
+	return &PromoCodeRepository{pool: pool, config: config}
+}
+
+func (p PromoCodeRepository) Create(ctx context.Context, promoCode PromoCode) (PromoCode, error) {
+	createPromoCodePreparedStmt, err := p.pool.PrepareContext(
+		ctx,
+		`INSERT INTO "promo_code" (code, amount, max_uses, expires_at, created_by)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING id, uses, active, created_at`)
+	if err != nil {
+		return PromoCode{}, err
+	}
+	err = createPromoCodePreparedStmt.QueryRowContext(
+		ctx,
+		promoCode.Code,
+		promoCode.Amount,
+		promoCode.MaxUses,
+		promoCode.ExpiresAt,
+		promoCode.CreatedBy,
+	).Scan(&promoCode.ID, &promoCode.Uses, &promoCode.Active, &promoCode.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return PromoCode{}, ErrPromoCodeAlreadyExists
+		}
+		logger.Log.Warnf("Error creating promo code, err %v", err)
+		return PromoCode{}, err
+	}
+	return promoCode, nil
+}
+
+func (p PromoCodeRepository) ReadAll(ctx context.Context) ([]PromoCode, error) {
+	selectPromoCodesPreparedStmt, err := p.pool.PrepareContext(
+		ctx,
+		`SELECT id, code, amount, max_uses, uses, expires_at, active, created_by, created_at
+				FROM "promo_code"
+				ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectPromoCodesPreparedStmt.QueryContext(ctx)
+	if err != nil {
+		logger.Log.Errorf("error during promo codes selection: %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var promoCodes []PromoCode
+	for rows.Next() {
+		promoCode := new(PromoCode)
+		scanErr := rows.Scan(
+			&promoCode.ID,
+			&promoCode.Code,
+			&promoCode.Amount,
+			&promoCode.MaxUses,
+			&promoCode.Uses,
+			&promoCode.ExpiresAt,
+			&promoCode.Active,
+			&promoCode.CreatedBy,
+			&promoCode.CreatedAt,
+		)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		promoCodes = append(promoCodes, *promoCode)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return promoCodes, nil
+}
+
+// Redeem активирует код и начисляет баллы в одной транзакции. Счётчик активаций увеличивается
+// условным UPDATE, поэтому последнюю активацию кода получает только один из конкурирующих запросов.
+func (p PromoCodeRepository) Redeem(ctx context.Context, code string, userID uint64) (PromoRedemption, error) {
+	transaction, txErr := p.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return PromoRedemption{}, txErr
+	}
+	if err := lockUserBalance(ctx, transaction, userID); err != nil {
+		return PromoRedemption{}, rollbackWithError(transaction, err)
+	}
+
+	usePromoCodePreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`UPDATE "promo_code" SET uses = uses + 1
+				WHERE code = $1
+					AND active
+					AND (expires_at IS NULL OR expires_at > NOW())
+					AND (max_uses IS NULL OR uses < max_uses)
+				RETURNING id, amount`)
+	if err != nil {
+		return PromoRedemption{}, rollbackWithError(transaction, err)
+	}
+	redemption := PromoRedemption{Code: code, UserID: userID}
+	var promoCodeID uint64
+	err = usePromoCodePreparedStmt.QueryRowContext(ctx, code).Scan(&promoCodeID, &redemption.Amount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = explainUnusablePromoCode(ctx, transaction, code)
+		}
+		return PromoRedemption{}, rollbackWithError(transaction, err)
+	}
+
+	createRedemptionPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "promo_redemption" (promo_code_id, user_id, amount)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (promo_code_id, user_id) DO NOTHING
+				RETURNING id, created_at,
+					CASE WHEN $4::INT > 0 THEN NOW() + make_interval(months => $4::INT) END`)
+	if err != nil {
+		return PromoRedemption{}, rollbackWithError(transaction, err)
+	}
+	var expiresAt sql.NullTime
+	err = createRedemptionPreparedStmt.QueryRowContext(
+		ctx, promoCodeID, userID, redemption.Amount, p.config.PointsTTLMonths,
+	).Scan(&redemption.ID, &redemption.CreatedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrPromoCodeAlreadyRedeemed
+		}
+		return PromoRedemption{}, rollbackWithError(transaction, err)
+	}
+	_, err = creditPoints(ctx, transaction, LedgerPosting{
+		UserID:    userID,
+		Kind:      LedgerKindPromo,
+		Amount:    redemption.Amount,
+		Reference: code,
+		SourceID:  redemption.ID,
+	}, sql.NullInt64{}, expiresAt)
+	if err != nil {
+		logger.Log.Warnf("Error posting promo code %s for user %d to ledger, err %v", code, userID, err)
+		return PromoRedemption{}, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return PromoRedemption{}, txErr
+	}
+	return redemption, nil
+}
+
+// explainUnusablePromoCode определяет, почему код не удалось активировать.
+func explainUnusablePromoCode(ctx context.Context, transaction *sql.Tx, code string) error {
+	selectPromoCodePreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT active AND (expires_at IS NULL OR expires_at > NOW()) FROM "promo_code" WHERE code = $1`)
+	if err != nil {
+		return err
+	}
+	var usable bool
+	err = selectPromoCodePreparedStmt.QueryRowContext(ctx, code).Scan(&usable)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPromoCodeNotFound
+		}
+		return err
+	}
+	if !usable {
+		return ErrPromoCodeExpired
+	}
+	return ErrPromoCodeExhausted
+}
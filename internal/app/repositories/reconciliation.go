@@ -0,0 +1,233 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+)
+
+// ReconciliationReference помечает корректирующие проводки сверки, они не учитываются в ожидаемом балансе.
+const ReconciliationReference = "reconciliation"
+
+type BalanceDiscrepancy struct {
+	UserID          uint64       `json:"user_id"`
+	Wallet          string       `json:"wallet"`
+	StoredBalance   money.Amount `json:"stored_balance"`
+	LedgerBalance   money.Amount `json:"ledger_balance"`
+	ExpectedBalance money.Amount `json:"expected_balance"`
+	StoredWithdrawn money.Amount `json:"stored_withdrawn"`
+	LedgerWithdrawn money.Amount `json:"ledger_withdrawn"`
+}
+
+type ReconciliationRepositoryInterface interface {
+	FindDiscrepancies(ctx context.Context) ([]BalanceDiscrepancy, error)
+	Repair(ctx context.Context, userID uint64, wallet string) (BalanceDiscrepancy, error)
+}
+
+// Ожидаемый баланс кошелька: начисления, бонусы кампаний и рефералов, промокоды, списания, возвраты и переводы
+// по исходным таблицам плюс операции, у которых нет отдельной таблицы-источника (корректировки, сгорание и т.п.).
+// Бонусы, промокоды и переводы проводятся только по основному кошельку ($12).
+var selectDiscrepanciesQuery = `
+	WITH accruals AS (
+		SELECT user_id, wallet, SUM(amount) AS total FROM "accrual" GROUP BY user_id, wallet
+	), campaigns AS (
+		SELECT user_id, $12::TEXT AS wallet, SUM(amount) AS total FROM "campaign_bonus" GROUP BY user_id
+	), referrals AS (
+		SELECT user_id, $12::TEXT AS wallet, SUM(amount) AS total FROM (
+			SELECT referrer_id AS user_id, referrer_bonus AS amount FROM "referral" WHERE rewarded_at IS NOT NULL
+			UNION ALL
+			SELECT referee_id, referee_bonus FROM "referral" WHERE rewarded_at IS NOT NULL
+		) rf GROUP BY user_id
+	), promos AS (
+		SELECT user_id, $12::TEXT AS wallet, SUM(amount) AS total FROM "promo_redemption" GROUP BY user_id
+	), withdrawals AS (
+		SELECT user_id, wallet, SUM(amount) AS total FROM "withdrawal" GROUP BY user_id, wallet
+	), reversals AS (
+		SELECT r.user_id, w.wallet, SUM(r.amount) AS total
+		FROM "withdrawal_reversal" r JOIN "withdrawal" w ON w.id = r.withdrawal_id
+		GROUP BY r.user_id, w.wallet
+	), transfers AS (
+		SELECT user_id, $12::TEXT AS wallet, SUM(amount) AS total FROM (
+			SELECT to_user_id AS user_id, amount FROM "points_transfer"
+			UNION ALL
+			SELECT from_user_id, -amount FROM "points_transfer"
+		) t GROUP BY user_id
+	), ledger AS (
+		SELECT e.user_id, t.wallet,
+			SUM(e.amount) AS total,
+			-SUM(e.amount) FILTER (WHERE t.kind IN ($1, $2)) AS withdrawn,
+			SUM(e.amount) FILTER (
+				WHERE t.kind NOT IN ($3, $1, $2, $7, $8, $9, $10, $11) AND t.reference IS DISTINCT FROM $4) AS other
+		FROM "ledger_entry" e JOIN "ledger_transaction" t ON t.id = e.transaction_id
+		WHERE e.account = $5
+		GROUP BY e.user_id, t.wallet
+	), report AS (
+		SELECT ub.user_id,
+			ub.wallet,
+			ub.balance AS stored_balance,
+			COALESCE(l.total, 0) AS ledger_balance,
+			COALESCE(a.total, 0) + COALESCE(c.total, 0) + COALESCE(rf.total, 0) + COALESCE(p.total, 0)
+				- COALESCE(w.total, 0) + COALESCE(r.total, 0)
+				+ COALESCE(tr.total, 0) + COALESCE(l.other, 0) AS expected_balance,
+			ub.withdrawals_sum AS stored_withdrawn,
+			COALESCE(l.withdrawn, 0) AS ledger_withdrawn
+		FROM "user-balance" ub
+			LEFT JOIN accruals a ON a.user_id = ub.user_id AND a.wallet = ub.wallet
+			LEFT JOIN campaigns c ON c.user_id = ub.user_id AND c.wallet = ub.wallet
+			LEFT JOIN referrals rf ON rf.user_id = ub.user_id AND rf.wallet = ub.wallet
+			LEFT JOIN promos p ON p.user_id = ub.user_id AND p.wallet = ub.wallet
+			LEFT JOIN withdrawals w ON w.user_id = ub.user_id AND w.wallet = ub.wallet
+			LEFT JOIN reversals r ON r.user_id = ub.user_id AND r.wallet = ub.wallet
+			LEFT JOIN transfers tr ON tr.user_id = ub.user_id AND tr.wallet = ub.wallet
+			LEFT JOIN ledger l ON l.user_id = ub.user_id AND l.wallet = ub.wallet
+		WHERE ($6::BIGINT IS NULL OR ub.user_id = $6::BIGINT)
+			AND ($13::TEXT IS NULL OR ub.wallet = $13::TEXT)
+	)
+	SELECT user_id, wallet, stored_balance, ledger_balance, expected_balance, stored_withdrawn, ledger_withdrawn
+	FROM report
+	WHERE stored_balance <> expected_balance
+		OR ledger_balance <> expected_balance
+		OR stored_withdrawn <> ledger_withdrawn
+	ORDER BY user_id, wallet`
+
+type ReconciliationRepository struct {
+	pool *sql.DB
+}
+
+func NewReconciliationRepository(pool *sql.DB) *ReconciliationRepository {
+	return &ReconciliationRepository{pool: pool}
+}
+
+func (r ReconciliationRepository) FindDiscrepancies(ctx context.Context) ([]BalanceDiscrepancy, error) {
+	return findDiscrepancies(ctx, r.pool, sql.NullInt64{}, sql.NullString{})
+}
+
+// Repair доводит журнал кошелька до ожидаемого баланса корректирующей проводкой
+// и пересчитывает материализованный баланс по журналу.
+func (r ReconciliationRepository) Repair(
+	ctx context.Context, userID uint64, wallet string) (BalanceDiscrepancy, error) {
+	wallet = walletOrDefault(wallet)
+	transaction, txErr := r.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return BalanceDiscrepancy{}, txErr
+	}
+	err := lockUserBalance(ctx, transaction, userID)
+	if err != nil {
+		return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+	}
+	discrepancies, err := findDiscrepancies(
+		ctx,
+		transaction,
+		sql.NullInt64{Int64: int64(userID), Valid: true},
+		sql.NullString{String: wallet, Valid: true})
+	if err != nil {
+		return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+	}
+	if len(discrepancies) == 0 {
+		return BalanceDiscrepancy{UserID: userID, Wallet: wallet}, transaction.Rollback()
+	}
+	discrepancy := discrepancies[0]
+
+	correction, err := discrepancy.ExpectedBalance.Sub(discrepancy.LedgerBalance)
+	if err != nil {
+		return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+	}
+	if !correction.IsZero() {
+		posting := LedgerPosting{
+			UserID:    userID,
+			Wallet:    wallet,
+			Kind:      LedgerKindAdjustment,
+			Amount:    correction,
+			Reference: ReconciliationReference,
+		}
+		if correction.IsPositive() {
+			_, err = creditPoints(ctx, transaction, posting, sql.NullInt64{}, sql.NullTime{})
+		} else {
+			_, err = debitPoints(ctx, transaction, posting, true)
+		}
+		if err != nil {
+			logger.Log.Warnf("Error posting reconciliation adjustment for user %d, err %v", userID, err)
+			return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+		}
+	}
+
+	balance, withdrawn, err := readLedgerBalances(ctx, transaction, userID, wallet)
+	if err != nil {
+		return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+	}
+	resyncBalancePreparedStmt, err := transaction.PrepareContext(
+		ctx, `UPDATE "user-balance" SET balance = $1, withdrawals_sum = $2 WHERE user_id = $3 AND wallet = $4`)
+	if err != nil {
+		return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+	}
+	_, err = resyncBalancePreparedStmt.ExecContext(ctx, balance, withdrawn, userID, wallet)
+	if err != nil {
+		logger.Log.Warnf("Error resyncing materialized balance of user %d, err %v", userID, err)
+		return BalanceDiscrepancy{}, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return BalanceDiscrepancy{}, txErr
+	}
+	return discrepancy, nil
+}
+
+func findDiscrepancies(
+	ctx context.Context, db preparer, userID sql.NullInt64, wallet sql.NullString) ([]BalanceDiscrepancy, error) {
+	selectDiscrepanciesPreparedStmt, err := db.PrepareContext(ctx, selectDiscrepanciesQuery)
+	if err != nil {
+		logger.Log.Warnf("Error preparing reconciliation query, err %v", err)
+		return nil, err
+	}
+	rows, err := selectDiscrepanciesPreparedStmt.QueryContext(
+		ctx,
+		LedgerKindWithdrawal,
+		LedgerKindReversal,
+		LedgerKindAccrual,
+		ReconciliationReference,
+		ledgerAccountUser,
+		userID,
+		LedgerKindTransferOut,
+		LedgerKindTransferIn,
+		LedgerKindCampaign,
+		LedgerKindReferral,
+		LedgerKindPromo,
+		DefaultWallet,
+		wallet,
+	)
+	if err != nil {
+		logger.Log.Warnf("Error executing reconciliation query, err %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var discrepancies []BalanceDiscrepancy
+	for rows.Next() {
+		discrepancy := new(BalanceDiscrepancy)
+		scanErr := rows.Scan(
+			&discrepancy.UserID,
+			&discrepancy.Wallet,
+			&discrepancy.StoredBalance,
+			&discrepancy.LedgerBalance,
+			&discrepancy.ExpectedBalance,
+			&discrepancy.StoredWithdrawn,
+			&discrepancy.LedgerWithdrawn,
+		)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		discrepancies = append(discrepancies, *discrepancy)
+	}
+	if rows.Err() != nil && !errors.Is(rows.Err(), sql.ErrNoRows) {
+		return nil, rows.Err()
+	}
+	return discrepancies, nil
+}
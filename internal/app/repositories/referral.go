@@ -0,0 +1,190 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"strings"
+)
+
+type ReferralStats struct {
+	Code       string
+	ReferredBy string
+	Invited    uint64
+	Rewarded   uint64
+	Earned     money.Amount
+}
+
+// ReferralBonuses задаёт бонусы пригласившему и приглашённому за первый обработанный заказ приглашённого.
+// ReferrerDailyCap ограничивает число бонусов пригласившему за сутки, чтобы приглашение собственных
+// дополнительных аккаунтов не давало неограниченных начислений. 0 — без ограничения.
+type ReferralBonuses struct {
+	Referrer         money.Amount
+	Referee          money.Amount
+	ReferrerDailyCap int64
+}
+
+type ReferralRepositoryInterface interface {
+	ReadStats(ctx context.Context, userID uint64) (ReferralStats, error)
+	Reward(ctx context.Context, transaction *sql.Tx, accrual ProcessedAccrual, bonuses ReferralBonuses) error
+}
+
+var ErrReferralCodeNotFound = errors.New("referral code not found")
+var ErrReferralCodeTaken = errors.New("referral code already taken")
+
+const referralCodeConstraint = "user_referral_code_udx"
+
+type ReferralRepository struct {
+	pool *sql.DB
+}
+
+func NewReferralRepository(pool *sql.DB) *ReferralRepository {
+	return &ReferralRepository{pool: pool}
+}
+
+func (r ReferralRepository) ReadStats(ctx context.Context, userID uint64) (ReferralStats, error) {
+	selectStatsPreparedStmt, err := r.pool.PrepareContext(
+		ctx,
+		`SELECT u.referral_code,
+					COALESCE((SELECT ru.login FROM "referral" rr JOIN "user" ru ON ru.id = rr.referrer_id
+						WHERE rr.referee_id = u.id), ''),
+					COUNT(r.id),
+					COUNT(r.rewarded_at),
+					COALESCE(SUM(r.referrer_bonus), 0)
+				FROM "user" u
+					LEFT JOIN "referral" r ON r.referrer_id = u.id
+				WHERE u.id = $1
+				GROUP BY u.id, u.referral_code`)
+	if err != nil {
+		return ReferralStats{}, err
+	}
+	var stats ReferralStats
+	err = selectStatsPreparedStmt.QueryRowContext(ctx, userID).Scan(
+		&stats.Code, &stats.ReferredBy, &stats.Invited, &stats.Rewarded, &stats.Earned)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ReferralStats{}, ErrUserNotFound
+		}
+		return ReferralStats{}, err
+	}
+	return stats, nil
+}
+
+// Reward начисляет реферальные бонусы, если обработанный заказ — первый у приглашённого пользователя
+// с начислением в основной кошелёк: партнёрские кошельки, как и в кампаниях, не учитываются.
+// Вызывается из AccrualHook, повторный вызов для того же приглашённого ничего не делает.
+func (r ReferralRepository) Reward(
+	ctx context.Context, transaction *sql.Tx, accrual ProcessedAccrual, bonuses ReferralBonuses) error {
+	if !bonuses.Referrer.IsPositive() && !bonuses.Referee.IsPositive() {
+		return nil
+	}
+	if walletOrDefault(accrual.Wallet) != DefaultWallet {
+		return nil
+	}
+	selectReferralPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT id, referrer_id FROM "referral" WHERE referee_id = $1 AND rewarded_at IS NULL FOR UPDATE`)
+	if err != nil {
+		return err
+	}
+	var referralID, referrerID uint64
+	err = selectReferralPreparedStmt.QueryRowContext(ctx, accrual.UserID).Scan(&referralID, &referrerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	referrerBonus := bonuses.Referrer
+	if referrerBonus.IsPositive() && bonuses.ReferrerDailyCap > 0 {
+		capReached, capErr := referrerDailyCapReached(ctx, transaction, referrerID, bonuses.ReferrerDailyCap)
+		if capErr != nil {
+			return capErr
+		}
+		if capReached {
+			logger.Log.Warnf("Referrer %d reached the daily referral bonus cap, referral %d gets no referrer bonus",
+				referrerID, referralID)
+			referrerBonus = 0
+		}
+	}
+
+	rewardPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`UPDATE "referral"
+				SET order_id = $1, referrer_bonus = $2, referee_bonus = $3, rewarded_at = NOW()
+				WHERE id = $4`)
+	if err != nil {
+		return err
+	}
+	_, err = rewardPreparedStmt.ExecContext(ctx, accrual.OrderID, referrerBonus, bonuses.Referee, referralID)
+	if err != nil {
+		logger.Log.Warnf("Error marking referral of user %d as rewarded, err %v", accrual.UserID, err)
+		return err
+	}
+	for _, posting := range []LedgerPosting{
+		{UserID: accrual.UserID, Kind: LedgerKindReferral, Amount: bonuses.Referee, SourceID: referralID},
+		{UserID: referrerID, Kind: LedgerKindReferral, Amount: referrerBonus, SourceID: referralID},
+	} {
+		if !posting.Amount.IsPositive() {
+			continue
+		}
+		if _, err = creditPoints(ctx, transaction, posting, sql.NullInt64{}, accrual.ExpiresAt); err != nil {
+			logger.Log.Warnf("Error posting referral bonus for user %d, err %v", posting.UserID, err)
+			return err
+		}
+	}
+	logger.Log.Infof("Referral %d rewarded after order %s", referralID, accrual.OrderNumber)
+	return nil
+}
+
+// referrerDailyCapReached проверяет, сколько бонусов пригласивший получил за последние сутки.
+// Строка пригласившего блокируется, чтобы параллельные начисления не превысили лимит.
+func referrerDailyCapReached(
+	ctx context.Context, transaction *sql.Tx, referrerID uint64, dailyCap int64) (bool, error) {
+	if err := lockUser(ctx, transaction, referrerID); err != nil {
+		return false, err
+	}
+	countRewardedPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT COUNT(*) FROM "referral"
+				WHERE referrer_id = $1 AND referrer_bonus > 0 AND rewarded_at > NOW() - INTERVAL '1 day'`)
+	if err != nil {
+		return false, err
+	}
+	var rewarded int64
+	if err = countRewardedPreparedStmt.QueryRowContext(ctx, referrerID).Scan(&rewarded); err != nil {
+		return false, err
+	}
+	return rewarded >= dailyCap, nil
+}
+
+// createReferral связывает нового пользователя с владельцем кода.
+func createReferral(ctx context.Context, transaction *sql.Tx, referrerCode string, refereeID uint64) error {
+	selectReferrerPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT id FROM "user" WHERE referral_code = $1 AND active`)
+	if err != nil {
+		return err
+	}
+	var referrerID uint64
+	err = selectReferrerPreparedStmt.QueryRowContext(
+		ctx, strings.ToUpper(strings.TrimSpace(referrerCode))).Scan(&referrerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReferralCodeNotFound
+		}
+		return err
+	}
+
+	createReferralPreparedStmt, err := transaction.PrepareContext(
+		ctx, `INSERT INTO "referral" (referrer_id, referee_id) VALUES ($1, $2)`)
+	if err != nil {
+		return err
+	}
+	_, err = createReferralPreparedStmt.ExecContext(ctx, referrerID, refereeID)
+	if err != nil {
+		logger.Log.Warnf("Error creating referral of user %d, err %v", refereeID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package repositories
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"time"
+)
+
+// Session — сессия входа пользователя. RefreshTokenHash хранит хеш текущего refresh-токена.
+type Session struct {
+	ID               uint64
+	UserID           uint64
+	RefreshTokenHash string
+	ExpiresAt        time.Time
+	CreatedAt        time.Time
+}
+
+type SessionRepositoryInterface interface {
+	Create(ctx context.Context, userID uint64, refreshTokenHash string) (Session, error)
+	Rotate(ctx context.Context, sessionID uint64, presentedHash string, newHash string) (Session, error)
+	IsActive(ctx context.Context, sessionID uint64) (bool, error)
+	Revoke(ctx context.Context, sessionID uint64, userID uint64) error
+	RevokeByTokenHash(ctx context.Context, sessionID uint64, tokenHash string) (uint64, error)
+	RevokeAllByUserID(ctx context.Context, userID uint64) ([]uint64, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+var ErrSessionNotActive = errors.New("session is revoked or expired")
+var ErrRefreshTokenReused = errors.New("refresh token was already used, session revoked")
+var ErrRefreshTokenMismatch = errors.New("refresh token does not belong to the session")
+
+type SessionRepository struct {
+	pool *sql.DB
+	ttl  time.Duration
+}
+
+func NewSessionRepository(pool *sql.DB, ttl time.Duration) *SessionRepository {
+	return &SessionRepository{pool: pool, ttl: ttl}
+}
+
+func (s SessionRepository) Create(ctx context.Context, userID uint64, refreshTokenHash string) (Session, error) {
+	createSessionPreparedStmt, err := s.pool.PrepareContext(
+		ctx,
+		`INSERT INTO "session" (user_id, refresh_token_hash, expires_at)
+				VALUES ($1, $2, NOW() + make_interval(secs => $3))
+				RETURNING id, expires_at, created_at`)
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{UserID: userID, RefreshTokenHash: refreshTokenHash}
+	err = createSessionPreparedStmt.QueryRowContext(ctx, userID, refreshTokenHash, s.ttl.Seconds()).Scan(
+		&session.ID, &session.ExpiresAt, &session.CreatedAt)
+	if err != nil {
+		logger.Log.Warnf("Error creating session for user %d, err %v", userID, err)
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// Rotate заменяет refresh-токен сессии и продлевает её. Предъявленный токен должен быть текущим.
+// Повторное использование уже заменённого токена той же сессии означает его утечку, поэтому сессия отзывается.
+// Любой другой несовпадающий токен просто отклоняется, сессия при этом не меняется.
+func (s SessionRepository) Rotate(
+	ctx context.Context, sessionID uint64, presentedHash string, newHash string) (Session, error) {
+	transaction, txErr := s.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Session{}, txErr
+	}
+	lockSessionPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT user_id, refresh_token_hash, revoked_at IS NULL AND expires_at > NOW()
+				FROM "session"
+				WHERE id = $1
+				FOR UPDATE`)
+	if err != nil {
+		return Session{}, rollbackWithError(transaction, err)
+	}
+	session := Session{ID: sessionID}
+	var active bool
+	err = lockSessionPreparedStmt.QueryRowContext(ctx, sessionID).Scan(
+		&session.UserID, &session.RefreshTokenHash, &active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrSessionNotFound
+		}
+		return Session{}, rollbackWithError(transaction, err)
+	}
+	if !active {
+		return Session{}, rollbackWithError(transaction, ErrSessionNotActive)
+	}
+	if subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(presentedHash)) != 1 {
+		reused, reusedErr := isRotatedToken(ctx, transaction, sessionID, presentedHash)
+		if reusedErr != nil {
+			return Session{}, rollbackWithError(transaction, reusedErr)
+		}
+		if !reused {
+			return Session{}, rollbackWithError(transaction, ErrRefreshTokenMismatch)
+		}
+		logger.Log.Warnf("Reused refresh token of session %d, revoking the session", sessionID)
+		if err = revokeSession(ctx, transaction, sessionID); err != nil {
+			return Session{}, rollbackWithError(transaction, err)
+		}
+		if txErr = transaction.Commit(); txErr != nil {
+			return Session{}, txErr
+		}
+		return Session{}, ErrRefreshTokenReused
+	}
+
+	rememberRotatedPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "session_rotated_token" (session_id, token_hash) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return Session{}, rollbackWithError(transaction, err)
+	}
+	if _, err = rememberRotatedPreparedStmt.ExecContext(ctx, sessionID, presentedHash); err != nil {
+		return Session{}, rollbackWithError(transaction, err)
+	}
+	rotatePreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`UPDATE "session"
+				SET refresh_token_hash = $1, expires_at = NOW() + make_interval(secs => $2), refreshed_at = NOW()
+				WHERE id = $3
+				RETURNING expires_at, created_at`)
+	if err != nil {
+		return Session{}, rollbackWithError(transaction, err)
+	}
+	err = rotatePreparedStmt.QueryRowContext(ctx, newHash, s.ttl.Seconds(), sessionID).Scan(
+		&session.ExpiresAt, &session.CreatedAt)
+	if err != nil {
+		return Session{}, rollbackWithError(transaction, err)
+	}
+	session.RefreshTokenHash = newHash
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return Session{}, txErr
+	}
+	return session, nil
+}
+
+func (s SessionRepository) IsActive(ctx context.Context, sessionID uint64) (bool, error) {
+	selectActivePreparedStmt, err := s.pool.PrepareContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM "session" WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())`)
+	if err != nil {
+		return false, err
+	}
+	var active bool
+	err = selectActivePreparedStmt.QueryRowContext(ctx, sessionID).Scan(&active)
+	return active, err
+}
+
+func (s SessionRepository) Revoke(ctx context.Context, sessionID uint64, userID uint64) error {
+	revokePreparedStmt, err := s.pool.PrepareContext(
+		ctx,
+		`UPDATE "session" SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND user_id = $2`)
+	if err != nil {
+		return err
+	}
+	result, err := revokePreparedStmt.ExecContext(ctx, sessionID, userID)
+	if err != nil {
+		logger.Log.Warnf("Error revoking session %d, err %v", sessionID, err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeByTokenHash отзывает сессию по её refresh-токену, текущему или уже заменённому,
+// и возвращает пользователя сессии. Нужен для выхода, когда access-токен уже истёк.
+func (s SessionRepository) RevokeByTokenHash(ctx context.Context, sessionID uint64, tokenHash string) (uint64, error) {
+	revokePreparedStmt, err := s.pool.PrepareContext(
+		ctx,
+		`UPDATE "session" SET revoked_at = COALESCE(revoked_at, NOW())
+				WHERE id = $1
+					AND (refresh_token_hash = $2
+						OR EXISTS(SELECT 1 FROM "session_rotated_token" WHERE session_id = $1 AND token_hash = $2))
+				RETURNING user_id`)
+	if err != nil {
+		return 0, err
+	}
+	var userID uint64
+	err = revokePreparedStmt.QueryRowContext(ctx, sessionID, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrSessionNotFound
+		}
+		logger.Log.Warnf("Error revoking session %d by refresh token, err %v", sessionID, err)
+		return 0, err
+	}
+	return userID, nil
+}
+
+// RevokeAllByUserID отзывает все действующие сессии пользователя и возвращает их идентификаторы.
+func (s SessionRepository) RevokeAllByUserID(ctx context.Context, userID uint64) ([]uint64, error) {
+	revokeAllPreparedStmt, err := s.pool.PrepareContext(
+		ctx,
+		`UPDATE "session" SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := revokeAllPreparedStmt.QueryContext(ctx, userID)
+	if err != nil {
+		logger.Log.Warnf("Error revoking sessions of user %d, err %v", userID, err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var sessionIDs []uint64
+	for rows.Next() {
+		var sessionID uint64
+		if scanErr := rows.Scan(&sessionID); scanErr != nil {
+			return nil, scanErr
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return sessionIDs, nil
+}
+
+// DeleteExpired удаляет истёкшие и отозванные сессии: обновить их уже нельзя.
+func (s SessionRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	deleteExpiredPreparedStmt, err := s.pool.PrepareContext(
+		ctx, `DELETE FROM "session" WHERE expires_at <= NOW() OR revoked_at IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	result, err := deleteExpiredPreparedStmt.ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func isRotatedToken(ctx context.Context, transaction *sql.Tx, sessionID uint64, tokenHash string) (bool, error) {
+	selectRotatedPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM "session_rotated_token" WHERE session_id = $1 AND token_hash = $2)`)
+	if err != nil {
+		return false, err
+	}
+	var rotated bool
+	err = selectRotatedPreparedStmt.QueryRowContext(ctx, sessionID, tokenHash).Scan(&rotated)
+	return rotated, err
+}
+
+func revokeSession(ctx context.Context, transaction *sql.Tx, sessionID uint64) error {
+	revokePreparedStmt, err := transaction.PrepareContext(
+		ctx, `UPDATE "session" SET revoked_at = NOW() WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	_, err = revokePreparedStmt.ExecContext(ctx, sessionID)
+	return err
+}
@@ -0,0 +1,23 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+)
+
+// rollbackWithError откатывает транзакцию и возвращает исходную ошибку,
+// либо ошибку отката, если откатить не удалось.
+func rollbackWithError(transaction *sql.Tx, err error) error {
+	txErr := transaction.Rollback()
+	if txErr != nil {
+		logger.Log.Warnf("Error during transaction rollback, err %v", txErr)
+		return txErr
+	}
+	return err
+}
+
+// preparer позволяет выполнять один и тот же запрос и в пуле, и внутри транзакции.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
@@ -0,0 +1,314 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"time"
+)
+
+type Transfer struct {
+	ID          uint64
+	FromUserID  uint64
+	FromLogin   string
+	ToUserID    uint64
+	ToLogin     string
+	Amount      money.Amount
+	Reference   string
+	CreatedAt   time.Time
+	IsDuplicate bool
+}
+
+// TransferLimits ограничивает переводы отправителя, нулевые значения отключают соответствующую проверку.
+type TransferLimits struct {
+	MinAmount   money.Amount
+	MaxAmount   money.Amount
+	DailyAmount money.Amount
+}
+
+type TransferRepositoryInterface interface {
+	Create(
+		ctx context.Context,
+		fromUserID uint64,
+		toLogin string,
+		amount money.Amount,
+		reference string,
+		limits TransferLimits) (Transfer, error)
+	ReadAllByUserID(ctx context.Context, userID uint64) ([]Transfer, error)
+}
+
+var ErrTransferRecipientNotFound = errors.New("transfer recipient not found or deactivated")
+var ErrTransferToSelf = errors.New("transfer to self is not allowed")
+var ErrTransferLimitExceeded = errors.New("transfer limit exceeded")
+var ErrTransferReferenceConflict = errors.New("transfer reference already used for a different transfer")
+
+type TransferRepository struct {
+	pool *sql.DB
+}
+
+func NewTransferRepository(pool *sql.DB) *TransferRepository {
+	return &TransferRepository{pool: pool}
+}
+
+// Create переводит баллы между пользователями в одной транзакции.
+// Повтор с тем же reference возвращает уже созданный перевод с IsDuplicate.
+func (t TransferRepository) Create(
+	ctx context.Context,
+	fromUserID uint64,
+	toLogin string,
+	amount money.Amount,
+	reference string,
+	limits TransferLimits) (Transfer, error) {
+	selectRecipientPreparedStmt, err := t.pool.PrepareContext(
+		ctx, `SELECT id, login FROM "user" WHERE login = $1 AND active`)
+	if err != nil {
+		return Transfer{}, err
+	}
+	transfer := Transfer{FromUserID: fromUserID, Amount: amount, Reference: reference}
+	err = selectRecipientPreparedStmt.QueryRowContext(ctx, toLogin).Scan(&transfer.ToUserID, &transfer.ToLogin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transfer{}, ErrTransferRecipientNotFound
+		}
+		return Transfer{}, err
+	}
+	if transfer.ToUserID == fromUserID {
+		return Transfer{}, ErrTransferToSelf
+	}
+
+	transaction, txErr := t.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return Transfer{}, txErr
+	}
+	// Балансы блокируются в порядке возрастания id, чтобы встречные переводы не взаимоблокировались
+	firstUserID, secondUserID := fromUserID, transfer.ToUserID
+	if firstUserID > secondUserID {
+		firstUserID, secondUserID = secondUserID, firstUserID
+	}
+	for _, userID := range []uint64{firstUserID, secondUserID} {
+		if err = lockUserBalance(ctx, transaction, userID); err != nil {
+			logger.Log.Warnf("error locking balance of user %d: %v", userID, err)
+			if errors.Is(err, ErrUserNotFound) && userID == transfer.ToUserID {
+				err = ErrTransferRecipientNotFound
+			}
+			return Transfer{}, rollbackWithError(transaction, err)
+		}
+	}
+
+	if reference != "" {
+		existing, found, findErr := findTransferByReference(ctx, transaction, fromUserID, reference)
+		if findErr != nil {
+			return Transfer{}, rollbackWithError(transaction, findErr)
+		}
+		if found {
+			if existing.ToUserID != transfer.ToUserID || existing.Amount.Cmp(amount) != 0 {
+				return Transfer{}, rollbackWithError(transaction, ErrTransferReferenceConflict)
+			}
+			existing.ToLogin = transfer.ToLogin
+			existing.IsDuplicate = true
+			return existing, transaction.Rollback()
+		}
+	}
+
+	if err = checkTransferLimits(ctx, transaction, fromUserID, amount, limits); err != nil {
+		return Transfer{}, rollbackWithError(transaction, err)
+	}
+	available, err := readAvailablePoints(ctx, transaction, fromUserID, DefaultWallet)
+	if err != nil {
+		return Transfer{}, rollbackWithError(transaction, err)
+	}
+	if amount.Cmp(available) > 0 {
+		logger.Log.Infof("Insufficient points for transfer of user %d", fromUserID)
+		return Transfer{}, rollbackWithError(transaction, ErrNotEnoughPoints)
+	}
+
+	var referenceValue sql.NullString
+	if reference != "" {
+		referenceValue = sql.NullString{String: reference, Valid: true}
+	}
+	createTransferPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "points_transfer" (from_user_id, to_user_id, amount, reference)
+				VALUES ($1, $2, $3, $4)
+				RETURNING id, created_at`)
+	if err != nil {
+		return Transfer{}, rollbackWithError(transaction, err)
+	}
+	err = createTransferPreparedStmt.QueryRowContext(
+		ctx, fromUserID, transfer.ToUserID, amount, referenceValue).Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		logger.Log.Warnf("Error creating transfer from user %d, err %v", fromUserID, err)
+		return Transfer{}, rollbackWithError(transaction, err)
+	}
+	if err = movePoints(ctx, transaction, transfer); err != nil {
+		return Transfer{}, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return Transfer{}, txErr
+	}
+	return transfer, nil
+}
+
+func (t TransferRepository) ReadAllByUserID(ctx context.Context, userID uint64) ([]Transfer, error) {
+	selectTransfersPreparedStmt, err := t.pool.PrepareContext(
+		ctx,
+		`SELECT pt.id, pt.from_user_id, fu.login, pt.to_user_id, tu.login, pt.amount,
+					COALESCE(pt.reference, ''), pt.created_at
+				FROM "points_transfer" pt
+					JOIN "user" fu ON fu.id = pt.from_user_id
+					JOIN "user" tu ON tu.id = pt.to_user_id
+				WHERE pt.from_user_id = $1 OR pt.to_user_id = $1
+				ORDER BY pt.created_at DESC, pt.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := selectTransfersPreparedStmt.QueryContext(ctx, userID)
+	if err != nil {
+		logger.Log.Errorf("error during transfers selection: %v", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var transfers []Transfer
+	for rows.Next() {
+		transfer := new(Transfer)
+		scanErr := rows.Scan(
+			&transfer.ID,
+			&transfer.FromUserID,
+			&transfer.FromLogin,
+			&transfer.ToUserID,
+			&transfer.ToLogin,
+			&transfer.Amount,
+			&transfer.Reference,
+			&transfer.CreatedAt,
+		)
+		if scanErr != nil {
+			logger.Log.Error(scanErr.Error())
+			return nil, scanErr
+		}
+		transfers = append(transfers, *transfer)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return transfers, nil
+}
+
+func findTransferByReference(
+	ctx context.Context, transaction *sql.Tx, fromUserID uint64, reference string) (Transfer, bool, error) {
+	selectTransferPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT id, from_user_id, to_user_id, amount, reference, created_at
+				FROM "points_transfer"
+				WHERE from_user_id = $1 AND reference = $2`)
+	if err != nil {
+		return Transfer{}, false, err
+	}
+	var transfer Transfer
+	err = selectTransferPreparedStmt.QueryRowContext(ctx, fromUserID, reference).Scan(
+		&transfer.ID,
+		&transfer.FromUserID,
+		&transfer.ToUserID,
+		&transfer.Amount,
+		&transfer.Reference,
+		&transfer.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transfer{}, false, nil
+		}
+		return Transfer{}, false, err
+	}
+	return transfer, true, nil
+}
+
+func checkTransferLimits(
+	ctx context.Context, transaction *sql.Tx, fromUserID uint64, amount money.Amount, limits TransferLimits) error {
+	if limits.MinAmount.IsPositive() && amount.Cmp(limits.MinAmount) < 0 {
+		return ErrTransferLimitExceeded
+	}
+	if limits.MaxAmount.IsPositive() && amount.Cmp(limits.MaxAmount) > 0 {
+		return ErrTransferLimitExceeded
+	}
+	if !limits.DailyAmount.IsPositive() {
+		return nil
+	}
+	selectDailySumPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(amount), 0)
+				FROM "points_transfer"
+				WHERE from_user_id = $1 AND created_at > NOW() - INTERVAL '1 day'`)
+	if err != nil {
+		return err
+	}
+	var dailySum money.Amount
+	err = selectDailySumPreparedStmt.QueryRowContext(ctx, fromUserID).Scan(&dailySum)
+	if err != nil {
+		return err
+	}
+	// Переполнение тоже означает превышение лимита
+	total, err := dailySum.Add(amount)
+	if err != nil || total.Cmp(limits.DailyAmount) > 0 {
+		return ErrTransferLimitExceeded
+	}
+	return nil
+}
+
+// movePoints списывает баллы отправителя по FIFO и начисляет их получателю партиями с теми же сроками сгорания,
+// чтобы перевод не продлевал жизнь баллов.
+func movePoints(ctx context.Context, transaction *sql.Tx, transfer Transfer) error {
+	outAmount, err := transfer.Amount.Neg()
+	if err != nil {
+		return err
+	}
+	outTransactionID, err := postLedgerTransaction(ctx, transaction, LedgerPosting{
+		UserID:    transfer.FromUserID,
+		Kind:      LedgerKindTransferOut,
+		Amount:    outAmount,
+		Reference: transfer.Reference,
+		SourceID:  transfer.ID,
+	})
+	if err != nil {
+		return err
+	}
+	portions, err := consumeLiveLots(
+		ctx, transaction, transfer.FromUserID, DefaultWallet, transfer.Amount, outTransactionID, false)
+	if err != nil {
+		return err
+	}
+	inTransactionID, err := postLedgerTransaction(ctx, transaction, LedgerPosting{
+		UserID:    transfer.ToUserID,
+		Kind:      LedgerKindTransferIn,
+		Amount:    transfer.Amount,
+		Reference: transfer.Reference,
+		SourceID:  transfer.ID,
+	})
+	if err != nil {
+		return err
+	}
+	createLotPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "points_lot" (user_id, ledger_transaction_id, amount, remaining, expires_at)
+				VALUES ($1, $2, $3, $3, $4)`)
+	if err != nil {
+		return err
+	}
+	for _, portion := range portions {
+		_, err = createLotPreparedStmt.ExecContext(
+			ctx, transfer.ToUserID, inTransactionID, portion.amount, portion.expiresAt)
+		if err != nil {
+			logger.Log.Warnf("Error inserting transferred points lot for user %d, err %v", transfer.ToUserID, err)
+			return err
+		}
+	}
+	return nil
+}
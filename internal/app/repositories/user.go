@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 )
@@ -15,10 +16,29 @@ type User struct {
 	Password string
 }
 
+// WalletBalances — баланс одного кошелька пользователя.
+type WalletBalances struct {
+	Wallet    string
+	Current   money.Amount
+	Withdrawn money.Amount
+	Held      money.Amount
+	Expiring  *ExpiringPoints
+}
+
+// Balances содержит баланс основного кошелька и балансы всех кошельков пользователя, включая основной.
+type Balances struct {
+	WalletBalances
+	Wallets []WalletBalances
+}
+
 type UserRepositoryInterface interface {
-	Create(ctx context.Context, login string, password string) (User, error)
+	Create(ctx context.Context, login string, password string, referralCode string, referrerCode string) (User, error)
 	Read(ctx context.Context, login string) (User, error)
-	GetBalances(ctx context.Context, userID uint64) (float32, float32, error)
+	GetBalances(ctx context.Context, userID uint64) (Balances, error)
+	IsAdmin(ctx context.Context, userID uint64) (bool, error)
+	ReadByID(ctx context.Context, userID uint64) (User, error)
+	ReadAllActive(ctx context.Context) ([]User, error)
+	ReadTier(ctx context.Context, userID uint64) (string, error)
 }
 
 var ErrLoginAlreadyTaken = errors.New("login already taken")
@@ -32,17 +52,20 @@ func NewUserRepository(pool *sql.DB) *UserRepository {
 	return &UserRepository{pool: pool}
 }
 
-func (u UserRepository) Create(ctx context.Context, login string, password string) (User, error) {
+// Create регистрирует пользователя с его реферальным кодом referralCode.
+// Непустой referrerCode связывает нового пользователя с пригласившим в той же транзакции.
+func (u UserRepository) Create(
+	ctx context.Context, login string, password string, referralCode string, referrerCode string) (User, error) {
 	transaction, txErr := u.pool.BeginTx(ctx, nil)
 	if txErr != nil {
 		return User{}, txErr
 	}
 	createUserPreparedStmt, err := transaction.PrepareContext(
-		ctx, `INSERT INTO "user" (login, password) VALUES ($1, $2) RETURNING id, login, password`)
+		ctx, `INSERT INTO "user" (login, password, referral_code) VALUES ($1, $2, $3) RETURNING id, login, password`)
 	if err != nil {
-		return User{}, err
+		return User{}, rollbackWithError(transaction, err)
 	}
-	row := createUserPreparedStmt.QueryRowContext(ctx, login, password)
+	row := createUserPreparedStmt.QueryRowContext(ctx, login, password, referralCode)
 	var ID uint64
 	var selectedLogin string
 	var selectedPassword string
@@ -50,27 +73,27 @@ func (u UserRepository) Create(ctx context.Context, login string, password strin
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+			if pgErr.ConstraintName == referralCodeConstraint {
+				return User{}, rollbackWithError(transaction, ErrReferralCodeTaken)
+			}
 			logger.Log.Infof("Login %s already taken", login)
-			return User{}, ErrLoginAlreadyTaken
+			return User{}, rollbackWithError(transaction, ErrLoginAlreadyTaken)
 		}
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			return User{}, txErr
-		}
-		return User{}, err
+		return User{}, rollbackWithError(transaction, err)
 	}
 	createUserBalancePreparedStmt, err := transaction.PrepareContext(
 		ctx, `INSERT INTO "user-balance" (user_id) VALUES ($1)`)
 	if err != nil {
-		return User{}, err
+		return User{}, rollbackWithError(transaction, err)
 	}
 	_, err = createUserBalancePreparedStmt.ExecContext(ctx, ID)
 	if err != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			return User{}, txErr
+		return User{}, rollbackWithError(transaction, err)
+	}
+	if referrerCode != "" {
+		if err = createReferral(ctx, transaction, referrerCode, ID); err != nil {
+			return User{}, rollbackWithError(transaction, err)
 		}
-		return User{}, err
 	}
 	txErr = transaction.Commit()
 	if txErr != nil {
@@ -111,21 +134,164 @@ func (u UserRepository) Read(ctx context.Context, login string) (User, error) {
 	return user, nil
 }
 
-func (u UserRepository) GetBalances(ctx context.Context, userID uint64) (float32, float32, error) {
-	getUserBalancePreparedStmt, err := u.pool.PrepareContext(
-		ctx, `SELECT balance, withdrawals_sum FROM "user-balance" where user_id = $1`)
+func (u UserRepository) GetBalances(ctx context.Context, userID uint64) (Balances, error) {
+	transaction, txErr := u.pool.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if txErr != nil {
+		return Balances{}, txErr
+	}
+	selectWalletsPreparedStmt, err := transaction.PrepareContext(
+		ctx, `SELECT wallet FROM "user-balance" WHERE user_id = $1 ORDER BY wallet <> $2, wallet`)
+	if err != nil {
+		return Balances{}, rollbackWithError(transaction, err)
+	}
+	wallets, err := readStrings(ctx, selectWalletsPreparedStmt, userID, DefaultWallet)
+	if err != nil {
+		return Balances{}, rollbackWithError(transaction, err)
+	}
+	if len(wallets) == 0 || wallets[0] != DefaultWallet {
+		return Balances{}, rollbackWithError(transaction, ErrUserNotFound)
+	}
+	var balances Balances
+	for _, wallet := range wallets {
+		walletBalances, readErr := readWalletBalances(ctx, transaction, userID, wallet)
+		if readErr != nil {
+			return Balances{}, rollbackWithError(transaction, readErr)
+		}
+		balances.Wallets = append(balances.Wallets, walletBalances)
+	}
+	balances.WalletBalances = balances.Wallets[0]
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return Balances{}, txErr
+	}
+	return balances, nil
+}
+
+func readWalletBalances(
+	ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) (WalletBalances, error) {
+	balances := WalletBalances{Wallet: wallet}
+	var err error
+	balances.Current, balances.Withdrawn, err = readLedgerBalances(ctx, transaction, userID, wallet)
+	if err != nil {
+		return WalletBalances{}, err
+	}
+	balances.Held, err = readHeldPoints(ctx, transaction, userID, wallet)
+	if err != nil {
+		return WalletBalances{}, err
+	}
+	balances.Current, err = balances.Current.Sub(balances.Held)
+	if err != nil {
+		return WalletBalances{}, err
+	}
+	balances.Expiring, err = readSoonestExpiring(ctx, transaction, userID, wallet)
+	if err != nil {
+		return WalletBalances{}, err
+	}
+	return balances, nil
+}
+
+func readStrings(ctx context.Context, stmt *sql.Stmt, args ...any) ([]string, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var values []string
+	for rows.Next() {
+		var value string
+		if scanErr := rows.Scan(&value); scanErr != nil {
+			return nil, scanErr
+		}
+		values = append(values, value)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return values, nil
+}
+
+func (u UserRepository) IsAdmin(ctx context.Context, userID uint64) (bool, error) {
+	selectIsAdminPreparedStmt, err := u.pool.PrepareContext(
+		ctx, `SELECT is_admin FROM "user" WHERE id = $1 AND active`)
+	if err != nil {
+		return false, err
+	}
+	var isAdmin bool
+	err = selectIsAdminPreparedStmt.QueryRowContext(ctx, userID).Scan(&isAdmin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+	return isAdmin, nil
+}
+
+func (u UserRepository) ReadByID(ctx context.Context, userID uint64) (User, error) {
+	readUserByIDPreparedStmt, err := u.pool.PrepareContext(
+		ctx, `SELECT id, login FROM "user" WHERE id = $1 AND active`)
+	if err != nil {
+		return User{}, err
+	}
+	var user User
+	err = readUserByIDPreparedStmt.QueryRowContext(ctx, userID).Scan(&user.ID, &user.Login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// ReadAllActive возвращает всех активных пользователей без паролей.
+func (u UserRepository) ReadAllActive(ctx context.Context) ([]User, error) {
+	readAllUsersPreparedStmt, err := u.pool.PrepareContext(
+		ctx, `SELECT id, login FROM "user" WHERE active ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readAllUsersPreparedStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		innerErr := rows.Close()
+		if innerErr != nil {
+			logger.Log.Errorf("error closing rows: %v", innerErr)
+		}
+	}(rows)
+	var users []User
+	for rows.Next() {
+		var user User
+		if scanErr := rows.Scan(&user.ID, &user.Login); scanErr != nil {
+			return nil, scanErr
+		}
+		users = append(users, user)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return users, nil
+}
+
+func (u UserRepository) ReadTier(ctx context.Context, userID uint64) (string, error) {
+	selectTierPreparedStmt, err := u.pool.PrepareContext(ctx, `SELECT tier FROM "user" WHERE id = $1`)
 	if err != nil {
-		return 0.0, 0.0, err
+		return "", err
 	}
-	row := getUserBalancePreparedStmt.QueryRowContext(ctx, userID)
-	var balance float32
-	var withdrawalsSum float32
-	err = row.Scan(&balance, &withdrawalsSum)
+	var tier string
+	err = selectTierPreparedStmt.QueryRowContext(ctx, userID).Scan(&tier)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return 0.0, 0.0, ErrUserNotFound
+			return "", ErrUserNotFound
 		}
-		return 0.0, 0.0, err
+		return "", err
 	}
-	return balance, withdrawalsSum, nil
+	return tier, nil
 }
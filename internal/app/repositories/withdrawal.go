@@ -5,26 +5,86 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"time"
 )
 
 type Withdrawal struct {
-	ID          uint64    `json:"-"`
-	UserID      uint64    `json:"-"`
-	OrderNumber string    `json:"order"`
-	Amount      float64   `json:"sum"`
-	CreatedAt   time.Time `json:"processed_at"`
+	ID          uint64       `json:"-"`
+	UserID      uint64       `json:"-"`
+	Wallet      string       `json:"wallet"`
+	OrderNumber string       `json:"order"`
+	Amount      money.Amount `json:"sum"`
+	CreatedAt   time.Time    `json:"processed_at"`
+	Reversed    money.Amount `json:"reversed,omitempty"`
+	ReversedAt  sql.NullTime `json:"-"`
+}
+
+// WithdrawalCursor указывает на последнее отданное списание, следующая страница начинается после него.
+type WithdrawalCursor struct {
+	CreatedAt time.Time
+	ID        uint64
+}
+
+// WithdrawalFilter задаёт полуинтервал дат [From, To), границы суммы включительно и страницу.
+// Нулевые значения не ограничивают выборку, Limit 0 означает все списания.
+type WithdrawalFilter struct {
+	From      time.Time
+	To        time.Time
+	MinAmount money.NullAmount
+	MaxAmount money.NullAmount
+	After     *WithdrawalCursor
+	Limit     uint64
+}
+
+type WithdrawalReversal struct {
+	ID           uint64
+	WithdrawalID uint64
+	UserID       uint64
+	OrderNumber  string
+	Amount       money.Amount
+	Reason       string
+	CreatedBy    uint64
+	CreatedAt    time.Time
+}
+
+// WithdrawalAllowance — то, от чего зависит допустимая сумма списания: доступный остаток кошелька
+// и сумма списаний с действующими резервами за текущие сутки и месяц.
+type WithdrawalAllowance struct {
+	Available      money.Amount
+	SpentToday     money.Amount
+	SpentThisMonth money.Amount
 }
 
 type WithdrawalRepositoryInterface interface {
-	Create(ctx context.Context, number string, amount float64, userID uint64) (uint64, error)
-	ReadAllByUserID(ctx context.Context, userID uint64) ([]Withdrawal, error)
+	Create(
+		ctx context.Context,
+		number string,
+		amount money.Amount,
+		wallet string,
+		userID uint64,
+		caps WithdrawalCaps) (uint64, error)
+	ReadAllowance(ctx context.Context, userID uint64, wallet string) (WithdrawalAllowance, error)
+	ReadAllByUserID(
+		ctx context.Context, userID uint64, filter WithdrawalFilter) ([]Withdrawal, *WithdrawalCursor, error)
+	StreamAllByUserID(
+		ctx context.Context,
+		userID uint64,
+		filter WithdrawalFilter,
+		consume func(withdrawal Withdrawal) error) (*WithdrawalCursor, error)
+	GetListVersion(ctx context.Context, userID uint64) (ListVersion, error)
+	Reverse(
+		ctx context.Context, number string, amount money.Amount, reason string, adminID uint64) (WithdrawalReversal, error)
 }
 
 var ErrNotEnoughPoints = errors.New("not enough points")
 var ErrWithdrawalOrderAlreadyExists = errors.New(" withdrawal order number already exists")
+var ErrDailyWithdrawalCapExceeded = errors.New("daily withdrawal cap exceeded")
+var ErrMonthlyWithdrawalCapExceeded = errors.New("monthly withdrawal cap exceeded")
+var ErrWithdrawalNotFound = errors.New("no withdrawal found with the given order number")
+var ErrReversalExceedsWithdrawal = errors.New("reversal exceeds the withdrawn amount")
 
 type WithdrawalRepository struct {
 	pool *sql.DB
@@ -34,151 +94,432 @@ func NewWithdrawalRepository(pool *sql.DB) WithdrawalRepositoryInterface {
 	return &WithdrawalRepository{pool}
 }
 
-func (w WithdrawalRepository) Create(ctx context.Context, number string, amount float64, userID uint64) (uint64, error) {
+// WithdrawalCaps ограничивает сумму списаний за календарные сутки и месяц (UTC), нулевые значения не ограничивают.
+type WithdrawalCaps struct {
+	Daily   money.Amount
+	Monthly money.Amount
+}
+
+func (w WithdrawalRepository) Create(
+	ctx context.Context,
+	number string,
+	amount money.Amount,
+	wallet string,
+	userID uint64,
+	caps WithdrawalCaps) (uint64, error) {
+	wallet = walletOrDefault(wallet)
 	transaction, txErr := w.pool.BeginTx(ctx, nil)
 	if txErr != nil {
 		return 0, txErr
 	}
 
-	selectUserBalance, err := transaction.PrepareContext(
-		ctx, `SELECT balance FROM "user-balance" WHERE user_id = $1 FOR UPDATE`)
+	err := lockUserBalance(ctx, transaction, userID)
 	if err != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			return 0, txErr
-		}
-		return 0, err
+		logger.Log.Warnf("error locking balance of user %d: %v", userID, err)
+		return 0, rollbackWithError(transaction, err)
 	}
-
-	row := selectUserBalance.QueryRowContext(ctx, userID)
-	if row.Err() != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Fatal("error during transaction rollback")
-			return 0, txErr
-		}
-		logger.Log.Warnf("error preparing select for balance: %v", err)
-		return 0, row.Err()
+	// Номер под действующим резервом спишется при его захвате, прямое списание помешало бы захвату
+	holdExists, err := heldOrderNumberExists(ctx, transaction, number)
+	if err != nil {
+		return 0, rollbackWithError(transaction, err)
 	}
-
-	var balance float64
-	err = row.Scan(&balance)
+	if holdExists {
+		logger.Log.Infof("withdrawal order %s is reserved by an active hold", number)
+		return 0, rollbackWithError(transaction, ErrHoldAlreadyExists)
+	}
+	available, err := readAvailablePoints(ctx, transaction, userID, wallet)
 	if err != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Fatal("error during transaction rollback")
-			return 0, txErr
-		}
 		logger.Log.Warnf("error acquiring balance: %v", err)
-		return 0, err
+		return 0, rollbackWithError(transaction, err)
 	}
-
-	if amount > balance {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Fatal("error during transaction rollback")
-			return 0, txErr
-		}
+	if amount.Cmp(available) > 0 {
 		logger.Log.Warnf(
 			"error insufficient balance for withdrawal userID %d, withdrawalOrderID %s", userID, number)
-		return 0, ErrNotEnoughPoints
+		return 0, rollbackWithError(transaction, ErrNotEnoughPoints)
 	}
-	updateUserBalancePreparedStmt, err := transaction.PrepareContext(
-		ctx,
-		`UPDATE "user-balance" 
-			    SET balance = balance - $1, withdrawals_sum = withdrawals_sum + $1 WHERE user_id = $2`)
+	if err = checkWithdrawalCaps(ctx, transaction, userID, wallet, amount, caps); err != nil {
+		return 0, rollbackWithError(transaction, err)
+	}
+
+	ID, err := createWithdrawal(ctx, transaction, number, amount, wallet, userID)
 	if err != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Fatal("error during transaction rollback")
-			return 0, txErr
-		}
-		logger.Log.Warnf("error preparing update for balance: %v", err)
-		return 0, err
+		return 0, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return 0, txErr
+	}
+	return ID, nil
+}
+
+// ReadAllowance читает остаток и сумму списаний за период теми же запросами, что и Create, но ничего не меняет.
+// Результат — снимок на момент чтения: списание по нему всё равно может не пройти, если баланс успеет измениться.
+func (w WithdrawalRepository) ReadAllowance(
+	ctx context.Context, userID uint64, wallet string) (WithdrawalAllowance, error) {
+	wallet = walletOrDefault(wallet)
+	transaction, txErr := w.pool.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if txErr != nil {
+		return WithdrawalAllowance{}, txErr
 	}
-	_, err = updateUserBalancePreparedStmt.ExecContext(ctx, amount, userID)
+	var allowance WithdrawalAllowance
+	var err error
+	allowance.Available, err = readAvailablePoints(ctx, transaction, userID, wallet)
 	if err != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Fatal("error during transaction rollback")
-			return 0, txErr
-		}
-		logger.Log.Warnf("error updating user balance during withdrawal, userID %d", userID)
-		return 0, err
+		return WithdrawalAllowance{}, rollbackWithError(transaction, err)
 	}
-	createWithdrawalPreparedStmt, err := transaction.PrepareContext(
-		ctx, `INSERT INTO withdrawal (amount, user_id, withdrawal_order_number) VALUES ($1, $2, $3) RETURNING id`)
+	allowance.SpentToday, allowance.SpentThisMonth, err = readWithdrawalSpending(ctx, transaction, userID, wallet)
 	if err != nil {
-		return 0, err
+		return WithdrawalAllowance{}, rollbackWithError(transaction, err)
 	}
+	txErr = transaction.Commit()
+	if txErr != nil {
+		return WithdrawalAllowance{}, txErr
+	}
+	return allowance, nil
+}
 
-	row = createWithdrawalPreparedStmt.QueryRowContext(ctx, amount, userID, number)
-	if row.Err() != nil {
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Fatal("error during transaction rollback")
-			return 0, txErr
-		}
-		logger.Log.Warnf("error creating withdrawal: %v", row.Err())
+// checkWithdrawalCaps проверяет лимиты кошелька с учётом уже совершённых списаний и действующих резервов.
+// Вызывается под блокировкой баланса пользователя, чтобы параллельные списания не обошли лимит.
+func checkWithdrawalCaps(
+	ctx context.Context,
+	transaction *sql.Tx,
+	userID uint64,
+	wallet string,
+	amount money.Amount,
+	caps WithdrawalCaps) error {
+	if !caps.Daily.IsPositive() && !caps.Monthly.IsPositive() {
+		return nil
+	}
+	spentToday, spentThisMonth, err := readWithdrawalSpending(ctx, transaction, userID, wallet)
+	if err != nil {
+		return err
+	}
+	if caps.Daily.IsPositive() && exceedsCap(spentToday, amount, caps.Daily) {
+		return ErrDailyWithdrawalCapExceeded
 	}
+	if caps.Monthly.IsPositive() && exceedsCap(spentThisMonth, amount, caps.Monthly) {
+		return ErrMonthlyWithdrawalCapExceeded
+	}
+	return nil
+}
 
+// exceedsCap считает переполнение суммы превышением лимита.
+func exceedsCap(spent money.Amount, amount money.Amount, limit money.Amount) bool {
+	total, err := spent.Add(amount)
+	return err != nil || total.Cmp(limit) > 0
+}
+
+// readWithdrawalSpending возвращает сумму списаний и действующих резервов кошелька за текущие сутки и месяц (UTC).
+// Возвращённые администратором суммы из лимитов исключаются.
+func readWithdrawalSpending(
+	ctx context.Context, transaction *sql.Tx, userID uint64, wallet string) (money.Amount, money.Amount, error) {
+	selectSpentPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT COALESCE(SUM(amount) FILTER (WHERE created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC')), 0),
+					COALESCE(SUM(amount), 0)
+				FROM (
+					SELECT w.amount - COALESCE(
+							(SELECT SUM(r.amount) FROM "withdrawal_reversal" r WHERE r.withdrawal_id = w.id), 0
+						) AS amount,
+						w.created_at
+					FROM withdrawal w
+					WHERE w.user_id = $1 AND w.wallet = $3
+						AND w.created_at >= date_trunc('month', NOW() AT TIME ZONE 'UTC')
+					UNION ALL
+					SELECT amount, created_at FROM "withdrawal_hold"
+					WHERE user_id = $1 AND wallet = $3 AND status = $2 AND expires_at > NOW()
+						AND created_at >= date_trunc('month', NOW() AT TIME ZONE 'UTC')
+				) spent`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var spentToday, spentThisMonth money.Amount
+	err = selectSpentPreparedStmt.QueryRowContext(
+		ctx, userID, HoldStatusHeld, wallet).Scan(&spentToday, &spentThisMonth)
+	if err != nil {
+		return 0, 0, err
+	}
+	return spentToday, spentThisMonth, nil
+}
+
+// createWithdrawal записывает списание и проводит его по журналу. Достаточность баллов проверяет вызывающий.
+func createWithdrawal(
+	ctx context.Context,
+	transaction *sql.Tx,
+	number string,
+	amount money.Amount,
+	wallet string,
+	userID uint64) (uint64, error) {
+	createWithdrawalPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO withdrawal (amount, user_id, withdrawal_order_number, wallet)
+				VALUES ($1, $2, $3, $4)
+				RETURNING id`)
+	if err != nil {
+		logger.Log.Warnf("error preparing insert for withdrawal: %v", err)
+		return 0, err
+	}
 	var ID uint64
-	err = row.Scan(&ID)
+	err = createWithdrawalPreparedStmt.QueryRowContext(ctx, amount, userID, number, wallet).Scan(&ID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
 			logger.Log.Infof("Withdrawal order number %s already exists", number)
 			return 0, ErrWithdrawalOrderAlreadyExists
 		}
-		txErr = transaction.Rollback()
-		if txErr != nil {
-			logger.Log.Warn("error during transaction rollback")
-			return 0, txErr
-		}
 		logger.Log.Warnf("error creating withdrawal: %v", err)
 		return 0, err
 	}
-	txErr = transaction.Commit()
-	if txErr != nil {
-		logger.Log.Fatal("error during transaction commit")
-		return 0, txErr
+
+	debit, err := amount.Neg()
+	if err != nil {
+		return 0, err
+	}
+	_, err = debitPoints(ctx, transaction, LedgerPosting{
+		UserID:    userID,
+		Wallet:    wallet,
+		Kind:      LedgerKindWithdrawal,
+		Amount:    debit,
+		Reference: number,
+		SourceID:  ID,
+	}, false)
+	if err != nil {
+		logger.Log.Warnf("error posting withdrawal %s to ledger: %v", number, err)
+		return 0, err
 	}
 	return ID, nil
+}
 
+func (w WithdrawalRepository) ReadAllByUserID(
+	ctx context.Context, userID uint64, filter WithdrawalFilter) ([]Withdrawal, *WithdrawalCursor, error) {
+	var withdrawals []Withdrawal
+	next, err := w.StreamAllByUserID(ctx, userID, filter, func(withdrawal Withdrawal) error {
+		withdrawals = append(withdrawals, withdrawal)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return withdrawals, next, nil
 }
 
-func (w WithdrawalRepository) ReadAllByUserID(ctx context.Context, userID uint64) ([]Withdrawal, error) {
+// StreamAllByUserID отдаёт списания от новых к старым. Если после страницы из filter.Limit списаний
+// есть ещё, возвращается курсор следующей страницы.
+func (w WithdrawalRepository) StreamAllByUserID(
+	ctx context.Context,
+	userID uint64,
+	filter WithdrawalFilter,
+	consume func(withdrawal Withdrawal) error) (*WithdrawalCursor, error) {
 	selectAllWithdrawalsStmt, err := w.pool.PrepareContext(
 		ctx,
-		"SELECT id, amount, user_id, created_at, withdrawal_order_number FROM withdrawal WHERE user_id = $1")
+		`SELECT w.id, w.amount, w.user_id, w.wallet, w.created_at, w.withdrawal_order_number,
+					COALESCE(r.total, 0), r.last_reversed_at
+				FROM withdrawal w
+					LEFT JOIN (
+						SELECT withdrawal_id, SUM(amount) AS total, MAX(created_at) AS last_reversed_at
+						FROM "withdrawal_reversal"
+						WHERE user_id = $1
+						GROUP BY withdrawal_id
+					) r ON r.withdrawal_id = w.id
+				WHERE w.user_id = $1
+					AND ($2::TIMESTAMP IS NULL OR w.created_at >= $2::TIMESTAMP)
+					AND ($3::TIMESTAMP IS NULL OR w.created_at < $3::TIMESTAMP)
+					AND ($4::NUMERIC IS NULL OR w.amount >= $4::NUMERIC)
+					AND ($5::NUMERIC IS NULL OR w.amount <= $5::NUMERIC)
+					AND ($6::TIMESTAMP IS NULL OR (w.created_at, w.id) < ($6::TIMESTAMP, $7::BIGINT))
+				ORDER BY w.created_at DESC, w.id DESC
+				LIMIT $8`)
 	if err != nil {
 		logger.Log.Error("error during prepare withdrawals select")
 		return nil, err
 	}
-	rows, err := selectAllWithdrawalsStmt.QueryContext(ctx, userID)
+	var after sql.NullTime
+	var afterID uint64
+	if filter.After != nil {
+		after = sql.NullTime{Time: filter.After.CreatedAt, Valid: true}
+		afterID = filter.After.ID
+	}
+	// Лишняя строка сверх страницы показывает, что есть следующая страница
+	var limit sql.NullInt64
+	if filter.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(filter.Limit) + 1, Valid: true}
+	}
+	rows, err := selectAllWithdrawalsStmt.QueryContext(
+		ctx,
+		userID,
+		nullTime(filter.From),
+		nullTime(filter.To),
+		filter.MinAmount,
+		filter.MaxAmount,
+		after,
+		afterID,
+		limit,
+	)
 	if err != nil {
 		logger.Log.Error("error during withdrawals selection")
 		return nil, err
 	}
-	if rows.Err() != nil {
-		logger.Log.Fatal("error during withdrawals selection")
-		return nil, rows.Err()
-	}
 	defer func(rows *sql.Rows) {
 		innerErr := rows.Close()
 		if innerErr != nil {
-			logger.Log.Error("error closing rows: %v", innerErr)
+			logger.Log.Errorf("error closing rows: %v", innerErr)
 		}
 	}(rows)
-	var withdrawals []Withdrawal
+	var consumed uint64
+	var last *Withdrawal
 	for rows.Next() {
+		if filter.Limit > 0 && consumed == filter.Limit {
+			return &WithdrawalCursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+		}
 		withdrawal := new(Withdrawal)
-		scanErr := rows.Scan(&withdrawal.ID, &withdrawal.Amount, &withdrawal.UserID, &withdrawal.CreatedAt, &withdrawal.OrderNumber)
+		scanErr := rows.Scan(
+			&withdrawal.ID,
+			&withdrawal.Amount,
+			&withdrawal.UserID,
+			&withdrawal.Wallet,
+			&withdrawal.CreatedAt,
+			&withdrawal.OrderNumber,
+			&withdrawal.Reversed,
+			&withdrawal.ReversedAt,
+		)
 		if scanErr != nil {
 			logger.Log.Error(scanErr.Error())
 			return nil, scanErr
 		}
-		withdrawals = append(withdrawals, *withdrawal)
+		if err = consume(*withdrawal); err != nil {
+			return nil, err
+		}
+		consumed++
+		last = withdrawal
+	}
+	if rows.Err() != nil {
+		logger.Log.Errorf("error during withdrawals selection: %v", rows.Err())
+		return nil, rows.Err()
+	}
+	return nil, nil
+}
+
+func (w WithdrawalRepository) GetListVersion(ctx context.Context, userID uint64) (ListVersion, error) {
+	selectListVersionStmt, err := w.pool.PrepareContext(
+		ctx,
+		`SELECT COUNT(*), COALESCE(MAX(id), 0), GREATEST(
+					COALESCE(MAX(created_at), 'epoch'),
+					(SELECT COALESCE(MAX(created_at), 'epoch') FROM "withdrawal_reversal" WHERE user_id = $1))
+				FROM withdrawal
+				WHERE user_id = $1`)
+	if err != nil {
+		logger.Log.Error("error during prepare withdrawals version select")
+		return ListVersion{}, err
+	}
+	var version ListVersion
+	err = selectListVersionStmt.QueryRowContext(ctx, userID).Scan(&version.Count, &version.MaxID, &version.LastModified)
+	if err != nil {
+		logger.Log.Errorf("error during withdrawals version selection: %v", err)
+		return ListVersion{}, err
+	}
+	return version, nil
+}
+
+// Reverse возвращает пользователю баллы по списанию целиком или частично.
+// Нулевая сумма означает возврат всего ещё не возвращённого остатка списания.
+func (w WithdrawalRepository) Reverse(
+	ctx context.Context, number string, amount money.Amount, reason string, adminID uint64) (WithdrawalReversal, error) {
+	selectOwnerPreparedStmt, err := w.pool.PrepareContext(
+		ctx, `SELECT user_id FROM withdrawal WHERE withdrawal_order_number = $1`)
+	if err != nil {
+		return WithdrawalReversal{}, err
+	}
+	var userID uint64
+	err = selectOwnerPreparedStmt.QueryRowContext(ctx, number).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WithdrawalReversal{}, ErrWithdrawalNotFound
+		}
+		return WithdrawalReversal{}, err
+	}
+
+	transaction, txErr := w.pool.BeginTx(ctx, nil)
+	if txErr != nil {
+		return WithdrawalReversal{}, txErr
+	}
+	err = lockUserBalance(ctx, transaction, userID)
+	if err != nil {
+		return WithdrawalReversal{}, rollbackWithError(transaction, err)
+	}
+	lockWithdrawalPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`SELECT w.id, w.wallet, w.amount - COALESCE(
+					(SELECT SUM(r.amount) FROM "withdrawal_reversal" r WHERE r.withdrawal_id = w.id), 0)
+				FROM withdrawal w
+				WHERE w.withdrawal_order_number = $1
+				FOR UPDATE`)
+	if err != nil {
+		return WithdrawalReversal{}, rollbackWithError(transaction, err)
+	}
+	var withdrawalID uint64
+	var wallet string
+	var reversible money.Amount
+	err = lockWithdrawalPreparedStmt.QueryRowContext(ctx, number).Scan(&withdrawalID, &wallet, &reversible)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WithdrawalReversal{}, rollbackWithError(transaction, ErrWithdrawalNotFound)
+		}
+		return WithdrawalReversal{}, rollbackWithError(transaction, err)
+	}
+	if amount.IsZero() {
+		amount = reversible
+	}
+	if !amount.IsPositive() || amount.Cmp(reversible) > 0 {
+		logger.Log.Infof("Reversal of %s exceeds reversible %s for withdrawal %s", amount, reversible, number)
+		return WithdrawalReversal{}, rollbackWithError(transaction, ErrReversalExceedsWithdrawal)
+	}
+
+	var reasonValue sql.NullString
+	if reason != "" {
+		reasonValue = sql.NullString{String: reason, Valid: true}
+	}
+	createReversalPreparedStmt, err := transaction.PrepareContext(
+		ctx,
+		`INSERT INTO "withdrawal_reversal" (withdrawal_id, user_id, amount, reason, created_by)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING id, created_at`)
+	if err != nil {
+		return WithdrawalReversal{}, rollbackWithError(transaction, err)
+	}
+	reversal := WithdrawalReversal{
+		WithdrawalID: withdrawalID,
+		UserID:       userID,
+		OrderNumber:  number,
+		Amount:       amount,
+		Reason:       reason,
+		CreatedBy:    adminID,
+	}
+	err = createReversalPreparedStmt.QueryRowContext(
+		ctx, withdrawalID, userID, amount, reasonValue, adminID).Scan(&reversal.ID, &reversal.CreatedAt)
+	if err != nil {
+		logger.Log.Warnf("error creating reversal for withdrawal %s: %v", number, err)
+		return WithdrawalReversal{}, rollbackWithError(transaction, err)
+	}
+	// Возвращённые баллы не сгорают: исходные партии уже израсходованы и их сроки не восстановить
+	_, err = creditPoints(ctx, transaction, LedgerPosting{
+		UserID:    userID,
+		Wallet:    wallet,
+		Kind:      LedgerKindReversal,
+		Amount:    amount,
+		Reference: number,
+		SourceID:  reversal.ID,
+	}, sql.NullInt64{}, sql.NullTime{})
+	if err != nil {
+		logger.Log.Warnf("error posting reversal of withdrawal %s to ledger: %v", number, err)
+		return WithdrawalReversal{}, rollbackWithError(transaction, err)
+	}
+
+	txErr = transaction.Commit()
+	if txErr != nil {
+		logger.Log.Warnf("error during transaction commit: %v", txErr)
+		return WithdrawalReversal{}, txErr
 	}
-	return withdrawals, nil
+	return reversal, nil
 }
@@ -3,12 +3,14 @@ package server
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/ClearThree/gophermart-bonus/internal/app/config"
 	"github.com/ClearThree/gophermart-bonus/internal/app/handlers"
 	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
 	"github.com/ClearThree/gophermart-bonus/internal/app/middlewares"
 	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
 	"github.com/ClearThree/gophermart-bonus/internal/app/service"
+	"github.com/ClearThree/gophermart-bonus/internal/app/validators"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -20,20 +22,89 @@ import (
 
 var Pool *sql.DB
 
-func GophermartBonusRouter(pool *sql.DB) chi.Router {
+func GophermartBonusRouter(pool *sql.DB) (chi.Router, error) {
+	orderNumberChecker, err := validators.NewOrderNumberCheckerFromConfig(&config.Settings)
+	if err != nil {
+		return nil, err
+	}
+	walletChecker := validators.NewWalletCheckerFromConfig(&config.Settings, repositories.DefaultWallet)
+	loyaltyService, err := service.NewLoyaltyServiceFromConfig(
+		repositories.NewLoyaltyTierRepository(pool), repositories.NewUserRepository(pool), &config.Settings)
+	if err != nil {
+		return nil, err
+	}
+	campaignRepository := repositories.NewCampaignRepository(pool)
+	referralService, err := service.NewReferralService(repositories.NewReferralRepository(pool), &config.Settings)
+	if err != nil {
+		return nil, err
+	}
 	orderService := service.NewOrderService(
-		repositories.NewOrderRepository(pool),
-		repositories.NewAccrualRepository(&config.Settings))
+		repositories.NewOrderRepository(
+			pool, &config.Settings, campaignRepository.ApplyToAccrual, referralService.ApplyToAccrual),
+		repositories.NewAccrualRepository(&config.Settings),
+		service.NewOrderRules(repositories.NewOrderRuleRepository(pool), &config.Settings),
+		loyaltyService)
 	userService := service.NewUserService(repositories.NewUserRepository(pool))
-	withdrawalService := service.NewWithdrawalService(repositories.NewWithdrawalRepository(pool))
+	campaignService := service.NewCampaignService(campaignRepository)
+	promoCodeService := service.NewPromoCodeService(repositories.NewPromoCodeRepository(pool, &config.Settings))
+	adjustmentService := service.NewBalanceAdjustmentService(repositories.NewBalanceAdjustmentRepository(pool))
+	reconciliationService := service.NewReconciliationService(repositories.NewReconciliationRepository(pool))
+	pointsExpirationService := service.NewPointsExpirationService(repositories.NewPointsLotRepository(pool))
+	withdrawalPolicies, err := service.NewWithdrawalPoliciesFromConfig(
+		repositories.NewUserRepository(pool), &config.Settings)
+	if err != nil {
+		return nil, err
+	}
+	withdrawalService := service.NewWithdrawalService(repositories.NewWithdrawalRepository(pool), withdrawalPolicies)
+	balanceHistoryService := service.NewBalanceHistoryService(repositories.NewBalanceHistoryRepository(pool))
+	statementService := service.NewStatementService(
+		repositories.NewUserRepository(pool), repositories.NewBalanceHistoryRepository(pool))
+	holdService := service.NewHoldService(
+		repositories.NewHoldRepository(pool), withdrawalPolicies, &config.Settings)
+	transferService, err := service.NewTransferService(repositories.NewTransferRepository(pool), &config.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionService := service.NewSessionService(
+		repositories.NewSessionRepository(pool, config.Settings.RefreshTokenTTL), config.Settings.SessionCacheTTL)
+	authMiddleware := middlewares.NewAuthMiddleware(sessionService)
+
+	idempotencyRepository := repositories.NewIdempotencyRepository(
+		pool, config.Settings.IdempotencyKeyTTL, config.Settings.IdempotencyKeyLease)
+	idempotencyService := service.NewIdempotencyService(idempotencyRepository)
+	idempotencyMiddleware := middlewares.NewIdempotencyMiddleware(idempotencyRepository)
 
 	var registerHandler = handlers.NewRegisterHandler(userService)
 	var loginHandler = handlers.NewLoginHandler(userService)
-	var userBalancesHandler = handlers.NewUserBalancesHandler(userService)
-	var registerOrderHandler = handlers.NewRegisterOrderHandler(orderService)
+	var refreshTokenHandler = handlers.NewRefreshTokenHandler(sessionService)
+	var logoutHandler = handlers.NewLogoutHandler(sessionService)
+	var userBalancesHandler = handlers.NewUserBalancesHandler(userService, loyaltyService)
+	var balanceHistoryHandler = handlers.NewBalanceHistoryHandler(balanceHistoryService, walletChecker)
+	var statementHandler = handlers.NewStatementHandler(statementService, walletChecker)
+	var referralStatsHandler = handlers.NewReferralStatsHandler(referralService)
+	var registerOrderHandler = handlers.NewRegisterOrderHandler(orderService, orderNumberChecker, walletChecker)
 	var readAllOrdersHandler = handlers.NewReadAllOrdersHandler(orderService)
-	var createWithdrawalHandler = handlers.NewCreateWithdrawalHandler(withdrawalService)
+	var cancelOrderHandler = handlers.NewCancelOrderHandler(orderService, orderNumberChecker)
+	var createWithdrawalHandler = handlers.NewCreateWithdrawalHandler(
+		withdrawalService, orderNumberChecker, walletChecker)
 	var readAllWithdrawalsHandler = handlers.NewReadAllWithdrawalsHandler(withdrawalService)
+	var withdrawalQuoteHandler = handlers.NewWithdrawalQuoteHandler(withdrawalService, walletChecker)
+	var createHoldHandler = handlers.NewCreateHoldHandler(holdService, orderNumberChecker, walletChecker)
+	var captureHoldHandler = handlers.NewCaptureHoldHandler(holdService)
+	var voidHoldHandler = handlers.NewVoidHoldHandler(holdService)
+	var createTransferHandler = handlers.NewCreateTransferHandler(transferService)
+	var readAllTransfersHandler = handlers.NewReadAllTransfersHandler(transferService)
+	var reconciliationHandler = handlers.NewReconciliationHandler(reconciliationService)
+	var reverseWithdrawalHandler = handlers.NewReverseWithdrawalHandler(withdrawalService, orderNumberChecker)
+	var createCampaignHandler = handlers.NewCreateCampaignHandler(campaignService)
+	var readAllCampaignsHandler = handlers.NewReadAllCampaignsHandler(campaignService)
+	var deactivateCampaignHandler = handlers.NewDeactivateCampaignHandler(campaignService)
+	var createPromoCodeHandler = handlers.NewCreatePromoCodeHandler(promoCodeService)
+	var readAllPromoCodesHandler = handlers.NewReadAllPromoCodesHandler(promoCodeService)
+	var redeemPromoCodeHandler = handlers.NewRedeemPromoCodeHandler(promoCodeService)
+	var createAdjustmentHandler = handlers.NewCreateAdjustmentHandler(adjustmentService)
+	var readAllAdjustmentsHandler = handlers.NewReadAllAdjustmentsHandler(adjustmentService)
 
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
@@ -45,17 +116,49 @@ func GophermartBonusRouter(pool *sql.DB) chi.Router {
 	router.Route("/api/user", func(r chi.Router) {
 
 		noAuthGroup := r.Group(nil)
-		noAuthGroup.Use(middlewares.SetAuthMiddleware)
+		noAuthGroup.Use(middlewares.NewSetAuthMiddleware(sessionService))
 		noAuthGroup.Post("/register", registerHandler.ServeHTTP)
 		noAuthGroup.Post("/login", loginHandler.ServeHTTP)
+		noAuthGroup.Post("/token/refresh", refreshTokenHandler.ServeHTTP)
+		// Выход проверяет refresh- или access-токен сам, чтобы работать и с истёкшим access-токеном
+		r.Post("/logout", logoutHandler.ServeHTTP)
 
 		authGroup := r.Group(nil)
-		authGroup.Use(middlewares.AuthMiddleware)
+		authGroup.Use(authMiddleware)
 		authGroup.Get("/balance", userBalancesHandler.ServeHTTP)
-		authGroup.Post("/orders", registerOrderHandler.ServeHTTP)
+		authGroup.Get("/balance/history", balanceHistoryHandler.ServeHTTP)
+		authGroup.Get("/statements", statementHandler.ServeHTTP)
 		authGroup.Get("/orders", readAllOrdersHandler.ServeHTTP)
-		authGroup.Post("/balance/withdraw", createWithdrawalHandler.ServeHTTP)
 		authGroup.Get("/withdrawals", readAllWithdrawalsHandler.ServeHTTP)
+		authGroup.Get("/balance/withdraw/quote", withdrawalQuoteHandler.ServeHTTP)
+		authGroup.Get("/balance/transfers", readAllTransfersHandler.ServeHTTP)
+		authGroup.Get("/referrals", referralStatsHandler.ServeHTTP)
+
+		// Изменяющие запросы принимают Idempotency-Key, новые мутации регистрируются здесь же
+		mutatingGroup := authGroup.With(idempotencyMiddleware)
+		mutatingGroup.Post("/orders", registerOrderHandler.ServeHTTP)
+		mutatingGroup.Delete("/orders/{number}", cancelOrderHandler.ServeHTTP)
+		mutatingGroup.Post("/balance/withdraw", createWithdrawalHandler.ServeHTTP)
+		mutatingGroup.Post("/balance/holds", createHoldHandler.ServeHTTP)
+		mutatingGroup.Post("/balance/holds/{id}/capture", captureHoldHandler.ServeHTTP)
+		mutatingGroup.Post("/balance/holds/{id}/void", voidHoldHandler.ServeHTTP)
+		mutatingGroup.Post("/balance/transfer", createTransferHandler.ServeHTTP)
+		mutatingGroup.Post("/promo/redeem", redeemPromoCodeHandler.ServeHTTP)
+	})
+
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Use(authMiddleware)
+		r.Use(middlewares.NewAdminMiddleware(userService))
+		r.Get("/reconciliation", reconciliationHandler.ServeHTTP)
+		r.Post("/reconciliation", reconciliationHandler.ServeHTTP)
+		r.With(idempotencyMiddleware).Post("/withdrawals/{number}/reversals", reverseWithdrawalHandler.ServeHTTP)
+		r.Get("/campaigns", readAllCampaignsHandler.ServeHTTP)
+		r.With(idempotencyMiddleware).Post("/campaigns", createCampaignHandler.ServeHTTP)
+		r.With(idempotencyMiddleware).Delete("/campaigns/{id}", deactivateCampaignHandler.ServeHTTP)
+		r.Get("/promo-codes", readAllPromoCodesHandler.ServeHTTP)
+		r.With(idempotencyMiddleware).Post("/promo-codes", createPromoCodeHandler.ServeHTTP)
+		r.Get("/users/{id}/adjustments", readAllAdjustmentsHandler.ServeHTTP)
+		r.With(idempotencyMiddleware).Post("/users/{id}/adjustments", createAdjustmentHandler.ServeHTTP)
 	})
 	go func() {
 		err := orderService.WorkerLoop(context.Background())
@@ -63,18 +166,32 @@ func GophermartBonusRouter(pool *sql.DB) chi.Router {
 			logger.Log.Errorf("Error in orderService.WorkerLoop: %v", err)
 		}
 	}()
-	return router
+	if config.Settings.PointsTTLMonths > 0 && config.Settings.PointsExpirationCheckPeriod > 0 {
+		go pointsExpirationService.ExpireLoop(context.Background(), config.Settings.PointsExpirationCheckPeriod)
+	}
+	if config.Settings.HoldExpirationCheckPeriod > 0 {
+		go holdService.ExpireLoop(context.Background(), config.Settings.HoldExpirationCheckPeriod)
+	}
+	if config.Settings.IdempotencyCleanupPeriod > 0 {
+		go idempotencyService.CleanupLoop(context.Background(), config.Settings.IdempotencyCleanupPeriod)
+	}
+	if config.Settings.SessionCleanupPeriod > 0 {
+		go sessionService.CleanupLoop(context.Background(), config.Settings.SessionCleanupPeriod)
+	}
+	if config.Settings.LoyaltyTierRecalculationPeriod > 0 {
+		go loyaltyService.RecalculateLoop(context.Background(), config.Settings.LoyaltyTierRecalculationPeriod)
+	}
+	if config.Settings.ReconciliationPeriod > 0 {
+		go reconciliationService.ReconcileLoop(
+			context.Background(), config.Settings.ReconciliationPeriod, config.Settings.ReconciliationRepair)
+	}
+	return router, nil
 }
 
 func Run(addr string) error {
 	logger.Log.Infof("Initiating server at %s", addr)
-	if config.Settings.DatabaseURI == "" {
-		logger.Log.Fatal("no Database URI provided")
-		os.Exit(1)
-	}
-
 	var err error
-	Pool, err = sql.Open("pgx", config.Settings.DatabaseURI)
+	Pool, err = OpenDB()
 	if err != nil {
 		return err
 	}
@@ -84,20 +201,38 @@ func Run(addr string) error {
 			logger.Log.Errorf("error closing pool: %v", innerErr)
 		}
 	}(Pool)
+	logger.Log.Info("Server initiation completed, starting to serve")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	if err = Pool.PingContext(ctx); err != nil {
+	router, err := GophermartBonusRouter(Pool)
+	if err != nil {
 		return err
 	}
+	return http.ListenAndServe(addr, router)
+}
+
+// OpenDB подключается к базе из настроек и применяет миграции.
+func OpenDB() (*sql.DB, error) {
+	if config.Settings.DatabaseURI == "" {
+		logger.Log.Fatal("no Database URI provided")
+		os.Exit(1)
+	}
 
-	err = migrateDB(Pool)
+	pool, err := sql.Open("pgx", config.Settings.DatabaseURI)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	logger.Log.Info("Server initiation completed, starting to serve")
 
-	return http.ListenAndServe(addr, GophermartBonusRouter(Pool))
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err = pool.PingContext(ctx); err != nil {
+		return nil, errors.Join(err, pool.Close())
+	}
+
+	err = migrateDB(pool)
+	if err != nil {
+		return nil, errors.Join(err, pool.Close())
+	}
+	return pool, nil
 }
 
 func migrateDB(pool *sql.DB) error {
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"slices"
+	"strings"
+)
+
+type BalanceAdjustmentServiceInterface interface {
+	Create(ctx context.Context, adjustment repositories.BalanceAdjustment) (repositories.BalanceAdjustment, error)
+	ReadAllByUserID(ctx context.Context, userID uint64) ([]repositories.BalanceAdjustment, error)
+}
+
+// Коды причин корректировок, код попадает в reference проводки и виден пользователю в истории
+const (
+	AdjustmentReasonGoodwill     = "goodwill"
+	AdjustmentReasonCorrection   = "correction"
+	AdjustmentReasonCompensation = "compensation"
+	AdjustmentReasonFraud        = "fraud"
+)
+
+var AdjustmentReasonCodes = []string{
+	AdjustmentReasonGoodwill,
+	AdjustmentReasonCorrection,
+	AdjustmentReasonCompensation,
+	AdjustmentReasonFraud,
+}
+
+var ErrUnknownAdjustmentReason = errors.New("unknown adjustment reason code")
+var ErrAdjustmentCommentRequired = errors.New("adjustment comment is required")
+var ErrZeroAdjustment = errors.New("adjustment amount must not be zero")
+
+type BalanceAdjustmentService struct {
+	adjustmentRepository repositories.BalanceAdjustmentRepositoryInterface
+}
+
+func NewBalanceAdjustmentService(
+	adjustmentRepository repositories.BalanceAdjustmentRepositoryInterface) *BalanceAdjustmentService {
+	return &BalanceAdjustmentService{adjustmentRepository: adjustmentRepository}
+}
+
+func (b BalanceAdjustmentService) Create(
+	ctx context.Context, adjustment repositories.BalanceAdjustment) (repositories.BalanceAdjustment, error) {
+	if adjustment.Amount.IsZero() {
+		return repositories.BalanceAdjustment{}, ErrZeroAdjustment
+	}
+	if !slices.Contains(AdjustmentReasonCodes, adjustment.ReasonCode) {
+		return repositories.BalanceAdjustment{}, ErrUnknownAdjustmentReason
+	}
+	adjustment.Comment = strings.TrimSpace(adjustment.Comment)
+	if adjustment.Comment == "" {
+		return repositories.BalanceAdjustment{}, ErrAdjustmentCommentRequired
+	}
+	return b.adjustmentRepository.Create(ctx, adjustment)
+}
+
+func (b BalanceAdjustmentService) ReadAllByUserID(
+	ctx context.Context, userID uint64) ([]repositories.BalanceAdjustment, error) {
+	return b.adjustmentRepository.ReadAllByUserID(ctx, userID)
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+)
+
+const (
+	DefaultBalanceHistoryLimit = 50
+	MaxBalanceHistoryLimit     = 500
+)
+
+type BalanceHistoryServiceInterface interface {
+	ReadHistory(
+		ctx context.Context,
+		userID uint64,
+		filter repositories.BalanceHistoryFilter) ([]repositories.BalanceHistoryEntry, uint64, error)
+}
+
+type BalanceHistoryService struct {
+	balanceHistoryRepository repositories.BalanceHistoryRepositoryInterface
+}
+
+func NewBalanceHistoryService(
+	balanceHistoryRepository repositories.BalanceHistoryRepositoryInterface) *BalanceHistoryService {
+	return &BalanceHistoryService{balanceHistoryRepository: balanceHistoryRepository}
+}
+
+func (b BalanceHistoryService) ReadHistory(
+	ctx context.Context,
+	userID uint64,
+	filter repositories.BalanceHistoryFilter) ([]repositories.BalanceHistoryEntry, uint64, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultBalanceHistoryLimit
+	}
+	if filter.Limit > MaxBalanceHistoryLimit {
+		filter.Limit = MaxBalanceHistoryLimit
+	}
+	return b.balanceHistoryRepository.ReadHistory(ctx, userID, filter)
+}
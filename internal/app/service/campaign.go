@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"math/big"
+	"strings"
+)
+
+type CampaignServiceInterface interface {
+	Create(ctx context.Context, campaign repositories.Campaign) (repositories.Campaign, error)
+	ReadAll(ctx context.Context) ([]repositories.Campaign, error)
+	Deactivate(ctx context.Context, campaignID uint64) error
+}
+
+var ErrInvalidCampaignPeriod = errors.New("campaign must end after it starts")
+var ErrInvalidCampaignReward = errors.New("campaign needs either a multiplier above 1 or a positive fixed bonus")
+
+type CampaignService struct {
+	campaignRepository repositories.CampaignRepositoryInterface
+}
+
+func NewCampaignService(campaignRepository repositories.CampaignRepositoryInterface) *CampaignService {
+	return &CampaignService{campaignRepository: campaignRepository}
+}
+
+func (c CampaignService) Create(
+	ctx context.Context, campaign repositories.Campaign) (repositories.Campaign, error) {
+	if !campaign.EndsAt.After(campaign.StartsAt) {
+		return repositories.Campaign{}, ErrInvalidCampaignPeriod
+	}
+	campaign.Multiplier = strings.TrimSpace(campaign.Multiplier)
+	hasMultiplier := campaign.Multiplier != ""
+	if hasMultiplier == campaign.FixedBonus.Valid {
+		return repositories.Campaign{}, ErrInvalidCampaignReward
+	}
+	if hasMultiplier {
+		multiplier, ok := new(big.Rat).SetString(campaign.Multiplier)
+		if !ok || multiplier.Cmp(big.NewRat(1, 1)) <= 0 {
+			return repositories.Campaign{}, ErrInvalidCampaignReward
+		}
+	} else if !campaign.FixedBonus.Amount.IsPositive() {
+		return repositories.Campaign{}, ErrInvalidCampaignReward
+	}
+	return c.campaignRepository.Create(ctx, campaign)
+}
+
+func (c CampaignService) ReadAll(ctx context.Context) ([]repositories.Campaign, error) {
+	return c.campaignRepository.ReadAll(ctx)
+}
+
+func (c CampaignService) Deactivate(ctx context.Context, campaignID uint64) error {
+	return c.campaignRepository.Deactivate(ctx, campaignID)
+}
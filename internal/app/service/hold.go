@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"time"
+)
+
+type HoldServiceInterface interface {
+	Create(
+		ctx context.Context,
+		number string,
+		amount money.Amount,
+		orderTotal money.NullAmount,
+		wallet string,
+		userID uint64) (repositories.Hold, error)
+	Capture(ctx context.Context, holdID uint64, userID uint64) (repositories.Hold, error)
+	Void(ctx context.Context, holdID uint64, userID uint64) (repositories.Hold, error)
+}
+
+type HoldService struct {
+	holdRepository repositories.HoldRepositoryInterface
+	policies       *WithdrawalPolicies
+	ttl            time.Duration
+}
+
+func NewHoldService(
+	holdRepository repositories.HoldRepositoryInterface,
+	policies *WithdrawalPolicies,
+	settings *config.Config) *HoldService {
+	return &HoldService{
+		holdRepository: holdRepository,
+		policies:       policies,
+		ttl:            settings.HoldTTL,
+	}
+}
+
+// Create резервирует баллы по тем же правилам, что и списание: резерв потом захватывается без повторной проверки.
+func (h HoldService) Create(
+	ctx context.Context,
+	number string,
+	amount money.Amount,
+	orderTotal money.NullAmount,
+	wallet string,
+	userID uint64) (repositories.Hold, error) {
+	policy, err := h.policies.For(ctx, userID)
+	if err != nil {
+		return repositories.Hold{}, err
+	}
+	if err = policy.Check(amount, orderTotal); err != nil {
+		return repositories.Hold{}, err
+	}
+	hold, err := h.holdRepository.Create(ctx, number, amount, wallet, userID, h.ttl, policy.Caps())
+	if err != nil {
+		return repositories.Hold{}, policy.CapViolation(err)
+	}
+	return hold, nil
+}
+
+func (h HoldService) Capture(ctx context.Context, holdID uint64, userID uint64) (repositories.Hold, error) {
+	return h.holdRepository.Capture(ctx, holdID, userID)
+}
+
+func (h HoldService) Void(ctx context.Context, holdID uint64, userID uint64) (repositories.Hold, error) {
+	return h.holdRepository.Void(ctx, holdID, userID)
+}
+
+// ExpireLoop периодически переводит просроченные резервы в статус EXPIRED.
+// На доступный остаток это не влияет: просроченный резерв перестаёт учитываться сразу по expires_at.
+func (h HoldService) ExpireLoop(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := h.holdRepository.ExpireStale(ctx)
+			if err != nil {
+				logger.Log.Warnf("Scheduled holds expiration failed: %v", err)
+				continue
+			}
+			if expired > 0 {
+				logger.Log.Infof("Scheduled holds expiration finished, %d holds expired", expired)
+			}
+		}
+	}
+}
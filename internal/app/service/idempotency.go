@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"time"
+)
+
+type IdempotencyService struct {
+	idempotencyRepository repositories.IdempotencyRepositoryInterface
+}
+
+func NewIdempotencyService(idempotencyRepository repositories.IdempotencyRepositoryInterface) *IdempotencyService {
+	return &IdempotencyService{idempotencyRepository: idempotencyRepository}
+}
+
+// CleanupLoop периодически удаляет ключи идемпотентности старше срока хранения.
+func (i IdempotencyService) CleanupLoop(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := i.idempotencyRepository.DeleteExpired(ctx)
+			if err != nil {
+				logger.Log.Warnf("Scheduled idempotency keys cleanup failed: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				logger.Log.Infof("Scheduled idempotency keys cleanup finished, %d keys deleted", deleted)
+			}
+		}
+	}
+}
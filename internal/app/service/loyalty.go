@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const BaseLoyaltyTier = "standard"
+
+type LoyaltyTier struct {
+	Name       string
+	Threshold  money.Amount
+	Multiplier string
+}
+
+// TierProgress — текущий уровень пользователя и сколько осталось начислить до следующего.
+type TierProgress struct {
+	Tier          LoyaltyTier
+	AccruedTotal  money.Amount
+	WindowMonths  int
+	NextTier      *LoyaltyTier
+	RemainingToGo money.Amount
+}
+
+type AccrualMultiplier interface {
+	MultiplierFor(ctx context.Context, userID uint64) (string, error)
+}
+
+type LoyaltyServiceInterface interface {
+	AccrualMultiplier
+	GetProgress(ctx context.Context, userID uint64) (TierProgress, error)
+	Recalculate(ctx context.Context) (int, error)
+}
+
+type LoyaltyService struct {
+	loyaltyTierRepository repositories.LoyaltyTierRepositoryInterface
+	tierReader            TierReader
+	tiers                 []LoyaltyTier
+	windowMonths          int
+}
+
+func NewLoyaltyServiceFromConfig(
+	loyaltyTierRepository repositories.LoyaltyTierRepositoryInterface,
+	tierReader TierReader,
+	settings *config.Config) (*LoyaltyService, error) {
+	tiers, err := parseLoyaltyTiers(settings.LoyaltyTiers)
+	if err != nil {
+		return nil, err
+	}
+	return &LoyaltyService{
+		loyaltyTierRepository: loyaltyTierRepository,
+		tierReader:            tierReader,
+		tiers:                 tiers,
+		windowMonths:          settings.LoyaltyTierWindowMonths,
+	}, nil
+}
+
+// parseLoyaltyTiers разбирает описания вида silver:1000:1.1 и добавляет базовый уровень с нулевым порогом.
+func parseLoyaltyTiers(specs []string) ([]LoyaltyTier, error) {
+	tiers := []LoyaltyTier{{Name: BaseLoyaltyTier, Multiplier: "1"}}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid loyalty tier %q, expected name:threshold:multiplier", spec)
+		}
+		threshold, err := money.Parse(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold of loyalty tier %q: %w", parts[0], err)
+		}
+		if multiplier, ok := new(big.Rat).SetString(parts[2]); !ok || multiplier.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid multiplier of loyalty tier %q", parts[0])
+		}
+		previous := tiers[len(tiers)-1]
+		if threshold.Cmp(previous.Threshold) <= 0 {
+			return nil, fmt.Errorf("loyalty tier %q threshold must be above the one of %q", parts[0], previous.Name)
+		}
+		tiers = append(tiers, LoyaltyTier{Name: parts[0], Threshold: threshold, Multiplier: parts[2]})
+	}
+	return tiers, nil
+}
+
+// tierFor возвращает индекс наивысшего уровня, порог которого достигнут.
+func (l LoyaltyService) tierFor(total money.Amount) int {
+	index := 0
+	for candidate, tier := range l.tiers {
+		if total.Cmp(tier.Threshold) >= 0 {
+			index = candidate
+		}
+	}
+	return index
+}
+
+func (l LoyaltyService) tierByName(name string) (LoyaltyTier, bool) {
+	for _, tier := range l.tiers {
+		if tier.Name == name {
+			return tier, true
+		}
+	}
+	return LoyaltyTier{}, false
+}
+
+// MultiplierFor возвращает множитель текущего уровня. Уровень, убранный из настроек, считается базовым.
+func (l LoyaltyService) MultiplierFor(ctx context.Context, userID uint64) (string, error) {
+	name, err := l.tierReader.ReadTier(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	tier, ok := l.tierByName(name)
+	if !ok {
+		return l.tiers[0].Multiplier, nil
+	}
+	return tier.Multiplier, nil
+}
+
+func (l LoyaltyService) GetProgress(ctx context.Context, userID uint64) (TierProgress, error) {
+	total, err := l.loyaltyTierRepository.ReadAccruedTotal(ctx, userID, l.windowMonths)
+	if err != nil {
+		return TierProgress{}, err
+	}
+	tier, ok := l.tierByName(total.Tier)
+	if !ok {
+		tier = l.tiers[0]
+	}
+	progress := TierProgress{Tier: tier, AccruedTotal: total.Total, WindowMonths: l.windowMonths}
+	// Следующий уровень считается от заработанного, а не от сохранённого уровня, который обновляется по расписанию
+	if next := l.tierFor(total.Total) + 1; next < len(l.tiers) {
+		progress.NextTier = &l.tiers[next]
+		progress.RemainingToGo, err = l.tiers[next].Threshold.Sub(total.Total)
+		if err != nil {
+			return TierProgress{}, err
+		}
+	}
+	return progress, nil
+}
+
+// Recalculate пересчитывает уровни всех пользователей и возвращает число изменившихся.
+func (l LoyaltyService) Recalculate(ctx context.Context) (int, error) {
+	totals, err := l.loyaltyTierRepository.ReadAccruedTotals(ctx, l.windowMonths)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, total := range totals {
+		tier := l.tiers[l.tierFor(total.Total)]
+		if tier.Name == total.Tier {
+			continue
+		}
+		if err = l.loyaltyTierRepository.UpdateTier(ctx, total.UserID, tier.Name); err != nil {
+			return changed, err
+		}
+		logger.Log.Infof("User %d moved from tier %s to %s", total.UserID, total.Tier, tier.Name)
+		changed++
+	}
+	return changed, nil
+}
+
+// RecalculateLoop пересчитывает уровни сразу при старте, чтобы частые перезапуски не откладывали пересчёт,
+// а затем каждый period.
+func (l LoyaltyService) RecalculateLoop(ctx context.Context, period time.Duration) {
+	l.runScheduledRecalculation(ctx)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.runScheduledRecalculation(ctx)
+		}
+	}
+}
+
+func (l LoyaltyService) runScheduledRecalculation(ctx context.Context) {
+	changed, err := l.Recalculate(ctx)
+	if err != nil {
+		logger.Log.Warnf("Scheduled loyalty tiers recalculation failed: %v", err)
+		return
+	}
+	logger.Log.Infof("Scheduled loyalty tiers recalculation finished, %d users changed tier", changed)
+}
@@ -10,39 +10,62 @@ import (
 )
 
 type OrderServiceInterface interface {
-	Create(ctx context.Context, number string, userID uint64) (uint64, error)
+	Create(ctx context.Context, number string, wallet string, userID uint64) (uint64, error)
 	ReadAllByUserID(ctx context.Context, userID uint64) ([]repositories.OrderWithAccrual, error)
+	GetListVersion(ctx context.Context, userID uint64) (repositories.ListVersion, error)
+	StreamAllByUserID(ctx context.Context, userID uint64, consume func(order repositories.OrderWithAccrual) error) error
 	GetOrdersForProcessing(ctx context.Context) ([]repositories.Order, error)
 	UpdateOrderStatus(ctx context.Context, order repositories.Order) error
+	Cancel(ctx context.Context, number string, userID uint64) error
 }
 
 var ErrOrderAlreadyRegisteredByCurrentUser = errors.New("order already registered by current user")
+var ErrOrderRegisteredWithOtherWallet = errors.New("order already registered by current user for another wallet")
 
 type OrderService struct {
 	orderRepository   repositories.OrderRepositoryInterface
 	accrualRepository repositories.AccrualRepositoryInterface
+	orderRules        OrderRulesInterface
+	accrualMultiplier AccrualMultiplier
 }
 
 func NewOrderService(
 	orderRepository repositories.OrderRepositoryInterface,
-	accrualRepository repositories.AccrualRepositoryInterface) *OrderService {
+	accrualRepository repositories.AccrualRepositoryInterface,
+	orderRules OrderRulesInterface,
+	accrualMultiplier AccrualMultiplier) *OrderService {
 	return &OrderService{
 		orderRepository:   orderRepository,
 		accrualRepository: accrualRepository,
+		orderRules:        orderRules,
+		accrualMultiplier: accrualMultiplier,
 	}
 }
 
-func (o OrderService) Create(ctx context.Context, number string, userID uint64) (uint64, error) {
-	order, err := o.orderRepository.Create(ctx, number, userID)
+func (o OrderService) Create(ctx context.Context, number string, wallet string, userID uint64) (uint64, error) {
+	order, err := o.orderRepository.Create(ctx, number, wallet, userID, o.orderRules.Check)
 	if err != nil {
-		if errors.Is(err, repositories.ErrOrderAlreadyExists) {
+		switch {
+		case errors.Is(err, ErrOrderQuotaExceeded):
+			o.orderRules.RecordAttempt(ctx, userID, number, repositories.UploadOutcomeRejected)
+			return 0, err
+		case errors.Is(err, repositories.ErrOrderAlreadyExists):
 			existingOrder, innerErr := o.orderRepository.Read(ctx, number)
 			if innerErr != nil {
 				return 0, innerErr
 			}
 			if existingOrder.UserID != userID {
+				o.orderRules.RecordAttempt(ctx, userID, number, repositories.UploadOutcomeDuplicateOther)
 				return 0, err
 			} else {
+				o.orderRules.RecordAttempt(ctx, userID, number, repositories.UploadOutcomeDuplicateOwn)
+				// Повторная загрузка не меняет кошелёк заказа, поэтому другой кошелёк — конфликт, а не повтор
+				if wallet == "" {
+					wallet = repositories.DefaultWallet
+				}
+				if existingOrder.Wallet != wallet {
+					return 0, ErrOrderRegisteredWithOtherWallet
+				}
 				return 0, ErrOrderAlreadyRegisteredByCurrentUser
 			}
 		}
@@ -59,6 +82,24 @@ func (o OrderService) ReadAllByUserID(ctx context.Context, userID uint64) ([]rep
 	return orders, nil
 }
 
+func (o OrderService) StreamAllByUserID(
+	ctx context.Context, userID uint64, consume func(order repositories.OrderWithAccrual) error) error {
+	return o.orderRepository.StreamAllByUserID(ctx, userID, consume)
+}
+
+func (o OrderService) GetListVersion(ctx context.Context, userID uint64) (repositories.ListVersion, error) {
+	return o.orderRepository.GetListVersion(ctx, userID)
+}
+
+func (o OrderService) Cancel(ctx context.Context, number string, userID uint64) error {
+	err := o.orderRepository.Cancel(ctx, number, userID)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("Order %s cancelled by user %d", number, userID)
+	return nil
+}
+
 func (o OrderService) GetOrdersForProcessing(ctx context.Context) ([]repositories.Order, error) {
 	orders, err := o.orderRepository.ReadByStatus(ctx, "NEW")
 	if err != nil {
@@ -100,8 +141,18 @@ func (o OrderService) UpdateOrderStatus(ctx context.Context, order repositories.
 		}
 		return nil
 	case repositories.ExternalOrderStatusProcessed:
+		multiplier, multiplierErr := o.multiplierFor(ctx, order)
+		if multiplierErr != nil {
+			logger.Log.Warnf("Failed to get accrual multiplier of user %d: %v", order.UserID, multiplierErr)
+			innerErr := o.orderRepository.UpdateOrderStatus(ctx, order.ID, repositories.OrderStatusNew)
+			if innerErr != nil {
+				logger.Log.Error("Error returning order to NEW: %v", innerErr)
+				return innerErr
+			}
+			return multiplierErr
+		}
 		err = o.orderRepository.UpdateOrderAndPasteAccrual(
-			ctx, order, repositories.OrderStatusProcessed, orderState.Accrual)
+			ctx, order, repositories.OrderStatusProcessed, orderState.Accrual, multiplier)
 		if err != nil {
 			logger.Log.Warnf("Failed to update order %s with PROCESSED status: %v", order.Number, err)
 			innerErr := o.orderRepository.UpdateOrderStatus(ctx, order.ID, repositories.OrderStatusNew)
@@ -135,6 +186,15 @@ func (o OrderService) UpdateOrderStatus(ctx context.Context, order repositories.
 	return nil
 }
 
+// multiplierFor возвращает множитель уровня лояльности. Уровень зарабатывается и действует только
+// в основном кошельке, партнёрские баллы начисляются как есть.
+func (o OrderService) multiplierFor(ctx context.Context, order repositories.Order) (string, error) {
+	if order.Wallet != "" && order.Wallet != repositories.DefaultWallet {
+		return "1", nil
+	}
+	return o.accrualMultiplier.MultiplierFor(ctx, order.UserID)
+}
+
 func (o OrderService) WorkerLoop(ctx context.Context) error {
 	ordersChannel := make(chan repositories.Order, config.Settings.DefaultChannelsBufferSize)
 	errorsChannel := make(chan error)
@@ -157,10 +217,14 @@ func (o OrderService) WorkerLoop(ctx context.Context) error {
 				return err
 			}
 			for _, order := range orders {
-				err = o.orderRepository.UpdateOrderStatus(ctx, order.ID, repositories.OrderStatusProcessing)
-				if err != nil {
-					logger.Log.Warnf("Failed to update order with PROCESSING status: %v", err)
-					return err
+				claimed, claimErr := o.orderRepository.ClaimForProcessing(ctx, order.ID)
+				if claimErr != nil {
+					logger.Log.Warnf("Failed to update order with PROCESSING status: %v", claimErr)
+					return claimErr
+				}
+				if !claimed {
+					logger.Log.Debugf("Order %s was cancelled or claimed concurrently, skipping", order.Number)
+					continue
 				}
 				ordersChannel <- order
 			}
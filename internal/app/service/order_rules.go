@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+)
+
+const (
+	OrderRuleActionReject = "reject"
+	OrderRuleActionFlag   = "flag"
+)
+
+const (
+	orderRuleMaxPerHour           = "max_orders_per_hour"
+	orderRuleMaxPerDay            = "max_orders_per_day"
+	orderRuleMaxInvalidShare      = "max_invalid_share"
+	orderRuleMaxDuplicateAttempts = "max_duplicate_attempts_per_day"
+)
+
+var ErrOrderQuotaExceeded = errors.New("order upload rules exceeded")
+
+type OrderRulesInterface interface {
+	Check(ctx context.Context, transaction *sql.Tx, userID uint64) error
+	RecordAttempt(ctx context.Context, userID uint64, number string, outcome string)
+}
+
+type OrderRules struct {
+	orderRuleRepository repositories.OrderRuleRepositoryInterface
+	config              *config.Config
+}
+
+func NewOrderRules(orderRuleRepository repositories.OrderRuleRepositoryInterface, config *config.Config) *OrderRules {
+	return &OrderRules{
+		orderRuleRepository: orderRuleRepository,
+		config:              config,
+	}
+}
+
+// Check проверяет, может ли пользователь загрузить ещё один заказ. Нулевой порог выключает правило.
+// Вызывается как OrderUploadGuard в транзакции загрузки заказа.
+func (o OrderRules) Check(ctx context.Context, transaction *sql.Tx, userID uint64) error {
+	stats, err := o.orderRuleRepository.GetUploadStats(ctx, transaction, userID)
+	if err != nil {
+		return err
+	}
+	var hits []repositories.RuleHit
+	if o.config.OrderRulesMaxPerHour > 0 && stats.AcceptedLastHour >= uint64(o.config.OrderRulesMaxPerHour) {
+		hits = append(hits, repositories.RuleHit{
+			Rule:      orderRuleMaxPerHour,
+			Value:     float64(stats.AcceptedLastHour),
+			Threshold: float64(o.config.OrderRulesMaxPerHour),
+		})
+	}
+	if o.config.OrderRulesMaxPerDay > 0 && stats.AcceptedLastDay >= uint64(o.config.OrderRulesMaxPerDay) {
+		hits = append(hits, repositories.RuleHit{
+			Rule:      orderRuleMaxPerDay,
+			Value:     float64(stats.AcceptedLastDay),
+			Threshold: float64(o.config.OrderRulesMaxPerDay),
+		})
+	}
+	if o.config.OrderRulesMaxInvalidShare > 0 &&
+		stats.FinishedOrders > 0 &&
+		stats.FinishedOrders >= uint64(o.config.OrderRulesInvalidShareMinOrders) {
+		invalidShare := float64(stats.InvalidOrders) / float64(stats.FinishedOrders)
+		if invalidShare > o.config.OrderRulesMaxInvalidShare {
+			hits = append(hits, repositories.RuleHit{
+				Rule:      orderRuleMaxInvalidShare,
+				Value:     invalidShare,
+				Threshold: o.config.OrderRulesMaxInvalidShare,
+			})
+		}
+	}
+	if o.config.OrderRulesMaxDuplicateAttempts > 0 &&
+		stats.DuplicatesLastDay >= uint64(o.config.OrderRulesMaxDuplicateAttempts) {
+		hits = append(hits, repositories.RuleHit{
+			Rule:      orderRuleMaxDuplicateAttempts,
+			Value:     float64(stats.DuplicatesLastDay),
+			Threshold: float64(o.config.OrderRulesMaxDuplicateAttempts),
+		})
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+
+	action := o.config.OrderRulesAction
+	if action != OrderRuleActionFlag {
+		action = OrderRuleActionReject
+	}
+	for _, hit := range hits {
+		hit.UserID = userID
+		hit.Action = action
+		logger.Log.Warnw("Order upload rule hit",
+			"user_id", userID,
+			"rule", hit.Rule,
+			"value", hit.Value,
+			"threshold", hit.Threshold,
+			"action", action,
+		)
+		if innerErr := o.orderRuleRepository.RecordHit(ctx, hit); innerErr != nil {
+			logger.Log.Warnf("Failed to record rule hit for user %d: %v", userID, innerErr)
+		}
+	}
+	if action == OrderRuleActionFlag {
+		return o.orderRuleRepository.FlagUser(ctx, transaction, userID)
+	}
+	return ErrOrderQuotaExceeded
+}
+
+func (o OrderRules) RecordAttempt(ctx context.Context, userID uint64, number string, outcome string) {
+	err := o.orderRuleRepository.RecordAttempt(ctx, userID, number, outcome)
+	if err != nil {
+		logger.Log.Warnf("Failed to record upload attempt of user %d: %v", userID, err)
+	}
+}
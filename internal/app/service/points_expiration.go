@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"time"
+)
+
+const expiredLotsBatchSize = 500
+
+type PointsExpirationService struct {
+	pointsLotRepository repositories.PointsLotRepositoryInterface
+}
+
+func NewPointsExpirationService(
+	pointsLotRepository repositories.PointsLotRepositoryInterface) *PointsExpirationService {
+	return &PointsExpirationService{pointsLotRepository: pointsLotRepository}
+}
+
+// ExpirePoints проводит сгорание всех просроченных партий и возвращает их количество.
+func (p PointsExpirationService) ExpirePoints(ctx context.Context) (int, error) {
+	expired := 0
+	for {
+		lots, err := p.pointsLotRepository.ReadExpiredLots(ctx, expiredLotsBatchSize)
+		if err != nil {
+			return expired, err
+		}
+		if len(lots) == 0 {
+			return expired, nil
+		}
+		for _, lot := range lots {
+			amount, expireErr := p.pointsLotRepository.ExpireLot(ctx, lot.ID)
+			if expireErr != nil {
+				logger.Log.Warnf("Failed to expire points lot %d of user %d: %v", lot.ID, lot.UserID, expireErr)
+				return expired, expireErr
+			}
+			if amount.IsPositive() {
+				logger.Log.Infof("Expired %s points of user %d from lot %d", amount, lot.UserID, lot.ID)
+				expired++
+			}
+		}
+	}
+}
+
+// ExpireLoop сразу проводит сгорание, накопившееся пока сервис не работал, а затем повторяет его каждый period.
+func (p PointsExpirationService) ExpireLoop(ctx context.Context, period time.Duration) {
+	p.runScheduledExpiration(ctx)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.runScheduledExpiration(ctx)
+		}
+	}
+}
+
+func (p PointsExpirationService) runScheduledExpiration(ctx context.Context) {
+	expired, err := p.ExpirePoints(ctx)
+	if err != nil {
+		logger.Log.Warnf("Scheduled points expiration failed: %v", err)
+		return
+	}
+	logger.Log.Infof("Scheduled points expiration finished, %d lots expired", expired)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"strings"
+)
+
+type PromoCodeServiceInterface interface {
+	Create(ctx context.Context, promoCode repositories.PromoCode) (repositories.PromoCode, error)
+	ReadAll(ctx context.Context) ([]repositories.PromoCode, error)
+	Redeem(ctx context.Context, code string, userID uint64) (repositories.PromoRedemption, error)
+}
+
+var ErrInvalidPromoCode = errors.New("promo code needs a non-empty code, a positive value and a positive usage limit")
+
+type PromoCodeService struct {
+	promoCodeRepository repositories.PromoCodeRepositoryInterface
+}
+
+func NewPromoCodeService(promoCodeRepository repositories.PromoCodeRepositoryInterface) *PromoCodeService {
+	return &PromoCodeService{promoCodeRepository: promoCodeRepository}
+}
+
+// NormalizePromoCode приводит код к виду, в котором он хранится: без пробелов по краям и в верхнем регистре.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (p PromoCodeService) Create(
+	ctx context.Context, promoCode repositories.PromoCode) (repositories.PromoCode, error) {
+	promoCode.Code = NormalizePromoCode(promoCode.Code)
+	if promoCode.Code == "" || !promoCode.Amount.IsPositive() {
+		return repositories.PromoCode{}, ErrInvalidPromoCode
+	}
+	if promoCode.MaxUses.Valid && promoCode.MaxUses.Int64 <= 0 {
+		return repositories.PromoCode{}, ErrInvalidPromoCode
+	}
+	return p.promoCodeRepository.Create(ctx, promoCode)
+}
+
+func (p PromoCodeService) ReadAll(ctx context.Context) ([]repositories.PromoCode, error) {
+	return p.promoCodeRepository.ReadAll(ctx)
+}
+
+func (p PromoCodeService) Redeem(
+	ctx context.Context, code string, userID uint64) (repositories.PromoRedemption, error) {
+	return p.promoCodeRepository.Redeem(ctx, NormalizePromoCode(code), userID)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"time"
+)
+
+type ReconciliationResult struct {
+	Discrepancy repositories.BalanceDiscrepancy `json:"discrepancy"`
+	Repaired    bool                            `json:"repaired"`
+}
+
+type ReconciliationReport struct {
+	CheckedAt time.Time              `json:"checked_at"`
+	Results   []ReconciliationResult `json:"results"`
+}
+
+type ReconciliationServiceInterface interface {
+	Reconcile(ctx context.Context, repair bool) (ReconciliationReport, error)
+}
+
+type ReconciliationService struct {
+	reconciliationRepository repositories.ReconciliationRepositoryInterface
+}
+
+func NewReconciliationService(
+	reconciliationRepository repositories.ReconciliationRepositoryInterface) *ReconciliationService {
+	return &ReconciliationService{reconciliationRepository: reconciliationRepository}
+}
+
+func (r ReconciliationService) Reconcile(ctx context.Context, repair bool) (ReconciliationReport, error) {
+	report := ReconciliationReport{CheckedAt: time.Now()}
+	discrepancies, err := r.reconciliationRepository.FindDiscrepancies(ctx)
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	for _, discrepancy := range discrepancies {
+		logger.Log.Warnw("Balance discrepancy found",
+			"user_id", discrepancy.UserID,
+			"wallet", discrepancy.Wallet,
+			"stored_balance", discrepancy.StoredBalance.String(),
+			"ledger_balance", discrepancy.LedgerBalance.String(),
+			"expected_balance", discrepancy.ExpectedBalance.String(),
+			"stored_withdrawn", discrepancy.StoredWithdrawn.String(),
+			"ledger_withdrawn", discrepancy.LedgerWithdrawn.String(),
+		)
+		result := ReconciliationResult{Discrepancy: discrepancy}
+		if repair {
+			_, repairErr := r.reconciliationRepository.Repair(ctx, discrepancy.UserID, discrepancy.Wallet)
+			if repairErr != nil {
+				logger.Log.Errorf("Failed to repair %s balance of user %d: %v",
+					discrepancy.Wallet, discrepancy.UserID, repairErr)
+			} else {
+				logger.Log.Infof("%s balance of user %d repaired", discrepancy.Wallet, discrepancy.UserID)
+				result.Repaired = true
+			}
+		}
+		report.Results = append(report.Results, result)
+	}
+	logger.Log.Infof("Reconciliation finished, %d discrepancies found", len(report.Results))
+	return report, nil
+}
+
+func (r ReconciliationService) ReconcileLoop(ctx context.Context, period time.Duration, repair bool) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := r.Reconcile(ctx, repair)
+			if err != nil {
+				logger.Log.Warnf("Scheduled reconciliation failed: %v", err)
+			}
+		}
+	}
+}
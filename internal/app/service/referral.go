@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+)
+
+type ReferralServiceInterface interface {
+	GetStats(ctx context.Context, userID uint64) (repositories.ReferralStats, error)
+}
+
+type ReferralService struct {
+	referralRepository repositories.ReferralRepositoryInterface
+	bonuses            repositories.ReferralBonuses
+}
+
+func NewReferralService(
+	referralRepository repositories.ReferralRepositoryInterface, settings *config.Config) (*ReferralService, error) {
+	bonuses := repositories.ReferralBonuses{ReferrerDailyCap: settings.ReferralReferrerDailyCap}
+	for _, bonus := range []struct {
+		name  string
+		value string
+		dest  *money.Amount
+	}{
+		{"REFERRAL_REFERRER_BONUS", settings.ReferralReferrerBonus, &bonuses.Referrer},
+		{"REFERRAL_REFEREE_BONUS", settings.ReferralRefereeBonus, &bonuses.Referee},
+	} {
+		if bonus.value == "" {
+			continue
+		}
+		amount, err := money.Parse(bonus.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", bonus.name, err)
+		}
+		*bonus.dest = amount
+	}
+	return &ReferralService{referralRepository: referralRepository, bonuses: bonuses}, nil
+}
+
+func (r ReferralService) GetStats(ctx context.Context, userID uint64) (repositories.ReferralStats, error) {
+	return r.referralRepository.ReadStats(ctx, userID)
+}
+
+// ApplyToAccrual подключается к OrderRepository как AccrualHook.
+func (r ReferralService) ApplyToAccrual(
+	ctx context.Context, transaction *sql.Tx, accrual repositories.ProcessedAccrual) error {
+	return r.referralRepository.Reward(ctx, transaction, accrual, r.bonuses)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/logger"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SessionServiceInterface interface {
+	Start(ctx context.Context, userID uint64) (repositories.Session, string, error)
+	Refresh(ctx context.Context, refreshToken string) (repositories.Session, string, error)
+	Revoke(ctx context.Context, sessionID uint64, userID uint64) error
+	RevokeByRefreshToken(ctx context.Context, refreshToken string) (uint64, error)
+	RevokeAll(ctx context.Context, userID uint64) error
+	IsSessionActive(ctx context.Context, sessionID uint64) (bool, error)
+}
+
+var ErrInvalidRefreshToken = errors.New("malformed refresh token")
+
+const refreshTokenSecretLength = 32
+const refreshTokenDelimiter = "."
+
+type sessionState struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// SessionService выдаёт и обновляет refresh-токены. Проверка отзыва сессии кешируется на cacheTTL,
+// поэтому отзыв с другого экземпляра сервиса вступает в силу с задержкой не больше cacheTTL.
+type SessionService struct {
+	sessionRepository repositories.SessionRepositoryInterface
+	cacheTTL          time.Duration
+	mu                sync.Mutex
+	cache             map[uint64]sessionState
+}
+
+func NewSessionService(
+	sessionRepository repositories.SessionRepositoryInterface, cacheTTL time.Duration) *SessionService {
+	return &SessionService{
+		sessionRepository: sessionRepository,
+		cacheTTL:          cacheTTL,
+		cache:             make(map[uint64]sessionState),
+	}
+}
+
+// Start открывает новую сессию после входа и возвращает её вместе с refresh-токеном.
+func (s *SessionService) Start(ctx context.Context, userID uint64) (repositories.Session, string, error) {
+	secret, err := generateRefreshSecret()
+	if err != nil {
+		return repositories.Session{}, "", err
+	}
+	session, err := s.sessionRepository.Create(ctx, userID, hashRefreshSecret(secret))
+	if err != nil {
+		return repositories.Session{}, "", err
+	}
+	return session, formatRefreshToken(session.ID, secret), nil
+}
+
+// Refresh меняет refresh-токен на новый. Старый токен после этого недействителен.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (repositories.Session, string, error) {
+	sessionID, presentedSecret, err := parseRefreshToken(refreshToken)
+	if err != nil {
+		return repositories.Session{}, "", err
+	}
+	secret, err := generateRefreshSecret()
+	if err != nil {
+		return repositories.Session{}, "", err
+	}
+	session, err := s.sessionRepository.Rotate(
+		ctx, sessionID, hashRefreshSecret(presentedSecret), hashRefreshSecret(secret))
+	if err != nil {
+		if errors.Is(err, repositories.ErrRefreshTokenReused) {
+			s.forget(sessionID)
+		}
+		return repositories.Session{}, "", err
+	}
+	return session, formatRefreshToken(session.ID, secret), nil
+}
+
+func (s *SessionService) Revoke(ctx context.Context, sessionID uint64, userID uint64) error {
+	if err := s.sessionRepository.Revoke(ctx, sessionID, userID); err != nil {
+		return err
+	}
+	s.forget(sessionID)
+	return nil
+}
+
+// RevokeByRefreshToken завершает сессию, которой принадлежит refresh-токен, и возвращает её пользователя.
+func (s *SessionService) RevokeByRefreshToken(ctx context.Context, refreshToken string) (uint64, error) {
+	sessionID, secret, err := parseRefreshToken(refreshToken)
+	if err != nil {
+		return 0, err
+	}
+	userID, err := s.sessionRepository.RevokeByTokenHash(ctx, sessionID, hashRefreshSecret(secret))
+	if err != nil {
+		return 0, err
+	}
+	s.forget(sessionID)
+	return userID, nil
+}
+
+// RevokeAll завершает все сессии пользователя, например после смены пароля.
+func (s *SessionService) RevokeAll(ctx context.Context, userID uint64) error {
+	sessionIDs, err := s.sessionRepository.RevokeAllByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.forget(sessionIDs...)
+	logger.Log.Infof("Revoked %d sessions of user %d", len(sessionIDs), userID)
+	return nil
+}
+
+func (s *SessionService) IsSessionActive(ctx context.Context, sessionID uint64) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	state, ok := s.cache[sessionID]
+	s.mu.Unlock()
+	if ok && now.Sub(state.checkedAt) < s.cacheTTL {
+		return state.active, nil
+	}
+	active, err := s.sessionRepository.IsActive(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		s.cache[sessionID] = sessionState{active: active, checkedAt: now}
+		s.mu.Unlock()
+	}
+	return active, nil
+}
+
+// CleanupLoop периодически удаляет истёкшие и отозванные сессии и устаревшие записи кеша.
+func (s *SessionService) CleanupLoop(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.pruneCache()
+			deleted, err := s.sessionRepository.DeleteExpired(ctx)
+			if err != nil {
+				logger.Log.Warnf("Scheduled sessions cleanup failed: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				logger.Log.Infof("Scheduled sessions cleanup finished, %d sessions deleted", deleted)
+			}
+		}
+	}
+}
+
+// forget помечает сессии отозванными в локальном кеше, чтобы отзыв действовал сразу.
+func (s *SessionService) forget(sessionIDs ...uint64) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sessionID := range sessionIDs {
+		s.cache[sessionID] = sessionState{active: false, checkedAt: now}
+	}
+}
+
+func (s *SessionService) pruneCache() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sessionID, state := range s.cache {
+		if now.Sub(state.checkedAt) >= s.cacheTTL {
+			delete(s.cache, sessionID)
+		}
+	}
+}
+
+func generateRefreshSecret() (string, error) {
+	secret := make([]byte, refreshTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func formatRefreshToken(sessionID uint64, secret string) string {
+	return strconv.FormatUint(sessionID, 10) + refreshTokenDelimiter + secret
+}
+
+func parseRefreshToken(refreshToken string) (uint64, string, error) {
+	rawSessionID, secret, found := strings.Cut(refreshToken, refreshTokenDelimiter)
+	if !found || secret == "" {
+		return 0, "", ErrInvalidRefreshToken
+	}
+	sessionID, err := strconv.ParseUint(rawSessionID, 10, 64)
+	if err != nil || sessionID == 0 {
+		return 0, "", ErrInvalidRefreshToken
+	}
+	return sessionID, secret, nil
+}
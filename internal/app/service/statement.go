@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"io"
+	"time"
+)
+
+const (
+	StatementFormatJSON = "json"
+	StatementFormatCSV  = "csv"
+)
+
+var ErrInvalidStatementPeriod = errors.New("statement period end must be after its start")
+var ErrUnknownStatementFormat = errors.New("unknown statement format")
+
+// StatementLine — операция в выписке. Суммы списаний положительные, прочие операции со знаком.
+type StatementLine struct {
+	Kind      string       `json:"kind"`
+	Reference string       `json:"reference,omitempty"`
+	Amount    money.Amount `json:"sum"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Statement — выписка по одному кошельку за полуинтервал [From, To): Opening + Accrued - Withdrawn + Other = Closing.
+type Statement struct {
+	UserID         uint64          `json:"user_id"`
+	Login          string          `json:"login"`
+	Wallet         string          `json:"wallet"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance money.Amount    `json:"opening_balance"`
+	Accruals       []StatementLine `json:"accruals"`
+	Withdrawals    []StatementLine `json:"withdrawals"`
+	Other          []StatementLine `json:"other"`
+	TotalAccrued   money.Amount    `json:"total_accrued"`
+	TotalWithdrawn money.Amount    `json:"total_withdrawn"`
+	TotalOther     money.Amount    `json:"total_other"`
+	ClosingBalance money.Amount    `json:"closing_balance"`
+}
+
+type StatementServiceInterface interface {
+	Generate(ctx context.Context, userID uint64, wallet string, from time.Time, to time.Time) (Statement, error)
+	GenerateAll(
+		ctx context.Context,
+		wallets []string,
+		from time.Time,
+		to time.Time,
+		consume func(statement Statement) error) error
+}
+
+type StatementService struct {
+	userRepository           repositories.UserRepositoryInterface
+	balanceHistoryRepository repositories.BalanceHistoryRepositoryInterface
+}
+
+func NewStatementService(
+	userRepository repositories.UserRepositoryInterface,
+	balanceHistoryRepository repositories.BalanceHistoryRepositoryInterface) *StatementService {
+	return &StatementService{
+		userRepository:           userRepository,
+		balanceHistoryRepository: balanceHistoryRepository,
+	}
+}
+
+// Generate строит выписку по кошельку wallet, пустое имя означает основной кошелёк.
+func (s StatementService) Generate(
+	ctx context.Context, userID uint64, wallet string, from time.Time, to time.Time) (Statement, error) {
+	user, err := s.userRepository.ReadByID(ctx, userID)
+	if err != nil {
+		return Statement{}, err
+	}
+	return s.generate(ctx, user, wallet, from, to)
+}
+
+// GenerateAll строит выписки всех активных пользователей по каждому из кошельков по одной, не держа их все в памяти.
+// Выписка по основному кошельку строится всегда, по остальным — только если в них были баллы или операции.
+func (s StatementService) GenerateAll(
+	ctx context.Context,
+	wallets []string,
+	from time.Time,
+	to time.Time,
+	consume func(statement Statement) error) error {
+	users, err := s.userRepository.ReadAllActive(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		for _, wallet := range wallets {
+			statement, generateErr := s.generate(ctx, user, wallet, from, to)
+			if generateErr != nil {
+				return generateErr
+			}
+			if statement.Wallet != repositories.DefaultWallet && statement.isEmpty() {
+				continue
+			}
+			if err = consume(statement); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s StatementService) generate(
+	ctx context.Context, user repositories.User, wallet string, from time.Time, to time.Time) (Statement, error) {
+	if !to.After(from) {
+		return Statement{}, ErrInvalidStatementPeriod
+	}
+	if wallet == "" {
+		wallet = repositories.DefaultWallet
+	}
+	opening, err := s.balanceHistoryRepository.ReadBalanceAt(ctx, user.ID, wallet, from)
+	if err != nil {
+		return Statement{}, err
+	}
+	statement := Statement{
+		UserID:         user.ID,
+		Login:          user.Login,
+		Wallet:         wallet,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+		Accruals:       []StatementLine{},
+		Withdrawals:    []StatementLine{},
+		Other:          []StatementLine{},
+	}
+	err = s.balanceHistoryRepository.StreamPeriod(
+		ctx, user.ID, wallet, from, to, func(entry repositories.BalanceHistoryEntry) error {
+			line := StatementLine{
+				Kind:      entry.Kind,
+				Reference: entry.Reference,
+				Amount:    entry.Amount,
+				CreatedAt: entry.CreatedAt,
+			}
+			var lineErr error
+			switch entry.Kind {
+			case repositories.LedgerKindAccrual:
+				statement.Accruals = append(statement.Accruals, line)
+				statement.TotalAccrued, lineErr = statement.TotalAccrued.Add(line.Amount)
+			case repositories.LedgerKindWithdrawal:
+				if line.Amount, lineErr = line.Amount.Neg(); lineErr != nil {
+					return lineErr
+				}
+				statement.Withdrawals = append(statement.Withdrawals, line)
+				statement.TotalWithdrawn, lineErr = statement.TotalWithdrawn.Add(line.Amount)
+			default:
+				statement.Other = append(statement.Other, line)
+				statement.TotalOther, lineErr = statement.TotalOther.Add(line.Amount)
+			}
+			return lineErr
+		})
+	if err != nil {
+		return Statement{}, err
+	}
+	statement.ClosingBalance, err = closingBalance(statement)
+	if err != nil {
+		return Statement{}, err
+	}
+	return statement, nil
+}
+
+func (s Statement) isEmpty() bool {
+	return s.OpeningBalance.IsZero() && len(s.Accruals) == 0 && len(s.Withdrawals) == 0 && len(s.Other) == 0
+}
+
+func closingBalance(statement Statement) (money.Amount, error) {
+	closing, err := statement.OpeningBalance.Add(statement.TotalAccrued)
+	if err != nil {
+		return 0, err
+	}
+	if closing, err = closing.Sub(statement.TotalWithdrawn); err != nil {
+		return 0, err
+	}
+	return closing.Add(statement.TotalOther)
+}
+
+// WriteStatement пишет выписку в формате json или csv.
+func WriteStatement(writer io.Writer, statement Statement, format string) error {
+	switch format {
+	case StatementFormatJSON:
+		return json.NewEncoder(writer).Encode(statement)
+	case StatementFormatCSV:
+		return writeStatementCSV(writer, statement)
+	default:
+		return ErrUnknownStatementFormat
+	}
+}
+
+var statementCSVHeader = []string{"section", "kind", "reference", "sum", "created_at", "wallet"}
+
+func writeStatementCSV(writer io.Writer, statement Statement) error {
+	csvWriter := csv.NewWriter(writer)
+	records := [][]string{
+		statementCSVHeader,
+		{
+			"opening_balance", "", "", statement.OpeningBalance.String(), statement.From.Format(time.RFC3339),
+			statement.Wallet,
+		},
+	}
+	for _, section := range []struct {
+		name  string
+		lines []StatementLine
+	}{
+		{"accrual", statement.Accruals},
+		{"withdrawal", statement.Withdrawals},
+		{"other", statement.Other},
+	} {
+		for _, line := range section.lines {
+			records = append(records, []string{
+				section.name,
+				line.Kind,
+				line.Reference,
+				line.Amount.String(),
+				line.CreatedAt.Format(time.RFC3339Nano),
+				statement.Wallet,
+			})
+		}
+	}
+	records = append(records, []string{
+		"closing_balance", "", "", statement.ClosingBalance.String(), statement.To.Format(time.RFC3339),
+		statement.Wallet,
+	})
+	if err := csvWriter.WriteAll(records); err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
+
+// MonthPeriod возвращает границы календарного месяца в формате YYYY-MM; пустая строка — предыдущий месяц.
+func MonthPeriod(month string, now time.Time) (time.Time, time.Time, error) {
+	var from time.Time
+	if month == "" {
+		current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		from = current.AddDate(0, -1, 0)
+	} else {
+		parsed, err := time.Parse("2006-01", month)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		from = parsed
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+)
+
+type TransferServiceInterface interface {
+	Create(
+		ctx context.Context,
+		fromUserID uint64,
+		toLogin string,
+		amount money.Amount,
+		reference string) (repositories.Transfer, error)
+	ReadAllByUserID(ctx context.Context, userID uint64) ([]repositories.Transfer, error)
+}
+
+type TransferService struct {
+	transferRepository repositories.TransferRepositoryInterface
+	limits             repositories.TransferLimits
+}
+
+func NewTransferService(
+	transferRepository repositories.TransferRepositoryInterface, settings *config.Config) (*TransferService, error) {
+	limits, err := transferLimitsFromConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferService{
+		transferRepository: transferRepository,
+		limits:             limits,
+	}, nil
+}
+
+func transferLimitsFromConfig(settings *config.Config) (repositories.TransferLimits, error) {
+	var limits repositories.TransferLimits
+	for _, limit := range []struct {
+		name  string
+		value string
+		dest  *money.Amount
+	}{
+		{"TRANSFER_MIN_AMOUNT", settings.TransferMinAmount, &limits.MinAmount},
+		{"TRANSFER_MAX_AMOUNT", settings.TransferMaxAmount, &limits.MaxAmount},
+		{"TRANSFER_DAILY_AMOUNT", settings.TransferDailyAmount, &limits.DailyAmount},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		amount, err := money.Parse(limit.value)
+		if err != nil {
+			return repositories.TransferLimits{}, fmt.Errorf("invalid %s: %w", limit.name, err)
+		}
+		*limit.dest = amount
+	}
+	return limits, nil
+}
+
+func (t TransferService) Create(
+	ctx context.Context,
+	fromUserID uint64,
+	toLogin string,
+	amount money.Amount,
+	reference string) (repositories.Transfer, error) {
+	return t.transferRepository.Create(ctx, fromUserID, toLogin, amount, reference, t.limits)
+}
+
+func (t TransferService) ReadAllByUserID(ctx context.Context, userID uint64) ([]repositories.Transfer, error) {
+	return t.transferRepository.ReadAllByUserID(ctx, userID)
+}
@@ -35,10 +35,16 @@ var ErrInvalidHash = errors.New("the encoded hash is not in the correct format")
 var ErrIncompatibleVersion = errors.New("incompatible version of argon2")
 var ErrPasswordIsIncorrect = errors.New("provided password is incorrect")
 
+// Алфавит реферальных кодов без похожих символов (0/O, 1/I)
+const referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const referralCodeLength = 8
+const referralCodeAttempts = 3
+
 type UserServiceInterface interface {
-	Register(ctx context.Context, login string, password string) (uint64, error)
+	Register(ctx context.Context, login string, password string, referrerCode string) (uint64, error)
 	Authenticate(ctx context.Context, login string, password string) (uint64, error)
-	GetBalances(ctx context.Context, userID uint64) (float32, float32, error)
+	GetBalances(ctx context.Context, userID uint64) (repositories.Balances, error)
+	IsAdmin(ctx context.Context, userID uint64) (bool, error)
 }
 
 type UserService struct {
@@ -49,7 +55,8 @@ func NewUserService(userRepo repositories.UserRepositoryInterface) *UserService
 	return &UserService{userRepository: userRepo}
 }
 
-func (u UserService) Register(ctx context.Context, login string, password string) (uint64, error) {
+// Register создаёт пользователя со сгенерированным реферальным кодом, referrerCode может быть пустым.
+func (u UserService) Register(ctx context.Context, login string, password string, referrerCode string) (uint64, error) {
 	salt, err := u.generateSalt(argon2Params.saltLength)
 	if err != nil {
 		return 0, err
@@ -58,9 +65,20 @@ func (u UserService) Register(ctx context.Context, login string, password string
 	if err != nil {
 		return 0, err
 	}
-	user, err := u.userRepository.Create(ctx, login, password)
-	if err != nil {
-		return 0, err
+	var user repositories.User
+	for attempt := 0; ; attempt++ {
+		referralCode, codeErr := u.generateReferralCode()
+		if codeErr != nil {
+			return 0, codeErr
+		}
+		user, err = u.userRepository.Create(ctx, login, password, referralCode, referrerCode)
+		if errors.Is(err, repositories.ErrReferralCodeTaken) && attempt < referralCodeAttempts {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		break
 	}
 	logger.Log.Debugf("User created: %s", login)
 	return user.ID, nil
@@ -81,12 +99,16 @@ func (u UserService) Authenticate(ctx context.Context, login string, password st
 	return user.ID, nil
 }
 
-func (u UserService) GetBalances(ctx context.Context, userID uint64) (float32, float32, error) {
-	balance, withdrawnBalances, err := u.userRepository.GetBalances(ctx, userID)
+func (u UserService) GetBalances(ctx context.Context, userID uint64) (repositories.Balances, error) {
+	balances, err := u.userRepository.GetBalances(ctx, userID)
 	if err != nil {
-		return 0, 0, err
+		return repositories.Balances{}, err
 	}
-	return balance, withdrawnBalances, nil
+	return balances, nil
+}
+
+func (u UserService) IsAdmin(ctx context.Context, userID uint64) (bool, error) {
+	return u.userRepository.IsAdmin(ctx, userID)
 }
 
 func (u UserService) generateEncodedPasswordHash(
@@ -173,3 +195,14 @@ func (u UserService) generateSalt(length uint32) ([]byte, error) {
 	}
 	return bytes, nil
 }
+
+func (u UserService) generateReferralCode() (string, error) {
+	bytes, err := u.generateSalt(referralCodeLength)
+	if err != nil {
+		return "", err
+	}
+	for index, value := range bytes {
+		bytes[index] = referralCodeAlphabet[int(value)%len(referralCodeAlphabet)]
+	}
+	return string(bytes), nil
+}
@@ -2,36 +2,173 @@ package service
 
 import (
 	"context"
+	"errors"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
 	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
 )
 
 type WithdrawalServiceInterface interface {
-	Create(ctx context.Context, number string, amount float64, userID uint64) (uint64, error)
-	ReadAllByUserID(ctx context.Context, userID uint64) ([]repositories.Withdrawal, error)
+	Create(
+		ctx context.Context,
+		number string,
+		amount money.Amount,
+		orderTotal money.NullAmount,
+		wallet string,
+		userID uint64) (uint64, error)
+	Quote(ctx context.Context, orderTotal money.Amount, wallet string, userID uint64) (WithdrawalQuote, error)
+	ReadAllByUserID(
+		ctx context.Context,
+		userID uint64,
+		filter repositories.WithdrawalFilter) ([]repositories.Withdrawal, *repositories.WithdrawalCursor, error)
+	GetListVersion(ctx context.Context, userID uint64) (repositories.ListVersion, error)
+	StreamAllByUserID(
+		ctx context.Context,
+		userID uint64,
+		filter repositories.WithdrawalFilter,
+		consume func(withdrawal repositories.Withdrawal) error) (*repositories.WithdrawalCursor, error)
+	Reverse(
+		ctx context.Context,
+		number string,
+		amount money.Amount,
+		reason string,
+		adminID uint64) (repositories.WithdrawalReversal, error)
 }
 
+// WithdrawalQuote — предварительный расчёт списания для корзины: сколько баллов можно применить
+// и какой останется доступный баланс. LimitedBy — код самого строгого ограничения.
+type WithdrawalQuote struct {
+	Wallet           string
+	Tier             string
+	OrderTotal       money.Amount
+	Available        money.Amount
+	MaxAmount        money.Amount
+	ResultingBalance money.Amount
+	LimitedBy        string
+}
+
+var ErrInvalidOrderTotal = errors.New("order total must be positive")
+var ErrInvalidWithdrawalAmountRange = errors.New("min_sum must not exceed max_sum")
+var ErrInvalidWithdrawalDateRange = errors.New("from must precede to")
+
+// MaxWithdrawalsLimit ограничивает размер страницы истории списаний, без limit история отдаётся целиком.
+const MaxWithdrawalsLimit = 500
+
 type WithdrawalService struct {
 	withdrawalRepository repositories.WithdrawalRepositoryInterface
+	policies             *WithdrawalPolicies
 }
 
-func NewWithdrawalService(withdrawalRepository repositories.WithdrawalRepositoryInterface) *WithdrawalService {
+func NewWithdrawalService(
+	withdrawalRepository repositories.WithdrawalRepositoryInterface, policies *WithdrawalPolicies) *WithdrawalService {
 	return &WithdrawalService{
 		withdrawalRepository: withdrawalRepository,
+		policies:             policies,
 	}
 }
 
-func (w WithdrawalService) Create(ctx context.Context, number string, amount float64, userID uint64) (uint64, error) {
-	createdWithdrawalID, err := w.withdrawalRepository.Create(ctx, number, amount, userID)
+func (w WithdrawalService) Create(
+	ctx context.Context,
+	number string,
+	amount money.Amount,
+	orderTotal money.NullAmount,
+	wallet string,
+	userID uint64) (uint64, error) {
+	policy, err := w.policies.For(ctx, userID)
 	if err != nil {
 		return 0, err
 	}
+	if err = policy.Check(amount, orderTotal); err != nil {
+		return 0, err
+	}
+	createdWithdrawalID, err := w.withdrawalRepository.Create(ctx, number, amount, wallet, userID, policy.Caps())
+	if err != nil {
+		return 0, policy.CapViolation(err)
+	}
 	return createdWithdrawalID, nil
 }
 
-func (w WithdrawalService) ReadAllByUserID(ctx context.Context, userID uint64) ([]repositories.Withdrawal, error) {
-	existingWithdrawals, err := w.withdrawalRepository.ReadAllByUserID(ctx, userID)
+// Quote применяет к корзине те же правила, что и Create, ничего не списывая и не резервируя.
+func (w WithdrawalService) Quote(
+	ctx context.Context, orderTotal money.Amount, wallet string, userID uint64) (WithdrawalQuote, error) {
+	if !orderTotal.IsPositive() {
+		return WithdrawalQuote{}, ErrInvalidOrderTotal
+	}
+	policy, err := w.policies.For(ctx, userID)
+	if err != nil {
+		return WithdrawalQuote{}, err
+	}
+	allowance, err := w.withdrawalRepository.ReadAllowance(ctx, userID, wallet)
+	if err != nil {
+		return WithdrawalQuote{}, err
+	}
+	maxAmount, limitedBy, err := policy.MaxApplicable(allowance, orderTotal)
+	if err != nil {
+		return WithdrawalQuote{}, err
+	}
+	resultingBalance, err := allowance.Available.Sub(maxAmount)
+	if err != nil {
+		return WithdrawalQuote{}, err
+	}
+	return WithdrawalQuote{
+		Wallet:           wallet,
+		Tier:             policy.Tier,
+		OrderTotal:       orderTotal,
+		Available:        allowance.Available,
+		MaxAmount:        maxAmount,
+		ResultingBalance: resultingBalance,
+		LimitedBy:        limitedBy,
+	}, nil
+}
+
+func (w WithdrawalService) ReadAllByUserID(
+	ctx context.Context,
+	userID uint64,
+	filter repositories.WithdrawalFilter) ([]repositories.Withdrawal, *repositories.WithdrawalCursor, error) {
+	filter, err := normalizeWithdrawalFilter(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	existingWithdrawals, next, err := w.withdrawalRepository.ReadAllByUserID(ctx, userID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	return existingWithdrawals, next, nil
+}
+
+func (w WithdrawalService) GetListVersion(ctx context.Context, userID uint64) (repositories.ListVersion, error) {
+	return w.withdrawalRepository.GetListVersion(ctx, userID)
+}
+
+func (w WithdrawalService) StreamAllByUserID(
+	ctx context.Context,
+	userID uint64,
+	filter repositories.WithdrawalFilter,
+	consume func(withdrawal repositories.Withdrawal) error) (*repositories.WithdrawalCursor, error) {
+	filter, err := normalizeWithdrawalFilter(filter)
 	if err != nil {
 		return nil, err
 	}
-	return existingWithdrawals, nil
+	return w.withdrawalRepository.StreamAllByUserID(ctx, userID, filter, consume)
+}
+
+func normalizeWithdrawalFilter(filter repositories.WithdrawalFilter) (repositories.WithdrawalFilter, error) {
+	if filter.MinAmount.Valid && filter.MaxAmount.Valid && filter.MinAmount.Amount.Cmp(filter.MaxAmount.Amount) > 0 {
+		return filter, ErrInvalidWithdrawalAmountRange
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, ErrInvalidWithdrawalDateRange
+	}
+	if filter.Limit > MaxWithdrawalsLimit {
+		filter.Limit = MaxWithdrawalsLimit
+	}
+	return filter, nil
+}
+
+func (w WithdrawalService) Reverse(
+	ctx context.Context,
+	number string,
+	amount money.Amount,
+	reason string,
+	adminID uint64) (repositories.WithdrawalReversal, error) {
+	return w.withdrawalRepository.Reverse(ctx, number, amount, reason, adminID)
 }
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"github.com/ClearThree/gophermart-bonus/internal/app/money"
+	"github.com/ClearThree/gophermart-bonus/internal/app/repositories"
+	"math/big"
+)
+
+const (
+	PolicyViolationInvalidAmount      = "invalid_amount"
+	PolicyViolationBelowMinimum       = "below_minimum"
+	PolicyViolationAboveMaximum       = "above_maximum"
+	PolicyViolationDailyCap           = "daily_cap_exceeded"
+	PolicyViolationMonthlyCap         = "monthly_cap_exceeded"
+	PolicyViolationOrderShare         = "order_share_exceeded"
+	PolicyViolationOrderTotalRequired = "order_total_required"
+)
+
+// Ограничения максимальной суммы списания помимо правил политики
+const (
+	QuoteLimitBalance    = "balance"
+	QuoteLimitOrderTotal = "order_total"
+)
+
+// PolicyViolation описывает нарушенное правило политики списаний и отдаётся клиенту в ответе 422.
+type PolicyViolation struct {
+	Code    string
+	Message string
+	Tier    string
+	Limit   money.Amount
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("withdrawal policy violation %s: %s", v.Code, v.Message)
+}
+
+// WithdrawalPolicy — ограничения списаний для уровня пользователя. Нулевые значения не ограничивают.
+type WithdrawalPolicy struct {
+	Tier          string
+	MinAmount     money.Amount
+	MaxAmount     money.Amount
+	DailyCap      money.Amount
+	MonthlyCap    money.Amount
+	MaxOrderShare string
+}
+
+func (p WithdrawalPolicy) Caps() repositories.WithdrawalCaps {
+	return repositories.WithdrawalCaps{Daily: p.DailyCap, Monthly: p.MonthlyCap}
+}
+
+// Check проверяет правила, не зависящие от истории списаний. Лимиты за период проверяет репозиторий в транзакции.
+func (p WithdrawalPolicy) Check(amount money.Amount, orderTotal money.NullAmount) error {
+	if !amount.IsPositive() {
+		return &PolicyViolation{
+			Code: PolicyViolationInvalidAmount, Message: "withdrawal amount must be positive", Tier: p.Tier}
+	}
+	if p.MinAmount.IsPositive() && amount.Cmp(p.MinAmount) < 0 {
+		return &PolicyViolation{
+			Code: PolicyViolationBelowMinimum, Message: "withdrawal amount is below the minimum",
+			Tier: p.Tier, Limit: p.MinAmount}
+	}
+	if p.MaxAmount.IsPositive() && amount.Cmp(p.MaxAmount) > 0 {
+		return &PolicyViolation{
+			Code: PolicyViolationAboveMaximum, Message: "withdrawal amount is above the maximum",
+			Tier: p.Tier, Limit: p.MaxAmount}
+	}
+	if !p.limitsOrderShare() {
+		return nil
+	}
+	if !orderTotal.Valid || !orderTotal.Amount.IsPositive() {
+		return &PolicyViolation{
+			Code: PolicyViolationOrderTotalRequired, Message: "order_total is required to check the order share",
+			Tier: p.Tier}
+	}
+	limit, err := orderTotal.Amount.MulFactor(p.MaxOrderShare)
+	if err != nil {
+		return err
+	}
+	if amount.Cmp(limit) > 0 {
+		return &PolicyViolation{
+			Code: PolicyViolationOrderShare, Message: "withdrawal exceeds the allowed share of the order total",
+			Tier: p.Tier, Limit: limit}
+	}
+	return nil
+}
+
+type quoteLimit struct {
+	code  string
+	limit money.Amount
+}
+
+// MaxApplicable возвращает наибольшую сумму, которую Check и лимиты за период пропустят для заказа на orderTotal,
+// и код ограничения, которое оказалось самым строгим. Если допустимая сумма меньше минимальной, возвращается 0.
+func (p WithdrawalPolicy) MaxApplicable(
+	allowance repositories.WithdrawalAllowance, orderTotal money.Amount) (money.Amount, string, error) {
+	limits := []quoteLimit{
+		{QuoteLimitBalance, money.Max(allowance.Available, 0)},
+		{QuoteLimitOrderTotal, orderTotal},
+	}
+	if p.MaxAmount.IsPositive() {
+		limits = append(limits, quoteLimit{PolicyViolationAboveMaximum, p.MaxAmount})
+	}
+	if p.limitsOrderShare() {
+		shareLimit, err := orderTotal.MulFactor(p.MaxOrderShare)
+		if err != nil {
+			return 0, "", err
+		}
+		limits = append(limits, quoteLimit{PolicyViolationOrderShare, shareLimit})
+	}
+	if p.DailyCap.IsPositive() {
+		left, err := remainingCap(p.DailyCap, allowance.SpentToday)
+		if err != nil {
+			return 0, "", err
+		}
+		limits = append(limits, quoteLimit{PolicyViolationDailyCap, left})
+	}
+	if p.MonthlyCap.IsPositive() {
+		left, err := remainingCap(p.MonthlyCap, allowance.SpentThisMonth)
+		if err != nil {
+			return 0, "", err
+		}
+		limits = append(limits, quoteLimit{PolicyViolationMonthlyCap, left})
+	}
+	strictest := limits[0]
+	for _, limit := range limits[1:] {
+		if limit.limit.Cmp(strictest.limit) < 0 {
+			strictest = limit
+		}
+	}
+	if p.MinAmount.IsPositive() && strictest.limit.Cmp(p.MinAmount) < 0 {
+		return 0, PolicyViolationBelowMinimum, nil
+	}
+	return strictest.limit, strictest.code, nil
+}
+
+func remainingCap(limit money.Amount, spent money.Amount) (money.Amount, error) {
+	left, err := limit.Sub(spent)
+	if err != nil {
+		return 0, err
+	}
+	return money.Max(left, 0), nil
+}
+
+// CapViolation переводит ошибку лимита за период из репозитория в нарушение политики.
+func (p WithdrawalPolicy) CapViolation(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrDailyWithdrawalCapExceeded):
+		return &PolicyViolation{
+			Code: PolicyViolationDailyCap, Message: "daily withdrawal cap exceeded", Tier: p.Tier, Limit: p.DailyCap}
+	case errors.Is(err, repositories.ErrMonthlyWithdrawalCapExceeded):
+		return &PolicyViolation{
+			Code: PolicyViolationMonthlyCap, Message: "monthly withdrawal cap exceeded", Tier: p.Tier, Limit: p.MonthlyCap}
+	default:
+		return err
+	}
+}
+
+func (p WithdrawalPolicy) limitsOrderShare() bool {
+	share, ok := new(big.Rat).SetString(p.MaxOrderShare)
+	return ok && share.Sign() > 0
+}
+
+type TierReader interface {
+	ReadTier(ctx context.Context, userID uint64) (string, error)
+}
+
+// tierPolicyOverride — переопределение политики уровня, незаданные поля берутся из глобальной политики.
+type tierPolicyOverride struct {
+	MinAmount     *money.Amount `json:"min_amount"`
+	MaxAmount     *money.Amount `json:"max_amount"`
+	DailyCap      *money.Amount `json:"daily_cap"`
+	MonthlyCap    *money.Amount `json:"monthly_cap"`
+	MaxOrderShare *string       `json:"max_order_share"`
+}
+
+type WithdrawalPolicies struct {
+	tierReader TierReader
+	global     WithdrawalPolicy
+	tiers      map[string]WithdrawalPolicy
+}
+
+func NewWithdrawalPoliciesFromConfig(tierReader TierReader, settings *config.Config) (*WithdrawalPolicies, error) {
+	global := WithdrawalPolicy{MaxOrderShare: settings.WithdrawalMaxOrderShare}
+	for _, limit := range []struct {
+		name  string
+		value string
+		dest  *money.Amount
+	}{
+		{"WITHDRAWAL_MIN_AMOUNT", settings.WithdrawalMinAmount, &global.MinAmount},
+		{"WITHDRAWAL_MAX_AMOUNT", settings.WithdrawalMaxAmount, &global.MaxAmount},
+		{"WITHDRAWAL_DAILY_CAP", settings.WithdrawalDailyCap, &global.DailyCap},
+		{"WITHDRAWAL_MONTHLY_CAP", settings.WithdrawalMonthlyCap, &global.MonthlyCap},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		amount, err := money.Parse(limit.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", limit.name, err)
+		}
+		*limit.dest = amount
+	}
+	if err := validateShare(global.MaxOrderShare); err != nil {
+		return nil, fmt.Errorf("invalid WITHDRAWAL_MAX_ORDER_SHARE: %w", err)
+	}
+
+	policies := &WithdrawalPolicies{tierReader: tierReader, global: global, tiers: map[string]WithdrawalPolicy{}}
+	if settings.WithdrawalTierPolicies == "" {
+		return policies, nil
+	}
+	var overrides map[string]tierPolicyOverride
+	if err := json.Unmarshal([]byte(settings.WithdrawalTierPolicies), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid WITHDRAWAL_TIER_POLICIES: %w", err)
+	}
+	for tier, override := range overrides {
+		policy := global
+		policy.Tier = tier
+		if override.MinAmount != nil {
+			policy.MinAmount = *override.MinAmount
+		}
+		if override.MaxAmount != nil {
+			policy.MaxAmount = *override.MaxAmount
+		}
+		if override.DailyCap != nil {
+			policy.DailyCap = *override.DailyCap
+		}
+		if override.MonthlyCap != nil {
+			policy.MonthlyCap = *override.MonthlyCap
+		}
+		if override.MaxOrderShare != nil {
+			if err := validateShare(*override.MaxOrderShare); err != nil {
+				return nil, fmt.Errorf("invalid max_order_share of tier %s: %w", tier, err)
+			}
+			policy.MaxOrderShare = *override.MaxOrderShare
+		}
+		policies.tiers[tier] = policy
+	}
+	return policies, nil
+}
+
+// For возвращает политику пользователя по его текущему уровню.
+func (w WithdrawalPolicies) For(ctx context.Context, userID uint64) (WithdrawalPolicy, error) {
+	tier, err := w.tierReader.ReadTier(ctx, userID)
+	if err != nil {
+		return WithdrawalPolicy{}, err
+	}
+	if policy, ok := w.tiers[tier]; ok {
+		return policy, nil
+	}
+	policy := w.global
+	policy.Tier = tier
+	return policy, nil
+}
+
+func validateShare(share string) error {
+	if share == "" {
+		return nil
+	}
+	if _, ok := new(big.Rat).SetString(share); !ok {
+		return fmt.Errorf("%q is not a decimal fraction", share)
+	}
+	return nil
+}
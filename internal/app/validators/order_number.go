@@ -0,0 +1,181 @@
+package validators
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	OrderNumberValidatorLuhn    = "luhn"
+	OrderNumberValidatorCharset = "charset"
+	OrderNumberValidatorRegex   = "regex"
+)
+
+var ErrInvalidOrderNumber = errors.New("invalid order number")
+var ErrUnknownOrderNumberValidator = errors.New("unknown order number validator")
+
+type OrderNumberValidator interface {
+	Validate(number string) error
+}
+
+type LuhnValidator struct{}
+
+func NewLuhnValidator() LuhnValidator {
+	return LuhnValidator{}
+}
+
+// Validate считает контрольную сумму по цифрам строки, поэтому не ограничен размером int.
+func (l LuhnValidator) Validate(number string) error {
+	if number == "" {
+		return fmt.Errorf("%w: empty number", ErrInvalidOrderNumber)
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if digit < 0 || digit > 9 {
+			return fmt.Errorf("%w: luhn check requires digits only", ErrInvalidOrderNumber)
+		}
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	if sum%10 != 0 {
+		return fmt.Errorf("%w: luhn checksum mismatch", ErrInvalidOrderNumber)
+	}
+	return nil
+}
+
+type LengthCharsetValidator struct {
+	minLength int
+	maxLength int
+	charset   string
+}
+
+func NewLengthCharsetValidator(minLength int, maxLength int, charset string) LengthCharsetValidator {
+	return LengthCharsetValidator{minLength: minLength, maxLength: maxLength, charset: charset}
+}
+
+func (l LengthCharsetValidator) Validate(number string) error {
+	length := utf8.RuneCountInString(number)
+	if l.minLength > 0 && length < l.minLength {
+		return fmt.Errorf("%w: shorter than %d characters", ErrInvalidOrderNumber, l.minLength)
+	}
+	if l.maxLength > 0 && length > l.maxLength {
+		return fmt.Errorf("%w: longer than %d characters", ErrInvalidOrderNumber, l.maxLength)
+	}
+	if l.charset == "" {
+		return nil
+	}
+	for _, char := range number {
+		if !strings.ContainsRune(l.charset, char) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidOrderNumber, char)
+		}
+	}
+	return nil
+}
+
+type RegexValidator struct {
+	pattern *regexp.Regexp
+}
+
+// NewRegexValidator привязывает шаблон к началу и концу строки: номер должен совпасть с ним целиком.
+func NewRegexValidator(pattern string) (RegexValidator, error) {
+	compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return RegexValidator{}, err
+	}
+	return RegexValidator{pattern: compiled}, nil
+}
+
+func (r RegexValidator) Validate(number string) error {
+	if !r.pattern.MatchString(number) {
+		return fmt.Errorf("%w: does not match pattern %s", ErrInvalidOrderNumber, r.pattern.String())
+	}
+	return nil
+}
+
+type ChainValidator struct {
+	validators []OrderNumberValidator
+}
+
+func NewChainValidator(validators ...OrderNumberValidator) ChainValidator {
+	return ChainValidator{validators: validators}
+}
+
+func (c ChainValidator) Validate(number string) error {
+	for _, validator := range c.validators {
+		if err := validator.Validate(number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrderNumberChecker приводит номер к каноничному виду и проверяет его цепочкой валидаторов.
+// Используется одинаково для номеров заказов и номеров заказов списаний.
+type OrderNumberChecker struct {
+	separators string
+	validator  OrderNumberValidator
+}
+
+func NewOrderNumberChecker(separators string, validator OrderNumberValidator) *OrderNumberChecker {
+	return &OrderNumberChecker{separators: separators, validator: validator}
+}
+
+func NewOrderNumberCheckerFromConfig(cfg *config.Config) (*OrderNumberChecker, error) {
+	validators := make([]OrderNumberValidator, 0, len(cfg.OrderNumberValidators))
+	for _, name := range cfg.OrderNumberValidators {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case OrderNumberValidatorLuhn:
+			validators = append(validators, NewLuhnValidator())
+		case OrderNumberValidatorCharset:
+			validators = append(validators, NewLengthCharsetValidator(
+				cfg.OrderNumberMinLength, cfg.OrderNumberMaxLength, cfg.OrderNumberCharset))
+		case OrderNumberValidatorRegex:
+			validator, err := NewRegexValidator(cfg.OrderNumberPattern)
+			if err != nil {
+				return nil, err
+			}
+			validators = append(validators, validator)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownOrderNumberValidator, name)
+		}
+	}
+	return NewOrderNumberChecker(cfg.OrderNumberSeparators, NewChainValidator(validators...)), nil
+}
+
+func (c *OrderNumberChecker) Normalize(number string) string {
+	number = strings.TrimSpace(number)
+	if c.separators == "" {
+		return number
+	}
+	return strings.Map(func(char rune) rune {
+		if strings.ContainsRune(c.separators, char) {
+			return -1
+		}
+		return char
+	}, number)
+}
+
+func (c *OrderNumberChecker) Check(number string) (string, error) {
+	normalized := c.Normalize(number)
+	if normalized == "" {
+		return "", fmt.Errorf("%w: empty number", ErrInvalidOrderNumber)
+	}
+	if err := c.validator.Validate(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
@@ -0,0 +1,183 @@
+package validators
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLuhnValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  string
+		wantErr bool
+	}{
+		{name: "short valid", number: "79927398713"},
+		{name: "valid", number: "12345678903"},
+		{name: "longer than int64", number: "12345678901234567890123459"},
+		{name: "thirty nines", number: "999999999999999999999999999999"},
+		{name: "zero", number: "0"},
+		{name: "checksum mismatch", number: "79927398710", wantErr: true},
+		{name: "long checksum mismatch", number: "12345678901234567890123450", wantErr: true},
+		{name: "empty", number: "", wantErr: true},
+		{name: "letters", number: "7992739871A", wantErr: true},
+		{name: "separator", number: "7992-7398-713", wantErr: true},
+	}
+	validator := NewLuhnValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate(tt.number)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidOrderNumber)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLengthCharsetValidator(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator LengthCharsetValidator
+		number    string
+		wantErr   bool
+	}{
+		{name: "no limits", validator: NewLengthCharsetValidator(0, 0, ""), number: "any-ID_42"},
+		{
+			name:      "alphanumeric fits",
+			validator: NewLengthCharsetValidator(4, 8, "ABCDEF0123456789"),
+			number:    "AB12CD",
+		},
+		{
+			name:      "too short",
+			validator: NewLengthCharsetValidator(4, 8, ""),
+			number:    "ABC",
+			wantErr:   true,
+		},
+		{
+			name:      "too long",
+			validator: NewLengthCharsetValidator(4, 8, ""),
+			number:    "ABCDEFGHI",
+			wantErr:   true,
+		},
+		{
+			name:      "length counted in characters",
+			validator: NewLengthCharsetValidator(0, 3, ""),
+			number:    "ЗАК",
+		},
+		{
+			name:      "character outside charset",
+			validator: NewLengthCharsetValidator(0, 0, "0123456789"),
+			number:    "123a",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validator.Validate(tt.number)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidOrderNumber)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRegexValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		number  string
+		wantErr bool
+	}{
+		{name: "match", pattern: `[A-Z]{2}[0-9]+`, number: "AB123"},
+		{name: "anchored at start", pattern: `[0-9]+`, number: "A123", wantErr: true},
+		{name: "anchored at end", pattern: `[0-9]+`, number: "123A", wantErr: true},
+		{name: "alternation anchored as a whole", pattern: `A|B`, number: "AB", wantErr: true},
+		{name: "alternation branch", pattern: `A|B`, number: "B"},
+		{name: "already anchored pattern", pattern: `^[0-9]+$`, number: "42"},
+		{name: "default pattern accepts anything", pattern: `^.*$`, number: "anything goes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewRegexValidator(tt.pattern)
+			require.NoError(t, err)
+			err = validator.Validate(tt.number)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidOrderNumber)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := NewRegexValidator(`[0-9`)
+	assert.Error(t, err)
+}
+
+func TestChainValidator(t *testing.T) {
+	regex, err := NewRegexValidator(`[0-9]+`)
+	require.NoError(t, err)
+	chain := NewChainValidator(NewLengthCharsetValidator(3, 0, ""), regex, NewLuhnValidator())
+
+	tests := []struct {
+		name    string
+		number  string
+		wantErr string
+	}{
+		{name: "passes all", number: "79927398713"},
+		{name: "stops at first failure", number: "1", wantErr: "shorter than 3 characters"},
+		{name: "second validator", number: "12a4", wantErr: "does not match pattern"},
+		{name: "last validator", number: "79927398710", wantErr: "luhn checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := chain.Validate(tt.number)
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrInvalidOrderNumber)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	assert.NoError(t, NewChainValidator().Validate("whatever"))
+}
+
+func TestOrderNumberCheckerNormalize(t *testing.T) {
+	tests := []struct {
+		name       string
+		separators string
+		number     string
+		want       string
+	}{
+		{name: "trims spaces", separators: "", number: "  12345 ", want: "12345"},
+		{name: "keeps inner separators without config", separators: "", number: "1234-5678", want: "1234-5678"},
+		{name: "drops separators", separators: " -", number: "1234 5678-9012", want: "123456789012"},
+		{name: "only separators", separators: "-", number: "---", want: ""},
+		{name: "unicode separator", separators: "·", number: "AB·12", want: "AB12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewOrderNumberChecker(tt.separators, NewChainValidator())
+			assert.Equal(t, tt.want, checker.Normalize(tt.number))
+		})
+	}
+}
+
+func TestOrderNumberCheckerCheck(t *testing.T) {
+	checker := NewOrderNumberChecker(" -", NewLuhnValidator())
+
+	number, err := checker.Check(" 1234-5678-9012-3456-7890-123459 ")
+	require.NoError(t, err)
+	assert.Equal(t, "12345678901234567890123459", number)
+
+	_, err = checker.Check(" - ")
+	assert.ErrorIs(t, err, ErrInvalidOrderNumber)
+
+	_, err = checker.Check("1234-5678-9012-3456-7890-123450")
+	assert.ErrorIs(t, err, ErrInvalidOrderNumber)
+}
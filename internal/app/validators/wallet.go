@@ -0,0 +1,58 @@
+package validators
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ClearThree/gophermart-bonus/internal/app/config"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownWallet = errors.New("unknown wallet")
+
+// WalletChecker проверяет, что кошелёк из запроса настроен. Пустое имя означает основной кошелёк.
+type WalletChecker struct {
+	defaultWallet string
+	wallets       map[string]struct{}
+}
+
+func NewWalletChecker(defaultWallet string, wallets ...string) *WalletChecker {
+	checker := &WalletChecker{
+		defaultWallet: defaultWallet,
+		wallets:       map[string]struct{}{defaultWallet: {}},
+	}
+	for _, wallet := range wallets {
+		wallet = strings.ToLower(strings.TrimSpace(wallet))
+		if wallet != "" {
+			checker.wallets[wallet] = struct{}{}
+		}
+	}
+	return checker
+}
+
+func NewWalletCheckerFromConfig(cfg *config.Config, defaultWallet string) *WalletChecker {
+	return NewWalletChecker(defaultWallet, cfg.Wallets...)
+}
+
+func (c *WalletChecker) Check(wallet string) (string, error) {
+	wallet = strings.ToLower(strings.TrimSpace(wallet))
+	if wallet == "" {
+		return c.defaultWallet, nil
+	}
+	if _, ok := c.wallets[wallet]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownWallet, wallet)
+	}
+	return wallet, nil
+}
+
+// Wallets возвращает настроенные кошельки: основной первым, остальные по алфавиту.
+func (c *WalletChecker) Wallets() []string {
+	wallets := make([]string, 0, len(c.wallets))
+	for wallet := range c.wallets {
+		if wallet != c.defaultWallet {
+			wallets = append(wallets, wallet)
+		}
+	}
+	sort.Strings(wallets)
+	return append([]string{c.defaultWallet}, wallets...)
+}
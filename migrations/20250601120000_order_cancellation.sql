@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Момент, когда воркер впервые взял заказ в обработку. После этого отменить заказ нельзя,
+-- даже если он вернулся в NEW, пока система начислений ещё считает его
+ALTER TABLE "order" ADD COLUMN "claimed_at" TIMESTAMP;
+
+CREATE TABLE "order_cancellation" (
+                                      "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+    -- Идентификатор отменённого заказа, сама строка заказа удаляется, чтобы освободить номер
+                                      "order_id" BIGINT NOT NULL,
+                                      "number" TEXT NOT NULL,
+                                      "user_id" BIGINT NOT NULL,
+                                      "uploaded_at" TIMESTAMP NOT NULL,
+                                      "cancelled_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                      PRIMARY KEY("id")
+);
+CREATE INDEX "order_cancellation_user_id_idx"
+    ON "order_cancellation" ("user_id");
+
+ALTER TABLE "order_cancellation"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "order_cancellation_user_id_idx";
+DROP TABLE "order_cancellation";
+ALTER TABLE "order" DROP COLUMN "claimed_at";
+-- +goose StatementEnd
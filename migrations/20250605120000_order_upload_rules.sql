@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "order_upload_attempt" (
+                                        "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                        "user_id" BIGINT NOT NULL,
+                                        "number" TEXT NOT NULL,
+    -- Результат попытки: accepted, duplicate_own, duplicate_other, rejected
+                                        "outcome" TEXT NOT NULL,
+                                        "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                        PRIMARY KEY("id")
+);
+CREATE INDEX "order_upload_attempt_user_id_created_at_idx"
+    ON "order_upload_attempt" ("user_id", "created_at");
+
+CREATE TABLE "order_rule_hit" (
+                                  "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                  "user_id" BIGINT NOT NULL,
+                                  "rule" TEXT NOT NULL,
+                                  "value" NUMERIC NOT NULL,
+                                  "threshold" NUMERIC NOT NULL,
+    -- Что сделали с пользователем: reject или flag
+                                  "action" TEXT NOT NULL,
+                                  "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                  PRIMARY KEY("id")
+);
+CREATE INDEX "order_rule_hit_user_id_idx"
+    ON "order_rule_hit" ("user_id");
+
+ALTER TABLE "user"
+    ADD COLUMN "flagged_for_review" BOOLEAN NOT NULL DEFAULT False,
+    ADD COLUMN "flagged_at" TIMESTAMP;
+
+ALTER TABLE "order_upload_attempt"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "order_rule_hit"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "user"
+    DROP COLUMN "flagged_for_review",
+    DROP COLUMN "flagged_at";
+
+DROP INDEX "order_rule_hit_user_id_idx";
+DROP INDEX "order_upload_attempt_user_id_created_at_idx";
+DROP TABLE "order_rule_hit";
+DROP TABLE "order_upload_attempt";
+-- +goose StatementEnd
@@ -0,0 +1,110 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "ledger_transaction" (
+                                      "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                      "user_id" BIGINT NOT NULL,
+    -- Тип операции: accrual, withdrawal, adjustment, reversal
+                                      "kind" TEXT NOT NULL,
+    -- Номер заказа или другой внешний идентификатор операции
+                                      "reference" TEXT,
+    -- Идентификатор строки в таблице-источнике (accrual, withdrawal и т.д.)
+                                      "source_id" BIGINT,
+                                      "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                      PRIMARY KEY("id")
+);
+CREATE INDEX "ledger_transaction_user_id_created_at_idx"
+    ON "ledger_transaction" ("user_id", "created_at");
+
+CREATE TABLE "ledger_entry" (
+                                "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                "transaction_id" BIGINT NOT NULL,
+    -- Счёт проводки: user:points для баллов пользователя, system:* для системных счетов
+                                "account" TEXT NOT NULL,
+                                "user_id" BIGINT,
+                                "amount" NUMERIC NOT NULL,
+                                "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                PRIMARY KEY("id")
+);
+CREATE INDEX "ledger_entry_transaction_id_idx"
+    ON "ledger_entry" ("transaction_id");
+CREATE INDEX "ledger_entry_user_id_account_idx"
+    ON "ledger_entry" ("user_id", "account");
+
+ALTER TABLE "ledger_transaction"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "ledger_entry"
+    ADD FOREIGN KEY("transaction_id") REFERENCES "ledger_transaction"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "ledger_entry"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+-- Журнал только дописывается, исправления делаются новыми проводками
+CREATE FUNCTION "ledger_append_only"() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "ledger_transaction_append_only"
+    BEFORE UPDATE OR DELETE ON "ledger_transaction"
+    FOR EACH ROW EXECUTE FUNCTION "ledger_append_only"();
+
+CREATE TRIGGER "ledger_entry_append_only"
+    BEFORE UPDATE OR DELETE ON "ledger_entry"
+    FOR EACH ROW EXECUTE FUNCTION "ledger_append_only"();
+
+-- Сумма проводок по каждой операции должна быть нулевой к моменту коммита
+CREATE FUNCTION "ledger_transaction_balanced"() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM "ledger_entry" WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER "ledger_entry_balanced"
+    AFTER INSERT ON "ledger_entry"
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION "ledger_transaction_balanced"();
+
+INSERT INTO "ledger_transaction" (user_id, kind, reference, source_id, created_at)
+SELECT a.user_id, 'accrual', o.number, a.id, a.created_at
+FROM "accrual" a JOIN "order" o ON o.id = a.order_id;
+
+INSERT INTO "ledger_transaction" (user_id, kind, reference, source_id, created_at)
+SELECT w.user_id, 'withdrawal', w.withdrawal_order_number, w.id, w.created_at
+FROM "withdrawal" w;
+
+INSERT INTO "ledger_entry" (transaction_id, account, user_id, amount, created_at)
+SELECT t.id, 'user:points', t.user_id, a.amount, t.created_at
+FROM "ledger_transaction" t JOIN "accrual" a ON t.kind = 'accrual' AND a.id = t.source_id
+UNION ALL
+SELECT t.id, 'system:accrual', NULL, -a.amount, t.created_at
+FROM "ledger_transaction" t JOIN "accrual" a ON t.kind = 'accrual' AND a.id = t.source_id
+UNION ALL
+SELECT t.id, 'user:points', t.user_id, -w.amount, t.created_at
+FROM "ledger_transaction" t JOIN "withdrawal" w ON t.kind = 'withdrawal' AND w.id = t.source_id
+UNION ALL
+SELECT t.id, 'system:redemption', NULL, w.amount, t.created_at
+FROM "ledger_transaction" t JOIN "withdrawal" w ON t.kind = 'withdrawal' AND w.id = t.source_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER "ledger_entry_balanced" ON "ledger_entry";
+DROP TRIGGER "ledger_entry_append_only" ON "ledger_entry";
+DROP TRIGGER "ledger_transaction_append_only" ON "ledger_transaction";
+DROP FUNCTION "ledger_transaction_balanced"();
+DROP FUNCTION "ledger_append_only"();
+
+DROP INDEX "ledger_entry_user_id_account_idx";
+DROP INDEX "ledger_entry_transaction_id_idx";
+DROP INDEX "ledger_transaction_user_id_created_at_idx";
+DROP TABLE "ledger_entry";
+DROP TABLE "ledger_transaction";
+-- +goose StatementEnd
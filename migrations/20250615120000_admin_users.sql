@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Администраторы назначаются вручную: UPDATE "user" SET is_admin = True WHERE login = '...'
+ALTER TABLE "user"
+    ADD COLUMN "is_admin" BOOLEAN NOT NULL DEFAULT False;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "user"
+    DROP COLUMN "is_admin";
+-- +goose StatementEnd
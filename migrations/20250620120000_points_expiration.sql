@@ -0,0 +1,92 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "accrual"
+    ADD COLUMN "expires_at" TIMESTAMP;
+
+-- Срок жизни уже сделанных начислений не задаётся: они остаются бессрочными (expires_at IS NULL),
+-- чтобы при первом запуске не сгорели баллы старше POINTS_TTL_MONTHS и не игнорировалось значение 0
+
+-- Партия баллов, из которой списания выбираются по FIFO. Для начислений одна партия на строку accrual.
+CREATE TABLE "points_lot" (
+                              "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                              "user_id" BIGINT NOT NULL,
+                              "ledger_transaction_id" BIGINT NOT NULL,
+                              "accrual_id" BIGINT,
+                              "amount" NUMERIC NOT NULL,
+                              "remaining" NUMERIC NOT NULL,
+    -- NULL означает, что баллы не сгорают
+                              "expires_at" TIMESTAMP,
+                              "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                              PRIMARY KEY("id"),
+                              CHECK ("remaining" >= 0 AND "remaining" <= "amount")
+);
+CREATE INDEX "points_lot_user_id_expires_at_live_idx"
+    ON "points_lot" ("user_id", "expires_at") WHERE "remaining" > 0;
+CREATE INDEX "points_lot_expires_at_live_idx"
+    ON "points_lot" ("expires_at") WHERE "remaining" > 0;
+
+CREATE TABLE "points_lot_consumption" (
+                                          "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                          "lot_id" BIGINT NOT NULL,
+                                          "ledger_transaction_id" BIGINT NOT NULL,
+                                          "amount" NUMERIC NOT NULL,
+                                          "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                          PRIMARY KEY("id")
+);
+CREATE INDEX "points_lot_consumption_lot_id_idx"
+    ON "points_lot_consumption" ("lot_id");
+
+ALTER TABLE "points_lot"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "points_lot"
+    ADD FOREIGN KEY("ledger_transaction_id") REFERENCES "ledger_transaction"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "points_lot"
+    ADD FOREIGN KEY("accrual_id") REFERENCES "accrual"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "points_lot_consumption"
+    ADD FOREIGN KEY("lot_id") REFERENCES "points_lot"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "points_lot_consumption"
+    ADD FOREIGN KEY("ledger_transaction_id") REFERENCES "ledger_transaction"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+-- Остатки существующих начислений: прошлые списания распределяются по самым старым начислениям
+INSERT INTO "points_lot" (user_id, ledger_transaction_id, accrual_id, amount, remaining, expires_at, created_at)
+SELECT lots.user_id,
+       lots.ledger_transaction_id,
+       lots.accrual_id,
+       lots.amount,
+       GREATEST(0, LEAST(lots.amount, lots.cumulative - COALESCE(w.total, 0))),
+       lots.expires_at,
+       lots.created_at
+FROM (
+         SELECT a.user_id,
+                t.id AS ledger_transaction_id,
+                a.id AS accrual_id,
+                a.amount,
+                a.expires_at,
+                a.created_at,
+                SUM(a.amount) OVER (PARTITION BY a.user_id ORDER BY a.created_at, a.id) AS cumulative
+         FROM "accrual" a JOIN "ledger_transaction" t ON t.kind = 'accrual' AND t.source_id = a.id
+     ) lots
+         LEFT JOIN (SELECT user_id, SUM(amount) AS total FROM "withdrawal" GROUP BY user_id) w
+                   ON w.user_id = lots.user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "points_lot_consumption_lot_id_idx";
+DROP INDEX "points_lot_expires_at_live_idx";
+DROP INDEX "points_lot_user_id_expires_at_live_idx";
+DROP TABLE "points_lot_consumption";
+DROP TABLE "points_lot";
+
+ALTER TABLE "accrual"
+    DROP COLUMN "expires_at";
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "withdrawal_hold" (
+                                   "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                   "user_id" BIGINT NOT NULL,
+    -- Номер заказа, под который резервируются баллы, при захвате становится номером списания
+                                   "order_number" TEXT NOT NULL,
+                                   "amount" NUMERIC NOT NULL,
+    -- HELD, CAPTURED, VOIDED, EXPIRED
+                                   "status" TEXT NOT NULL DEFAULT 'HELD',
+                                   "expires_at" TIMESTAMP NOT NULL,
+                                   "withdrawal_id" BIGINT,
+                                   "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                   "modified_at" TIMESTAMP,
+                                   PRIMARY KEY("id")
+);
+CREATE INDEX "withdrawal_hold_user_id_status_idx"
+    ON "withdrawal_hold" ("user_id", "status");
+CREATE UNIQUE INDEX "withdrawal_hold_order_number_held_udx"
+    ON "withdrawal_hold" ("order_number") WHERE "status" = 'HELD';
+
+ALTER TABLE "withdrawal_hold"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "withdrawal_hold"
+    ADD FOREIGN KEY("withdrawal_id") REFERENCES "withdrawal"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "withdrawal_hold_order_number_held_udx";
+DROP INDEX "withdrawal_hold_user_id_status_idx";
+DROP TABLE "withdrawal_hold";
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "withdrawal_reversal" (
+                                       "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                       "withdrawal_id" BIGINT NOT NULL,
+                                       "user_id" BIGINT NOT NULL,
+                                       "amount" NUMERIC NOT NULL CHECK ("amount" > 0),
+                                       "reason" TEXT,
+    -- Администратор, оформивший возврат
+                                       "created_by" BIGINT NOT NULL,
+                                       "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                       PRIMARY KEY("id")
+);
+CREATE INDEX "withdrawal_reversal_withdrawal_id_idx"
+    ON "withdrawal_reversal" ("withdrawal_id");
+CREATE INDEX "withdrawal_reversal_user_id_idx"
+    ON "withdrawal_reversal" ("user_id");
+
+ALTER TABLE "withdrawal_reversal"
+    ADD FOREIGN KEY("withdrawal_id") REFERENCES "withdrawal"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "withdrawal_reversal"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "withdrawal_reversal"
+    ADD FOREIGN KEY("created_by") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "withdrawal_reversal_user_id_idx";
+DROP INDEX "withdrawal_reversal_withdrawal_id_idx";
+DROP TABLE "withdrawal_reversal";
+-- +goose StatementEnd
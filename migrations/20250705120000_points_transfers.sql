@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "points_transfer" (
+                                   "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                   "from_user_id" BIGINT NOT NULL,
+                                   "to_user_id" BIGINT NOT NULL,
+                                   "amount" NUMERIC NOT NULL CHECK ("amount" > 0),
+    -- Клиентский идентификатор перевода, повтор с тем же значением не создаёт второй перевод
+                                   "reference" TEXT,
+                                   "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                   PRIMARY KEY("id"),
+                                   CHECK ("from_user_id" <> "to_user_id")
+);
+CREATE UNIQUE INDEX "points_transfer_from_user_id_reference_udx"
+    ON "points_transfer" ("from_user_id", "reference") WHERE "reference" IS NOT NULL;
+CREATE INDEX "points_transfer_from_user_id_created_at_idx"
+    ON "points_transfer" ("from_user_id", "created_at");
+CREATE INDEX "points_transfer_to_user_id_idx"
+    ON "points_transfer" ("to_user_id");
+
+ALTER TABLE "points_transfer"
+    ADD FOREIGN KEY("from_user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "points_transfer"
+    ADD FOREIGN KEY("to_user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "points_transfer_to_user_id_idx";
+DROP INDEX "points_transfer_from_user_id_created_at_idx";
+DROP INDEX "points_transfer_from_user_id_reference_udx";
+DROP TABLE "points_transfer";
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "idempotency_key" (
+                                   "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                   "user_id" BIGINT NOT NULL,
+                                   "key" TEXT NOT NULL,
+    -- sha256 от метода, пути и тела запроса
+                                   "request_hash" TEXT NOT NULL,
+    -- NULL, пока первый запрос с этим ключом ещё выполняется
+                                   "status_code" INTEGER,
+                                   "content_type" TEXT,
+                                   "response_body" BYTEA,
+                                   "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                   "completed_at" TIMESTAMP,
+                                   PRIMARY KEY("id")
+);
+CREATE UNIQUE INDEX "idempotency_key_user_id_key_udx"
+    ON "idempotency_key" ("user_id", "key");
+CREATE INDEX "idempotency_key_created_at_idx"
+    ON "idempotency_key" ("created_at");
+
+ALTER TABLE "idempotency_key"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "idempotency_key_created_at_idx";
+DROP INDEX "idempotency_key_user_id_key_udx";
+DROP TABLE "idempotency_key";
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Уровень пользователя, по которому выбираются политики списаний
+ALTER TABLE "user"
+    ADD COLUMN "tier" TEXT NOT NULL DEFAULT 'standard';
+
+CREATE INDEX "withdrawal_user_id_created_at_idx"
+    ON "withdrawal" ("user_id", "created_at");
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "withdrawal_user_id_created_at_idx";
+ALTER TABLE "user"
+    DROP COLUMN "tier";
+-- +goose StatementEnd
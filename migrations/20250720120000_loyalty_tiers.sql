@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Сумма от системы начислений до применения множителя уровня и сам множитель
+ALTER TABLE "accrual"
+    ADD COLUMN "base_amount" NUMERIC,
+    ADD COLUMN "multiplier" NUMERIC NOT NULL DEFAULT 1;
+
+UPDATE "accrual" SET base_amount = amount;
+
+ALTER TABLE "accrual"
+    ALTER COLUMN "base_amount" SET NOT NULL;
+
+CREATE INDEX "accrual_user_id_created_at_idx"
+    ON "accrual" ("user_id", "created_at");
+
+ALTER TABLE "user"
+    ADD COLUMN "tier_updated_at" TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "user"
+    DROP COLUMN "tier_updated_at";
+DROP INDEX "accrual_user_id_created_at_idx";
+ALTER TABLE "accrual"
+    DROP COLUMN "multiplier",
+    DROP COLUMN "base_amount";
+-- +goose StatementEnd
@@ -0,0 +1,91 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "campaign" (
+                            "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                            "name" TEXT NOT NULL,
+                            "starts_at" TIMESTAMP NOT NULL,
+                            "ends_at" TIMESTAMP NOT NULL,
+    -- Ровно одно из двух: множитель к базовому начислению (2 — двойные баллы) или фиксированный бонус за заказ
+                            "multiplier" NUMERIC,
+                            "fixed_bonus" NUMERIC,
+                            "active" BOOLEAN NOT NULL DEFAULT True,
+                            "created_by" BIGINT NOT NULL,
+                            "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                            PRIMARY KEY("id"),
+                            CHECK ("ends_at" > "starts_at"),
+                            CHECK (("multiplier" IS NULL) <> ("fixed_bonus" IS NULL))
+);
+CREATE INDEX "campaign_active_period_idx"
+    ON "campaign" ("starts_at", "ends_at") WHERE "active";
+
+-- Участники кампании по уровню или поимённо. Кампания без записей в обеих таблицах действует для всех
+CREATE TABLE "campaign_tier" (
+                                 "campaign_id" BIGINT NOT NULL,
+                                 "tier" TEXT NOT NULL,
+                                 PRIMARY KEY("campaign_id", "tier")
+);
+
+CREATE TABLE "campaign_user" (
+                                 "campaign_id" BIGINT NOT NULL,
+                                 "user_id" BIGINT NOT NULL,
+                                 PRIMARY KEY("campaign_id", "user_id")
+);
+
+CREATE TABLE "campaign_bonus" (
+                                  "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                  "campaign_id" BIGINT NOT NULL,
+                                  "order_id" BIGINT NOT NULL,
+                                  "user_id" BIGINT NOT NULL,
+                                  "accrual_id" BIGINT NOT NULL,
+                                  "amount" NUMERIC NOT NULL,
+                                  "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                  PRIMARY KEY("id")
+);
+CREATE UNIQUE INDEX "campaign_bonus_campaign_id_order_id_udx"
+    ON "campaign_bonus" ("campaign_id", "order_id");
+CREATE INDEX "campaign_bonus_user_id_idx"
+    ON "campaign_bonus" ("user_id");
+
+ALTER TABLE "campaign"
+    ADD FOREIGN KEY("created_by") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "campaign_tier"
+    ADD FOREIGN KEY("campaign_id") REFERENCES "campaign"("id")
+        ON UPDATE NO ACTION ON DELETE CASCADE;
+
+ALTER TABLE "campaign_user"
+    ADD FOREIGN KEY("campaign_id") REFERENCES "campaign"("id")
+        ON UPDATE NO ACTION ON DELETE CASCADE;
+
+ALTER TABLE "campaign_user"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "campaign_bonus"
+    ADD FOREIGN KEY("campaign_id") REFERENCES "campaign"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "campaign_bonus"
+    ADD FOREIGN KEY("order_id") REFERENCES "order"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "campaign_bonus"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "campaign_bonus"
+    ADD FOREIGN KEY("accrual_id") REFERENCES "accrual"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "campaign_bonus_user_id_idx";
+DROP INDEX "campaign_bonus_campaign_id_order_id_udx";
+DROP TABLE "campaign_bonus";
+DROP TABLE "campaign_user";
+DROP TABLE "campaign_tier";
+DROP INDEX "campaign_active_period_idx";
+DROP TABLE "campaign";
+-- +goose StatementEnd
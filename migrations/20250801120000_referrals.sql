@@ -0,0 +1,64 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Реферальный код выдаётся каждому пользователю при регистрации, существующим пользователям — здесь
+ALTER TABLE "user"
+    ADD COLUMN "referral_code" TEXT;
+CREATE UNIQUE INDEX "user_referral_code_udx"
+    ON "user" ("referral_code");
+-- Коды из того же алфавита, что и при регистрации; при совпадении код генерируется заново
+DO $$
+DECLARE
+    target_id BIGINT;
+    code TEXT;
+BEGIN
+    FOR target_id IN SELECT id FROM "user" WHERE referral_code IS NULL LOOP
+        LOOP
+            SELECT string_agg(substr('ABCDEFGHJKLMNPQRSTUVWXYZ23456789', 1 + floor(random() * 32)::INT, 1), '')
+                INTO code
+                FROM generate_series(1, 8);
+            EXIT WHEN NOT EXISTS (SELECT 1 FROM "user" WHERE referral_code = code);
+        END LOOP;
+        UPDATE "user" SET referral_code = code WHERE id = target_id;
+    END LOOP;
+END
+$$;
+ALTER TABLE "user"
+    ALTER COLUMN "referral_code" SET NOT NULL;
+
+-- Приглашённый может иметь только одного пригласившего. Бонусы фиксируются при первом обработанном заказе
+CREATE TABLE "referral" (
+                            "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                            "referrer_id" BIGINT NOT NULL,
+                            "referee_id" BIGINT NOT NULL UNIQUE,
+                            "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                            "order_id" BIGINT,
+                            "referrer_bonus" NUMERIC,
+                            "referee_bonus" NUMERIC,
+                            "rewarded_at" TIMESTAMP,
+                            PRIMARY KEY("id"),
+                            CHECK ("referrer_id" <> "referee_id")
+);
+CREATE INDEX "referral_referrer_id_idx"
+    ON "referral" ("referrer_id");
+
+ALTER TABLE "referral"
+    ADD FOREIGN KEY("referrer_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "referral"
+    ADD FOREIGN KEY("referee_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "referral"
+    ADD FOREIGN KEY("order_id") REFERENCES "order"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "referral_referrer_id_idx";
+DROP TABLE "referral";
+DROP INDEX "user_referral_code_udx";
+ALTER TABLE "user"
+    DROP COLUMN "referral_code";
+-- +goose StatementEnd
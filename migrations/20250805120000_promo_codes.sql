@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "promo_code" (
+                              "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+    -- Код хранится в верхнем регистре
+                              "code" TEXT NOT NULL UNIQUE,
+                              "amount" NUMERIC NOT NULL,
+    -- Общее число активаций, NULL — без ограничения
+                              "max_uses" INTEGER,
+                              "uses" INTEGER NOT NULL DEFAULT 0,
+                              "expires_at" TIMESTAMP,
+                              "active" BOOLEAN NOT NULL DEFAULT True,
+                              "created_by" BIGINT NOT NULL,
+                              "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                              PRIMARY KEY("id"),
+                              CHECK ("amount" > 0),
+                              CHECK ("max_uses" IS NULL OR "uses" <= "max_uses")
+);
+
+-- Каждый пользователь активирует код не больше одного раза
+CREATE TABLE "promo_redemption" (
+                                    "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                    "promo_code_id" BIGINT NOT NULL,
+                                    "user_id" BIGINT NOT NULL,
+                                    "amount" NUMERIC NOT NULL,
+                                    "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                    PRIMARY KEY("id")
+);
+CREATE UNIQUE INDEX "promo_redemption_promo_code_id_user_id_udx"
+    ON "promo_redemption" ("promo_code_id", "user_id");
+CREATE INDEX "promo_redemption_user_id_idx"
+    ON "promo_redemption" ("user_id");
+
+ALTER TABLE "promo_code"
+    ADD FOREIGN KEY("created_by") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "promo_redemption"
+    ADD FOREIGN KEY("promo_code_id") REFERENCES "promo_code"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "promo_redemption"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "promo_redemption_user_id_idx";
+DROP INDEX "promo_redemption_promo_code_id_user_id_udx";
+DROP TABLE "promo_redemption";
+DROP TABLE "promo_code";
+-- +goose StatementEnd
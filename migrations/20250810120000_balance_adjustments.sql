@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Ручные корректировки баланса администраторами. Сумма со знаком: положительная начисляет, отрицательная списывает
+CREATE TABLE "balance_adjustment" (
+                                      "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                                      "user_id" BIGINT NOT NULL,
+                                      "amount" NUMERIC NOT NULL,
+                                      "reason_code" TEXT NOT NULL,
+                                      "comment" TEXT NOT NULL,
+    -- Списание сверх доступного остатка по явному указанию администратора
+                                      "forced" BOOLEAN NOT NULL DEFAULT False,
+                                      "created_by" BIGINT NOT NULL,
+                                      "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                      PRIMARY KEY("id"),
+                                      CHECK ("amount" <> 0)
+);
+CREATE INDEX "balance_adjustment_user_id_idx"
+    ON "balance_adjustment" ("user_id");
+
+ALTER TABLE "balance_adjustment"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "balance_adjustment"
+    ADD FOREIGN KEY("created_by") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "balance_adjustment_user_id_idx";
+DROP TABLE "balance_adjustment";
+-- +goose StatementEnd
@@ -0,0 +1,58 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Баллы хранятся в именованных кошельках, смешивать баллы разных кошельков нельзя.
+-- Все существующие данные переезжают в основной кошелёк bonus
+ALTER TABLE "user-balance"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+ALTER TABLE "user-balance"
+    DROP CONSTRAINT "user-balance_user_id_key";
+CREATE UNIQUE INDEX "user-balance_user_id_wallet_udx"
+    ON "user-balance" ("user_id", "wallet");
+
+ALTER TABLE "ledger_transaction"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+
+ALTER TABLE "points_lot"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+DROP INDEX "points_lot_user_id_expires_at_live_idx";
+CREATE INDEX "points_lot_user_id_wallet_expires_at_live_idx"
+    ON "points_lot" ("user_id", "wallet", "expires_at") WHERE "remaining" > 0;
+
+-- Кошелёк, в который зачисляются баллы за заказ
+ALTER TABLE "order"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+
+ALTER TABLE "accrual"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+
+ALTER TABLE "withdrawal"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+
+ALTER TABLE "withdrawal_hold"
+    ADD COLUMN "wallet" TEXT NOT NULL DEFAULT 'bonus';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "withdrawal_hold"
+    DROP COLUMN "wallet";
+ALTER TABLE "withdrawal"
+    DROP COLUMN "wallet";
+ALTER TABLE "accrual"
+    DROP COLUMN "wallet";
+ALTER TABLE "order"
+    DROP COLUMN "wallet";
+DROP INDEX "points_lot_user_id_wallet_expires_at_live_idx";
+CREATE INDEX "points_lot_user_id_expires_at_live_idx"
+    ON "points_lot" ("user_id", "expires_at") WHERE "remaining" > 0;
+ALTER TABLE "points_lot"
+    DROP COLUMN "wallet";
+ALTER TABLE "ledger_transaction"
+    DROP COLUMN "wallet";
+DELETE FROM "user-balance" WHERE "wallet" <> 'bonus';
+DROP INDEX "user-balance_user_id_wallet_udx";
+ALTER TABLE "user-balance"
+    ADD CONSTRAINT "user-balance_user_id_key" UNIQUE ("user_id");
+ALTER TABLE "user-balance"
+    DROP COLUMN "wallet";
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- История списаний отдаётся от новых к старым с курсором по (created_at, id),
+-- id в индексе делает порядок и курсор однозначными при совпадающем времени
+DROP INDEX "withdrawal_user_id_created_at_idx";
+CREATE INDEX "withdrawal_user_id_created_at_id_idx"
+    ON "withdrawal" ("user_id", "created_at", "id");
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "withdrawal_user_id_created_at_id_idx";
+CREATE INDEX "withdrawal_user_id_created_at_idx"
+    ON "withdrawal" ("user_id", "created_at");
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Сессия входа: короткоживущий access-токен ссылается на неё, refresh-токен меняется при каждом обновлении
+CREATE TABLE "session" (
+                           "id" BIGINT NOT NULL UNIQUE GENERATED BY DEFAULT AS IDENTITY,
+                           "user_id" BIGINT NOT NULL,
+    -- sha256 от текущего refresh-токена, сам токен не хранится
+                           "refresh_token_hash" TEXT NOT NULL,
+                           "expires_at" TIMESTAMP NOT NULL,
+                           "created_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                           "refreshed_at" TIMESTAMP,
+                           "revoked_at" TIMESTAMP,
+                           PRIMARY KEY("id")
+);
+CREATE INDEX "session_user_id_active_idx"
+    ON "session" ("user_id") WHERE "revoked_at" IS NULL;
+CREATE INDEX "session_expires_at_idx"
+    ON "session" ("expires_at");
+
+-- Хеши уже заменённых refresh-токенов сессии: предъявление одного из них означает утечку токена
+CREATE TABLE "session_rotated_token" (
+                                         "session_id" BIGINT NOT NULL,
+                                         "token_hash" TEXT NOT NULL,
+                                         "rotated_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+                                         PRIMARY KEY("session_id", "token_hash")
+);
+
+ALTER TABLE "session"
+    ADD FOREIGN KEY("user_id") REFERENCES "user"("id")
+        ON UPDATE NO ACTION ON DELETE NO ACTION;
+
+ALTER TABLE "session_rotated_token"
+    ADD FOREIGN KEY("session_id") REFERENCES "session"("id")
+        ON UPDATE NO ACTION ON DELETE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "session_rotated_token";
+DROP INDEX "session_expires_at_idx";
+DROP INDEX "session_user_id_active_idx";
+DROP TABLE "session";
+-- +goose StatementEnd